@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/my-username/billion-user-app/event-pipelines/analytics-consumer/internal/analytics"
+	"github.com/my-username/billion-user-app/pkg/config"
+	"github.com/my-username/billion-user-app/pkg/kafkaclient"
+	"github.com/my-username/billion-user-app/pkg/logger"
+)
+
+// How often the aggregated event counts are logged
+const reportInterval = time.Minute
+
+func main() {
+	cfg, err := config.LoadConfig("../../.env")
+	if err != nil {
+		log.Fatal("Failed to load config:", err)
+	}
+
+	appLogger := logger.New("analytics-consumer")
+	appLogger.Info().Msg("Starting analytics consumer")
+
+	// Without Kafka, consume from a file-backed in-process bus instead, which
+	// receives whatever is published to it from this process
+	var publisher kafkaclient.Publisher
+	var subscriber kafkaclient.Subscriber
+	if strings.TrimSpace(cfg.KafkaBrokers) == "" {
+		busDir := filepath.Join(cfg.EventBusDir, "analytics-consumer")
+		bus, err := kafkaclient.OpenBus(busDir, 4)
+		if err != nil {
+			appLogger.Fatal().Err(err).Msg("Failed to open event bus")
+		}
+		appLogger.Warn().Str("dir", busDir).Msg("No Kafka brokers configured, consuming from the local event bus")
+		publisher, subscriber = bus, bus
+	} else {
+		brokers := strings.Split(cfg.KafkaBrokers, ",")
+		kafkaClient, err := kafkaclient.NewClient(brokers)
+		if err != nil {
+			appLogger.Fatal().Err(err).Msg("Failed to connect to Kafka")
+		}
+		publisher, subscriber = kafkaClient, kafkaclient.NewSubscriber(brokers)
+	}
+	defer publisher.Close()
+
+	// Events that keep failing are retried after a minute and ten minutes,
+	// then dead-lettered for the dlq tool
+	consumer, err := kafkaclient.NewConsumer(kafkaclient.ConsumerConfig{
+		GroupID:     "analytics-consumer",
+		Subscriber:  subscriber,
+		Concurrency: 4,
+		FromOldest:  true,
+		DeadLetter: &kafkaclient.DeadLetterConfig{
+			Publisher:   publisher,
+			RetryDelays: []time.Duration{time.Minute, 10 * time.Minute},
+		},
+	})
+	if err != nil {
+		appLogger.Fatal().Err(err).Msg("Failed to connect to Kafka")
+	}
+
+	counter := analytics.NewCounter(appLogger)
+	counter.Register(consumer)
+
+	// Stop on SIGINT or SIGTERM, letting in-flight messages finish
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		ticker := time.NewTicker(reportInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				counter.Report()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	if err := consumer.Run(ctx); err != nil {
+		appLogger.Fatal().Err(err).Msg("Consumer stopped")
+	}
+	if err := consumer.Close(); err != nil {
+		appLogger.Error().Err(err).Msg("Failed to leave consumer group")
+	}
+	counter.Report()
+	appLogger.Info().Msg("Analytics consumer stopped")
+}
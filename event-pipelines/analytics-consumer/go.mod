@@ -2,3 +2,41 @@ module github.com/my-username/billion-user-app/event-pipelines/analytics-consume
 
 go 1.21.0
 
+require (
+	github.com/my-username/billion-user-app/pkg/config v0.0.0
+	github.com/my-username/billion-user-app/pkg/kafkaclient v0.0.0
+	github.com/my-username/billion-user-app/pkg/logger v0.0.0
+	github.com/rs/zerolog v1.32.0
+)
+
+require (
+	github.com/IBM/sarama v1.42.1 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/eapache/go-resiliency v1.4.0 // indirect
+	github.com/eapache/go-xerial-snappy v0.0.0-20230731223053-c322873962e3 // indirect
+	github.com/eapache/queue v1.1.0 // indirect
+	github.com/golang/snappy v0.0.4 // indirect
+	github.com/hashicorp/errwrap v1.0.0 // indirect
+	github.com/hashicorp/go-multierror v1.1.1 // indirect
+	github.com/hashicorp/go-uuid v1.0.3 // indirect
+	github.com/jcmturner/aescts/v2 v2.0.0 // indirect
+	github.com/jcmturner/dnsutils/v2 v2.0.0 // indirect
+	github.com/jcmturner/gofork v1.7.6 // indirect
+	github.com/jcmturner/gokrb5/v8 v8.4.4 // indirect
+	github.com/jcmturner/rpc/v2 v2.0.3 // indirect
+	github.com/joho/godotenv v1.5.1 // indirect
+	github.com/klauspost/compress v1.17.0 // indirect
+	github.com/mattn/go-colorable v0.1.13 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/pierrec/lz4/v4 v4.1.18 // indirect
+	github.com/rcrowley/go-metrics v0.0.0-20201227073835-cf1acfcdf475 // indirect
+	golang.org/x/crypto v0.18.0 // indirect
+	golang.org/x/net v0.17.0 // indirect
+	golang.org/x/sys v0.16.0 // indirect
+)
+
+replace (
+	github.com/my-username/billion-user-app/pkg/config => ../../pkg/config
+	github.com/my-username/billion-user-app/pkg/kafkaclient => ../../pkg/kafkaclient
+	github.com/my-username/billion-user-app/pkg/logger => ../../pkg/logger
+)
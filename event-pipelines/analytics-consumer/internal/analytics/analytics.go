@@ -0,0 +1,86 @@
+// Package analytics aggregates the platform's domain events into counts that
+// are periodically reported
+package analytics
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/my-username/billion-user-app/pkg/kafkaclient"
+	"github.com/rs/zerolog"
+)
+
+// Counter counts events by name since the last report
+type Counter struct {
+	logger zerolog.Logger
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// NewCounter creates a Counter reporting to logger
+func NewCounter(logger zerolog.Logger) *Counter {
+	return &Counter{logger: logger, counts: make(map[string]int)}
+}
+
+// Register subscribes the counter to the events it aggregates
+func (c *Counter) Register(consumer *kafkaclient.Consumer) {
+	consumer.Handle("user.created", kafkaclient.JSONHandler(func(_ context.Context, e kafkaclient.UserCreatedEvent) error {
+		c.logger.Info().Uint64("user_id", e.UserID).Msg("User created")
+		c.add("user.created")
+		return nil
+	}))
+	consumer.Handle("user.updated", kafkaclient.JSONHandler(func(_ context.Context, _ kafkaclient.UserUpdatedEvent) error {
+		c.add("user.updated")
+		return nil
+	}))
+	consumer.Handle("product.created", kafkaclient.JSONHandler(func(_ context.Context, e kafkaclient.ProductCreatedEvent) error {
+		c.logger.Info().Uint64("product_id", e.ProductID).Float64("price", e.Price).Msg("Product created")
+		c.add("product.created")
+		return nil
+	}))
+	consumer.Handle("task.created", kafkaclient.JSONHandler(func(_ context.Context, e kafkaclient.TaskCreatedEvent) error {
+		c.logger.Info().Uint64("task_id", e.TaskID).Uint64("user_id", e.UserID).Msg("Task created")
+		c.add("task.created")
+		return nil
+	}))
+	consumer.Handle("auth.security", kafkaclient.JSONHandler(func(_ context.Context, e kafkaclient.AuthSecurityEvent) error {
+		c.logger.Warn().Uint64("user_id", e.UserID).Str("type", e.Type).Str("reason", e.Reason).Msg("Security event")
+		c.add("auth.security." + e.Type)
+		return nil
+	}))
+	consumer.Handle("auth.audit", kafkaclient.JSONHandler(func(_ context.Context, e kafkaclient.AuthAuditEvent) error {
+		c.add(e.Action + "." + e.Outcome)
+		return nil
+	}))
+}
+
+// Report logs the counts since the last report and resets them
+func (c *Counter) Report() {
+	c.mu.Lock()
+	counts := c.counts
+	c.counts = make(map[string]int)
+	c.mu.Unlock()
+
+	if len(counts) == 0 {
+		return
+	}
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	event := c.logger.Info()
+	for _, name := range names {
+		event = event.Int(name, counts[name])
+	}
+	event.Msg("Event counts")
+}
+
+func (c *Counter) add(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[name]++
+}
@@ -3,6 +3,8 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -27,9 +29,74 @@ type Config struct {
 
 	// --- Messaging (Kafka) ---
 	KafkaBrokers string
+	// With KafkaBrokers empty, events go to a file-backed in-process bus
+	// under this directory instead, one subdirectory per process
+	EventBusDir string
 
 	// --- Auth (JWT) ---
-	JWTSecret string
+	JWTSecret string // Shared HS256 secret, used when no asymmetric keys are configured
+
+	// auth-service: directory of PEM private keys (file name = kid). When set,
+	// tokens are signed with the active key instead of JWTSecret.
+	JWTKeysDir     string
+	JWTActiveKeyID string // Optional; defaults to the greatest kid in JWTKeysDir
+
+	// Other services: auth-service's JWKS endpoint. When set, tokens are
+	// verified with the published public keys instead of JWTSecret.
+	JWKSURL string
+	// Other services: auth-service's API key exchange endpoint. When set,
+	// API keys are accepted alongside Bearer JWTs.
+	APIKeyExchangeURL string
+
+	// auth-service: HMAC key for single-use tokens (email verification,
+	// password reset, MFA challenges). Never shared with other services.
+	OneTimeTokenSecret       string
+	RequireEmailVerification bool
+	AppBaseURL               string // Frontend URL used to build links in emails
+	OIDCIssuer               string // Public base URL of auth-service as an OpenID provider
+
+	// auth-service: password policy for new passwords, and an optional
+	// directory of breached SHA-1 hashes split by 5-character prefix
+	PasswordMinLength    int
+	PasswordMinClasses   int
+	PasswordHistorySize  int
+	BreachedPasswordsDir string
+
+	// auth-service: algorithm for new password hashes, "bcrypt" or
+	// "argon2id", and its cost. Existing hashes are upgraded on login.
+	PasswordHasher    string
+	BcryptCost        int
+	Argon2MemoryKiB   int
+	Argon2Iterations  int
+	Argon2Parallelism int
+
+	// auth-service: WebAuthn relying party for passkeys, enabled by setting
+	// the RP ID. Origins default to AppBaseURL.
+	WebAuthnRPID    string
+	WebAuthnRPName  string
+	WebAuthnOrigins []string
+
+	// auth-service: external OpenID providers users can sign in with, listed
+	// by name in OIDC_PROVIDERS and configured with OIDC_PROVIDER_<NAME>_*
+	OIDCProviders []OIDCProvider
+
+	// --- Email ---
+	MailerDriver  string // "smtp", "file" or "memory"
+	MailFrom      string
+	MailOutputDir string // Used by the file driver
+	SMTPHost      string
+	SMTPPort      string
+	SMTPUsername  string
+	SMTPPassword  string
+}
+
+// OIDCProvider configures an external OpenID provider
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string // Defaults to "openid email profile"
 }
 
 // LoadConfig loads configuration from environment variables
@@ -62,12 +129,86 @@ func LoadConfig(envPath ...string) (*Config, error) {
 		DBSslMode:    getEnv("DB_SSLMODE", "disable"),
 		RedisAddress: getEnv("REDIS_ADDRESS", "localhost:6379"),
 		KafkaBrokers: getEnv("KAFKA_BROKERS", "localhost:9092"),
+		EventBusDir:  getEnv("EVENT_BUS_DIR", "data/events"),
 		JWTSecret:    getEnv("JWT_SECRET", "super-secret-key"),
+
+		JWTKeysDir:     getEnv("JWT_KEYS_DIR", ""),
+		JWTActiveKeyID: getEnv("JWT_ACTIVE_KID", ""),
+		JWKSURL:        getEnv("JWKS_URL", ""),
+
+		APIKeyExchangeURL: getEnv("API_KEY_EXCHANGE_URL", ""),
+
+		OneTimeTokenSecret:       getEnv("ONE_TIME_TOKEN_SECRET", "super-secret-one-time-key"),
+		RequireEmailVerification: getEnv("REQUIRE_EMAIL_VERIFICATION", "false") == "true",
+		AppBaseURL:               getEnv("APP_BASE_URL", "http://localhost:3000"),
+		OIDCIssuer:               getEnv("OIDC_ISSUER", "http://localhost:3001"),
+		OIDCProviders:            loadOIDCProviders(),
+
+		WebAuthnRPID:   getEnv("WEBAUTHN_RP_ID", ""),
+		WebAuthnRPName: getEnv("WEBAUTHN_RP_NAME", "Billion User App"),
+
+		PasswordMinLength:    getEnvInt("PASSWORD_MIN_LENGTH", 8),
+		PasswordMinClasses:   getEnvInt("PASSWORD_MIN_CLASSES", 2),
+		PasswordHistorySize:  getEnvInt("PASSWORD_HISTORY_SIZE", 5),
+		BreachedPasswordsDir: getEnv("BREACHED_PASSWORDS_DIR", ""),
+
+		PasswordHasher:    getEnv("PASSWORD_HASHER", "bcrypt"),
+		BcryptCost:        getEnvInt("BCRYPT_COST", 10),
+		Argon2MemoryKiB:   getEnvInt("ARGON2_MEMORY_KIB", 19*1024),
+		Argon2Iterations:  getEnvInt("ARGON2_ITERATIONS", 2),
+		Argon2Parallelism: getEnvInt("ARGON2_PARALLELISM", 1),
+
+		MailerDriver:  getEnv("MAILER_DRIVER", "file"),
+		MailFrom:      getEnv("MAIL_FROM", "no-reply@localhost"),
+		MailOutputDir: getEnv("MAIL_OUTPUT_DIR", "./tmp/mail"),
+		SMTPHost:      getEnv("SMTP_HOST", "localhost"),
+		SMTPPort:      getEnv("SMTP_PORT", "587"),
+		SMTPUsername:  getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:  getEnv("SMTP_PASSWORD", ""),
+	}
+
+	for _, origin := range strings.Split(getEnv("WEBAUTHN_ORIGINS", cfg.AppBaseURL), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			cfg.WebAuthnOrigins = append(cfg.WebAuthnOrigins, origin)
+		}
 	}
 
 	return cfg, nil
 }
 
+// loadOIDCProviders reads the providers named in OIDC_PROVIDERS, e.g.
+// "google,corp" with OIDC_PROVIDER_GOOGLE_ISSUER, _CLIENT_ID, _CLIENT_SECRET
+// and optionally _SCOPES for each
+func loadOIDCProviders() []OIDCProvider {
+	var providers []OIDCProvider
+	for _, name := range strings.Split(getEnv("OIDC_PROVIDERS", ""), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_PROVIDER_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		providers = append(providers, OIDCProvider{
+			Name:         name,
+			Issuer:       getEnv(prefix+"ISSUER", ""),
+			ClientID:     getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret: getEnv(prefix+"CLIENT_SECRET", ""),
+			Scopes:       strings.Fields(getEnv(prefix+"SCOPES", "openid email profile")),
+		})
+	}
+	return providers
+}
+
+// getEnvInt reads an integer environment variable, falling back to the
+// default when it is unset or malformed
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(getEnv(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
 // getEnv is a helper to read an environment variable or return a default value
 func getEnv(key, fallback string) string {
 	if value, exists := os.LookupEnv(key); exists {
@@ -0,0 +1,154 @@
+package jwtutils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIKeyPrefix starts every API key issued by auth-service, so keys can be
+// told apart from JWTs in an Authorization header
+const APIKeyPrefix = "bua_"
+
+// IsAPIKey reports whether token is an API key rather than a JWT
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// APIKeyExchanger trades an API key for a short-lived access token. Forget
+// drops any cached token for the key, e.g. after it was revoked.
+type APIKeyExchanger interface {
+	Exchange(key string) (string, error)
+	Forget(key string)
+}
+
+// SetAPIKeyExchanger makes ValidateToken accept API keys
+func (m *JWTManager) SetAPIKeyExchanger(exchanger APIKeyExchanger) {
+	m.apiKeys = exchanger
+}
+
+func (m *JWTManager) validateAPIKey(key string) (*Claims, error) {
+	if m.apiKeys == nil {
+		return nil, ErrInvalidToken
+	}
+
+	token, err := m.apiKeys.Exchange(key)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	claims, err := m.ValidateToken(token)
+	if err != nil {
+		// Exchange again on the next request; that fails if the key itself
+		// was revoked
+		m.apiKeys.Forget(key)
+		return nil, err
+	}
+	return claims, nil
+}
+
+// RemoteAPIKeyExchanger exchanges API keys at auth-service and caches the
+// resulting access tokens until shortly before they expire, so a key costs
+// one round trip per token lifetime rather than one per request
+type RemoteAPIKeyExchanger struct {
+	url    string
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[string]exchangedToken
+}
+
+type exchangedToken struct {
+	token   string
+	expires time.Time
+}
+
+const (
+	// Cached tokens are dropped this long before they expire
+	exchangeExpiryMargin = 30 * time.Second
+	// Sweep expired tokens once the cache grows past this many entries
+	maxExchangedTokens = 10000
+)
+
+// NewRemoteAPIKeyExchanger creates an exchanger for auth-service's exchange
+// endpoint at url
+func NewRemoteAPIKeyExchanger(url string) *RemoteAPIKeyExchanger {
+	return &RemoteAPIKeyExchanger{
+		url:    url,
+		client: &http.Client{Timeout: 5 * time.Second},
+		cache:  make(map[string]exchangedToken),
+	}
+}
+
+// Exchange implements APIKeyExchanger
+func (e *RemoteAPIKeyExchanger) Exchange(key string) (string, error) {
+	id := cacheID(key)
+
+	e.mu.Lock()
+	cached, ok := e.cache[id]
+	e.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.token, nil
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to exchange API key: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", ErrInvalidToken
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to decode API key exchange: %w", err)
+	}
+
+	expires := time.Now().Add(time.Duration(body.ExpiresIn)*time.Second - exchangeExpiryMargin)
+	e.remember(id, exchangedToken{token: body.AccessToken, expires: expires})
+	return body.AccessToken, nil
+}
+
+// Forget implements APIKeyExchanger
+func (e *RemoteAPIKeyExchanger) Forget(key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.cache, cacheID(key))
+}
+
+func (e *RemoteAPIKeyExchanger) remember(id string, token exchangedToken) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.cache) >= maxExchangedTokens {
+		now := time.Now()
+		for k, t := range e.cache {
+			if now.After(t.expires) {
+				delete(e.cache, k)
+			}
+		}
+	}
+	e.cache[id] = token
+}
+
+// cacheID avoids keeping raw API keys in memory longer than needed
+func cacheID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,6 +1,10 @@
 package jwtutils
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
 	"errors"
 	"time"
 
@@ -10,23 +14,110 @@ import (
 var (
 	ErrInvalidToken = errors.New("invalid token")
 	ErrExpiredToken = errors.New("token has expired")
+	ErrRevokedToken = errors.New("token has been revoked")
 )
 
-// Claims represents JWT claims
+// Token types set in the "typ" header. Access tokens use the RFC 9068 type so
+// that ID tokens, which are signed with the same keys, are never accepted as
+// access tokens.
+const (
+	TypeAccessToken = "at+jwt"
+	TypeIDToken     = "JWT"
+)
+
+// RevocationChecker reports whether a validly signed token has been revoked
+// before its expiry, e.g. by logout or account deactivation. Implementations
+// decide how to behave when their backing store is unavailable.
+type RevocationChecker interface {
+	IsRevoked(claims *Claims) bool
+}
+
+// Claims represents JWT claims. User tokens identify an end user by UserID;
+// service tokens (PrincipalType PrincipalService) identify an OAuth2 client
+// by ClientID and have no user.
 type Claims struct {
-	UserID   uint64 `json:"user_id"`
-	Email    string `json:"email"`
-	Username string `json:"username"`
+	UserID        uint64   `json:"user_id"`
+	Email         string   `json:"email"`
+	Username      string   `json:"username"`
+	Roles         []string `json:"roles,omitempty"`
+	Permissions   []string `json:"permissions,omitempty"`
+	SessionID     string   `json:"sid,omitempty"`    // Login session the token belongs to
+	Scopes        []string `json:"scopes,omitempty"` // Set for machine clients, see HasScope
+	PrincipalType string   `json:"principal_type,omitempty"`
+	ClientID      string   `json:"client_id,omitempty"`
+	Actor         *Actor   `json:"act,omitempty"` // Set while an administrator impersonates the user
 	jwt.RegisteredClaims
 }
 
-// JWTManager handles JWT operations
+// Actor is the party actually using a token issued for another user, as in
+// the "act" claim of RFC 8693 section 4.1
+type Actor struct {
+	UserID   uint64 `json:"user_id"`
+	Username string `json:"username,omitempty"`
+}
+
+// TokenOption customizes the claims of a generated token
+type TokenOption func(*Claims)
+
+// WithSessionID binds the token to a login session, so that revoking the
+// session also invalidates the token
+func WithSessionID(sessionID string) TokenOption {
+	return func(c *Claims) {
+		c.SessionID = sessionID
+	}
+}
+
+// WithScopes restricts the token to the given scopes
+func WithScopes(scopes []string) TokenOption {
+	return func(c *Claims) {
+		c.Scopes = scopes
+	}
+}
+
+// WithClientID records the OAuth2 client a user token was issued to
+func WithClientID(clientID string) TokenOption {
+	return func(c *Claims) {
+		c.ClientID = clientID
+	}
+}
+
+// WithActor marks the token as used by actor on behalf of its user
+func WithActor(actor Actor) TokenOption {
+	return func(c *Claims) {
+		c.Actor = &actor
+	}
+}
+
+// WithLifetime overrides the lifetime of the token
+func WithLifetime(lifetime time.Duration) TokenOption {
+	return func(c *Claims) {
+		if c.IssuedAt != nil {
+			c.ExpiresAt = jwt.NewNumericDate(c.IssuedAt.Add(lifetime))
+		}
+	}
+}
+
+// TokenDuration returns the lifetime of generated tokens
+func (m *JWTManager) TokenDuration() time.Duration {
+	return m.tokenDuration
+}
+
+// JWTManager handles JWT operations.
+// It either uses a shared HS256 secret (legacy), signs with an asymmetric
+// KeySet (auth-service), or only verifies against a KeyProvider such as a
+// RemoteKeySet (every other service).
 type JWTManager struct {
 	secretKey     string
+	signingKeys   *KeySet
+	keys          KeyProvider
+	revocations   RevocationChecker
+	apiKeys       APIKeyExchanger
+	audience      string
 	tokenDuration time.Duration
 }
 
-// NewJWTManager creates a new JWT manager
+// NewJWTManager creates a JWT manager that signs and verifies with a shared
+// HS256 secret
 func NewJWTManager(secretKey string, tokenDuration time.Duration) *JWTManager {
 	return &JWTManager{
 		secretKey:     secretKey,
@@ -34,31 +125,153 @@ func NewJWTManager(secretKey string, tokenDuration time.Duration) *JWTManager {
 	}
 }
 
-// GenerateToken generates a new JWT token for a user
-func (m *JWTManager) GenerateToken(userID uint64, email, username string) (string, error) {
+// NewSigningJWTManager creates a JWT manager that signs with the active key
+// of keys and verifies with any key in the set
+func NewSigningJWTManager(keys *KeySet, tokenDuration time.Duration) *JWTManager {
+	return &JWTManager{
+		signingKeys:   keys,
+		keys:          keys,
+		tokenDuration: tokenDuration,
+	}
+}
+
+// NewVerifyingJWTManager creates a JWT manager that can only validate tokens,
+// using public keys resolved by keys
+func NewVerifyingJWTManager(keys KeyProvider) *JWTManager {
+	return &JWTManager{keys: keys}
+}
+
+// SetAudience makes ValidateToken reject tokens addressed to other audiences.
+// Tokens without an audience, such as user tokens, are still accepted.
+func (m *JWTManager) SetAudience(audience string) {
+	m.audience = audience
+}
+
+// SetRevocationChecker makes ValidateToken reject tokens reported as revoked
+// by checker
+func (m *JWTManager) SetRevocationChecker(checker RevocationChecker) {
+	m.revocations = checker
+}
+
+// JWKS returns the public keys this manager signs with. It is empty for
+// managers that do not sign with a KeySet.
+func (m *JWTManager) JWKS() JWKS {
+	if m.signingKeys == nil {
+		return JWKS{Keys: []JWK{}}
+	}
+	return m.signingKeys.JWKS()
+}
+
+// GenerateToken generates a new JWT token for a user, embedding the roles and
+// permissions granted to them by auth-service
+func (m *JWTManager) GenerateToken(userID uint64, email, username string, roles, permissions []string, opts ...TokenOption) (string, error) {
 	claims := &Claims{
-		UserID:   userID,
-		Email:    email,
-		Username: username,
+		UserID:        userID,
+		Email:         email,
+		Username:      username,
+		Roles:         roles,
+		Permissions:   permissions,
+		PrincipalType: PrincipalUser,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        newTokenID(),
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(m.tokenDuration)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
 		},
 	}
+	for _, opt := range opts {
+		opt(claims)
+	}
+
+	return m.sign(claims, TypeAccessToken)
+}
 
+// GenerateServiceToken generates a token for an OAuth2 client acting on its
+// own behalf, restricted to scopes and addressed to audience
+func (m *JWTManager) GenerateServiceToken(clientID string, scopes, audience []string, opts ...TokenOption) (string, error) {
+	claims := &Claims{
+		Scopes:        scopes,
+		PrincipalType: PrincipalService,
+		ClientID:      clientID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        newTokenID(),
+			Subject:   clientID,
+			Audience:  audience,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(m.tokenDuration)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+		},
+	}
+	for _, opt := range opts {
+		opt(claims)
+	}
+
+	return m.sign(claims, TypeAccessToken)
+}
+
+// sign serializes claims with the active signing key, or the shared secret,
+// as a token of type typ
+func (m *JWTManager) sign(claims jwt.Claims, typ string) (string, error) {
+	if m.signingKeys != nil {
+		key := m.signingKeys.ActiveKey()
+		token := jwt.NewWithClaims(key.method(), claims)
+		token.Header["kid"] = key.ID
+		token.Header["typ"] = typ
+		return token.SignedString(key.PrivateKey)
+	}
+
+	if m.secretKey == "" {
+		return "", ErrNoSigningKey
+	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["typ"] = typ
 	return token.SignedString([]byte(m.secretKey))
 }
 
-// ValidateToken validates a JWT token and returns the claims
-func (m *JWTManager) ValidateToken(tokenString string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+// keyFunc resolves the verification key for a parsed token, making sure the
+// token's algorithm matches the kind of key we hold for it
+func (m *JWTManager) keyFunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		if m.keys != nil || m.secretKey == "" {
 			return nil, ErrInvalidToken
 		}
 		return []byte(m.secretKey), nil
-	})
+	}
+
+	if m.keys == nil {
+		return nil, ErrInvalidToken
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, err := m.keys.PublicKey(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	switch key.(type) {
+	case *rsa.PublicKey:
+		if token.Method != jwt.SigningMethodRS256 {
+			return nil, ErrInvalidToken
+		}
+	case ed25519.PublicKey:
+		if token.Method != jwt.SigningMethodEdDSA {
+			return nil, ErrInvalidToken
+		}
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+	return key, nil
+}
+
+// ValidateToken validates an access token and returns the claims. Other JWTs,
+// such as ID tokens, are rejected. If an APIKeyExchanger is set, API keys are
+// accepted too and validated through the access token they are exchanged for.
+func (m *JWTManager) ValidateToken(tokenString string) (*Claims, error) {
+	if IsAPIKey(tokenString) {
+		return m.validateAPIKey(tokenString)
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, m.keyFunc)
 
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
@@ -72,7 +285,24 @@ func (m *JWTManager) ValidateToken(tokenString string) (*Claims, error) {
 		return nil, ErrInvalidToken
 	}
 
+	if typ, _ := token.Header["typ"].(string); typ != TypeAccessToken {
+		return nil, ErrInvalidToken
+	}
+
+	if m.audience != "" && len(claims.Audience) > 0 && !claims.hasAudience(m.audience) {
+		return nil, ErrInvalidToken
+	}
+
+	if m.revocations != nil && m.revocations.IsRevoked(claims) {
+		return nil, ErrRevokedToken
+	}
+
 	return claims, nil
 }
 
-
+// newTokenID returns a random "jti", so individual tokens can be revoked
+func newTokenID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
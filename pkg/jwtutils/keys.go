@@ -0,0 +1,278 @@
+package jwtutils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported asymmetric signing algorithms
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+var (
+	ErrUnknownKey           = errors.New("unknown signing key")
+	ErrNoSigningKey         = errors.New("no signing key available")
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+)
+
+// KeyProvider resolves the public key used to verify a token signed with kid
+type KeyProvider interface {
+	PublicKey(kid string) (crypto.PublicKey, error)
+}
+
+// SigningKey is a private key used to sign tokens, identified by its key ID
+type SigningKey struct {
+	ID         string
+	Algorithm  string
+	PrivateKey crypto.Signer
+}
+
+// NewSigningKey wraps an RSA or Ed25519 private key
+func NewSigningKey(kid string, key crypto.Signer) (*SigningKey, error) {
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		return &SigningKey{ID: kid, Algorithm: AlgRS256, PrivateKey: key}, nil
+	case ed25519.PrivateKey:
+		return &SigningKey{ID: kid, Algorithm: AlgEdDSA, PrivateKey: key}, nil
+	}
+	return nil, ErrUnsupportedAlgorithm
+}
+
+// GenerateSigningKey creates a new random key for the given algorithm
+func GenerateSigningKey(kid, algorithm string) (*SigningKey, error) {
+	switch algorithm {
+	case AlgRS256:
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		return NewSigningKey(kid, key)
+	case AlgEdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return NewSigningKey(kid, key)
+	}
+	return nil, ErrUnsupportedAlgorithm
+}
+
+func (k *SigningKey) method() jwt.SigningMethod {
+	if k.Algorithm == AlgEdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+// KeySet holds the private keys of a token issuer. Every key in the set is
+// published in the JWKS and accepted for verification; the active key signs
+// new tokens. Keeping the previous key in the set while its tokens are still
+// alive is what makes rotation seamless.
+type KeySet struct {
+	mu     sync.RWMutex
+	keys   map[string]*SigningKey
+	active string
+
+	// Set when the keys were loaded from disk, so Reload can re-read them
+	dir       string
+	activeKID string
+}
+
+// NewKeySet creates a key set from in-memory keys. If activeKID is empty the
+// key with the greatest ID becomes active.
+func NewKeySet(keys []*SigningKey, activeKID string) (*KeySet, error) {
+	s := &KeySet{}
+	if err := s.replace(keys, activeKID); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// LoadKeySet reads every *.pem private key in dir; the file name without its
+// extension is the key ID. If activeKID is empty the greatest key ID is used,
+// so naming keys by date (e.g. "2024-06-01.pem") makes the newest one sign.
+// An empty directory is seeded with a fresh Ed25519 key.
+func LoadKeySet(dir, activeKID string) (*KeySet, error) {
+	s := &KeySet{dir: dir, activeKID: activeKID}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload re-reads the key directory, picking up added, removed or newly
+// activated keys without a restart
+func (s *KeySet) Reload() error {
+	if s.dir == "" {
+		return nil
+	}
+
+	keys, err := readKeyDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	if len(keys) == 0 {
+		key, err := GenerateSigningKey(time.Now().UTC().Format("2006-01-02T150405"), AlgEdDSA)
+		if err != nil {
+			return err
+		}
+		if err := writeKeyFile(s.dir, key); err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+
+	return s.replace(keys, s.activeKID)
+}
+
+func (s *KeySet) replace(keys []*SigningKey, activeKID string) error {
+	if len(keys) == 0 {
+		return ErrNoSigningKey
+	}
+
+	byID := make(map[string]*SigningKey, len(keys))
+	for _, k := range keys {
+		byID[k.ID] = k
+	}
+
+	active := activeKID
+	if active == "" {
+		for id := range byID {
+			if id > active {
+				active = id
+			}
+		}
+	}
+	if _, ok := byID[active]; !ok {
+		return fmt.Errorf("active key %q not found: %w", active, ErrUnknownKey)
+	}
+
+	s.mu.Lock()
+	s.keys = byID
+	s.active = active
+	s.mu.Unlock()
+	return nil
+}
+
+// ActiveKey returns the key currently used for signing
+func (s *KeySet) ActiveKey() *SigningKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.keys[s.active]
+}
+
+// PublicKey implements KeyProvider
+func (s *KeySet) PublicKey(kid string) (crypto.PublicKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	k, ok := s.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return k.PrivateKey.Public(), nil
+}
+
+// JWKS returns the public half of every key in the set
+func (s *KeySet) JWKS() JWKS {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]string, 0, len(s.keys))
+	for id := range s.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	set := JWKS{Keys: make([]JWK, 0, len(ids))}
+	for _, id := range ids {
+		k := s.keys[id]
+		jwk, err := NewJWK(k.ID, k.Algorithm, k.PrivateKey.Public())
+		if err != nil {
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+func readKeyDir(dir string) ([]*SigningKey, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, os.MkdirAll(dir, 0o700)
+		}
+		return nil, err
+	}
+
+	var keys []*SigningKey
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".pem" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		kid := strings.TrimSuffix(entry.Name(), ".pem")
+		key, err := ParsePrivateKeyPEM(kid, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key %s: %w", entry.Name(), err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func writeKeyFile(dir string, key *SigningKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key.PrivateKey)
+	if err != nil {
+		return err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	return os.WriteFile(filepath.Join(dir, key.ID+".pem"), data, 0o600)
+}
+
+// ParsePrivateKeyPEM parses a PKCS#8 (RSA or Ed25519) or PKCS#1 (RSA) key
+func ParsePrivateKeyPEM(kid string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return NewSigningKey(kid, key)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, ErrUnsupportedAlgorithm
+		}
+		return NewSigningKey(kid, signer)
+	}
+	return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+}
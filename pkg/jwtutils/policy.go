@@ -0,0 +1,160 @@
+package jwtutils
+
+import "strings"
+
+// Built-in roles. The permissions attached to each role are stored in
+// auth-service and copied into the token at issue time.
+const (
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+	RoleUser      = "user"
+)
+
+// Principal types. Tokens issued before principal types existed carry none
+// and are user tokens.
+const (
+	PrincipalUser    = "user"
+	PrincipalService = "service"
+)
+
+// Permissions checked by the services. PermAll grants every permission.
+const (
+	PermAll            = "*"
+	PermManageUsers    = "users:manage"
+	PermDeleteUsers    = "users:delete"
+	PermManageProducts = "products:manage"
+	PermManageTasks    = "tasks:manage"
+	PermManageMedia    = "media:manage"
+	PermManageRoles    = "roles:manage"
+	PermManageClients  = "clients:manage"
+	PermManageAccounts = "accounts:manage" // Deactivate accounts and reset their credentials
+	PermReadAudit      = "audit:read"      // Read auth-service's security audit log
+	PermImpersonate    = "users:impersonate"
+)
+
+// Scopes restrict tokens issued to machine clients, such as API keys, to
+// reading or writing one kind of resource. Tokens from an interactive login
+// carry no scopes and are not restricted.
+const (
+	ScopeUsersRead     = "users:read"
+	ScopeUsersWrite    = "users:write"
+	ScopeProductsRead  = "products:read"
+	ScopeProductsWrite = "products:write"
+	ScopeTasksRead     = "tasks:read"
+	ScopeTasksWrite    = "tasks:write"
+	ScopeMediaRead     = "media:read"
+	ScopeMediaWrite    = "media:write"
+)
+
+// Scopes returns every scope a machine client may be granted
+func Scopes() []string {
+	return []string{
+		ScopeUsersRead, ScopeUsersWrite,
+		ScopeProductsRead, ScopeProductsWrite,
+		ScopeTasksRead, ScopeTasksWrite,
+		ScopeMediaRead, ScopeMediaWrite,
+	}
+}
+
+// ScopeForMethod returns the scope needed to call an endpoint of resource
+// with the given HTTP method: "<resource>:read" for safe methods and
+// "<resource>:write" for everything else
+func ScopeForMethod(resource, method string) string {
+	if IsSafeMethod(method) {
+		return resource + ":read"
+	}
+	return resource + ":write"
+}
+
+// IsSafeMethod reports whether requests with the HTTP method only read data
+func IsSafeMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return true
+	}
+	return false
+}
+
+// IsImpersonated reports whether the token was issued to an administrator
+// acting as its user. Actor identifies the administrator.
+func (c *Claims) IsImpersonated() bool {
+	return c.Actor != nil
+}
+
+// AllowsMethod reports whether the token may be used for a request with the
+// given HTTP method. Impersonation tokens may only be used for safe methods,
+// so support staff viewing a customer's account cannot change their data.
+func (c *Claims) AllowsMethod(method string) bool {
+	return !c.IsImpersonated() || IsSafeMethod(method)
+}
+
+// IsService reports whether the token was issued to an OAuth2 client rather
+// than a user
+func (c *Claims) IsService() bool {
+	return c.PrincipalType == PrincipalService
+}
+
+func (c *Claims) hasAudience(audience string) bool {
+	for _, a := range c.Audience {
+		if a == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// IsScoped reports whether the token is restricted to a set of scopes
+func (c *Claims) IsScoped() bool {
+	return len(c.Scopes) > 0
+}
+
+// HasScope reports whether the token may be used for scope. Unscoped tokens
+// may be used for anything their permissions allow.
+func (c *Claims) HasScope(scope string) bool {
+	if !c.IsScoped() {
+		return true
+	}
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRole reports whether the token carries the given role
+func (c *Claims) HasRole(role string) bool {
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission reports whether the token carries the given permission,
+// either directly or through the PermAll wildcard
+func (c *Claims) HasPermission(permission string) bool {
+	for _, p := range c.Permissions {
+		if p == permission || p == PermAll {
+			return true
+		}
+	}
+	return false
+}
+
+// CanActOn reports whether the token holder may modify a resource owned by
+// ownerID. Owners can always act on their own resources; anyone else needs
+// the given permission (e.g. an admin or moderator fixing a customer's data).
+// Service principals own nothing and act on any resource whose write scope
+// they were granted, e.g. "products:write" for PermManageProducts.
+func (c *Claims) CanActOn(ownerID uint64, permission string) bool {
+	if c == nil {
+		return false
+	}
+	if c.IsService() {
+		resource, _, _ := strings.Cut(permission, ":")
+		return c.HasScope(resource + ":write")
+	}
+	return c.UserID == ownerID || c.HasPermission(permission)
+}
@@ -0,0 +1,619 @@
+package kafkaclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrBusClosed is returned when publishing to a closed Bus
+var ErrBusClosed = errors.New("kafkaclient: bus is closed")
+
+// offsetsFile is where a file-backed bus keeps the consumer groups' offsets
+const offsetsFile = "offsets.json"
+
+// Bus is an in-process event bus with Kafka's semantics: topics are split
+// into partitions by key, the members of a consumer group share a topic's
+// partitions, and a group resumes from its committed offsets. It is both a
+// Publisher and a Subscriber, so tests and single-binary local setups can
+// run producers and consumers without a broker. Messages are never deleted.
+type Bus struct {
+	partitions int
+	dir        string // Where messages and offsets are stored, empty to keep them in memory
+
+	mu      sync.Mutex
+	closed  bool
+	next    int // Partition of the next keyless message, round robin
+	topics  map[string][]*busPartition
+	logs    map[string]*os.File // By topic
+	offsets map[string]map[string]map[int32]int64
+	groups  map[string]*busGroup
+}
+
+type busPartition struct {
+	messages []*Message
+	appended chan struct{} // Closed and replaced whenever a message is appended
+}
+
+// busRecord is a message as stored in a topic's log file
+type busRecord struct {
+	Partition int32             `json:"partition"`
+	Key       []byte            `json:"key,omitempty"`
+	Value     []byte            `json:"value"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// NewBus creates an in-memory bus whose topics have the given number of
+// partitions, at least one
+func NewBus(partitions int) *Bus {
+	return &Bus{
+		partitions: max(partitions, 1),
+		topics:     make(map[string][]*busPartition),
+		logs:       make(map[string]*os.File),
+		offsets:    make(map[string]map[string]map[int32]int64),
+		groups:     make(map[string]*busGroup),
+	}
+}
+
+// OpenBus creates a bus that keeps its messages and offsets in dir, one
+// "<topic>.log" file of JSON lines per topic, so they survive restarts. Only
+// one process may use dir at a time.
+func OpenBus(dir string, partitions int) (*Bus, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create bus directory: %w", err)
+	}
+
+	b := NewBus(partitions)
+	b.dir = dir
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		topic := strings.TrimSuffix(filepath.Base(path), ".log")
+		if err := b.load(topic, path); err != nil {
+			_ = b.Close()
+			return nil, err
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, offsetsFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		_ = b.Close()
+		return nil, fmt.Errorf("failed to read offsets: %w", err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &b.offsets); err != nil {
+			_ = b.Close()
+			return nil, fmt.Errorf("failed to read offsets: %w", err)
+		}
+	}
+	return b, nil
+}
+
+// load reads a topic's log file and keeps it open for appending. A record
+// cut short by a crash is dropped.
+func (b *Bus) load(topic, path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open topic %s: %w", topic, err)
+	}
+	b.logs[topic] = file
+	partitions := b.topic(topic)
+
+	reader := bufio.NewReader(file)
+	var size int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break // Any incomplete last line is truncated below
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read topic %s: %w", topic, err)
+		}
+
+		var record busRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return fmt.Errorf("failed to read topic %s at byte %d: %w", topic, size, err)
+		}
+		for int(record.Partition) >= len(partitions) {
+			partitions = append(partitions, newBusPartition())
+		}
+		p := partitions[record.Partition]
+		p.messages = append(p.messages, &Message{
+			Topic:     topic,
+			Partition: record.Partition,
+			Offset:    int64(len(p.messages)),
+			Key:       record.Key,
+			Value:     record.Value,
+			Headers:   record.Headers,
+			Timestamp: record.Timestamp,
+		})
+		size += int64(len(line))
+	}
+	b.topics[topic] = partitions
+
+	if err := file.Truncate(size); err != nil {
+		return fmt.Errorf("failed to repair topic %s: %w", topic, err)
+	}
+	_, err = file.Seek(size, io.SeekStart)
+	return err
+}
+
+// Publish appends value to topic
+func (b *Bus) Publish(topic, key string, value []byte) error {
+	return b.PublishWithHeaders(topic, key, value, nil)
+}
+
+// PublishWithHeaders is Publish with headers
+func (b *Bus) PublishWithHeaders(topic, key string, value []byte, headers map[string]string) error {
+	if topic == "" || strings.ContainsAny(topic, `/\`) {
+		return fmt.Errorf("kafkaclient: invalid topic %q", topic)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrBusClosed
+	}
+
+	partitions := b.topic(topic)
+	msg := &Message{
+		Topic:     topic,
+		Partition: b.partitionFor(key, len(partitions)),
+		Value:     append([]byte(nil), value...),
+		Headers:   copyHeaders(headers),
+		Timestamp: time.Now(),
+	}
+	if key != "" {
+		msg.Key = []byte(key)
+	}
+	p := partitions[msg.Partition]
+	msg.Offset = int64(len(p.messages))
+
+	if b.dir != "" {
+		if err := b.appendLog(msg); err != nil {
+			return err
+		}
+	}
+
+	p.messages = append(p.messages, msg)
+	close(p.appended)
+	p.appended = make(chan struct{})
+	return nil
+}
+
+func (b *Bus) appendLog(msg *Message) error {
+	file := b.logs[msg.Topic]
+	if file == nil {
+		var err error
+		file, err = os.OpenFile(filepath.Join(b.dir, msg.Topic+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to create topic %s: %w", msg.Topic, err)
+		}
+		b.logs[msg.Topic] = file
+	}
+
+	line, err := json.Marshal(busRecord{
+		Partition: msg.Partition,
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Headers:   msg.Headers,
+		Timestamp: msg.Timestamp,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write to topic %s: %w", msg.Topic, err)
+	}
+	return nil
+}
+
+// topic returns the partitions of a topic, creating it if needed. b.mu must
+// be held.
+func (b *Bus) topic(name string) []*busPartition {
+	partitions, ok := b.topics[name]
+	if !ok {
+		partitions = make([]*busPartition, b.partitions)
+		for i := range partitions {
+			partitions[i] = newBusPartition()
+		}
+		b.topics[name] = partitions
+	}
+	return partitions
+}
+
+func newBusPartition() *busPartition {
+	return &busPartition{appended: make(chan struct{})}
+}
+
+// partitionFor hashes key to a partition, spreading keyless messages round
+// robin. b.mu must be held.
+func (b *Bus) partitionFor(key string, partitions int) int32 {
+	if key == "" {
+		b.next++
+		return int32(b.next % partitions)
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int32(h.Sum32() % uint32(partitions))
+}
+
+// Messages returns a copy of every message published to topic so far,
+// ordered by partition and offset. It is meant for assertions in tests.
+func (b *Bus) Messages(topic string) []*Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var messages []*Message
+	for _, p := range b.topics[topic] {
+		for _, msg := range p.messages {
+			messages = append(messages, copyMessage(msg))
+		}
+	}
+	return messages
+}
+
+// Close stops the bus's consumer groups and closes its files
+func (b *Bus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	for _, g := range b.groups {
+		g.rebalance()
+	}
+
+	var errs []error
+	for _, file := range b.logs {
+		errs = append(errs, file.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// JoinGroup creates a member of a consumer group on the bus
+func (b *Bus) JoinGroup(group string, fromOldest bool) (ConsumerGroup, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, ErrBusClosed
+	}
+	g, ok := b.groups[group]
+	if !ok {
+		g = &busGroup{name: group, running: make(map[int]int), changed: make(chan struct{})}
+		b.groups[group] = g
+	}
+	return &busMember{bus: b, group: g, fromOldest: fromOldest}, nil
+}
+
+// busGroup is a consumer group on a Bus. Whenever its members or their
+// topics change it moves to a new generation: every running session is
+// cancelled, and sessions of the new generation start only once all of the
+// old ones have ended, so a partition is never consumed by two members.
+type busGroup struct {
+	name       string
+	members    []*busMember // In the order they joined
+	generation int
+	running    map[int]int // Running sessions by generation
+	cancels    []context.CancelFunc
+	changed    chan struct{} // Closed and replaced when a session ends or the generation changes
+}
+
+// rebalance starts a new generation. The bus's mu must be held.
+func (g *busGroup) rebalance() {
+	g.generation++
+	for _, cancel := range g.cancels {
+		cancel()
+	}
+	g.cancels = nil
+	g.signal()
+}
+
+func (g *busGroup) signal() {
+	close(g.changed)
+	g.changed = make(chan struct{})
+}
+
+// busMember is a member of a consumer group on a Bus
+type busMember struct {
+	bus        *Bus
+	group      *busGroup
+	fromOldest bool
+
+	// Guarded by the bus's mu
+	joined bool
+	closed bool
+	topics []string
+}
+
+// Consume claims the member's share of the partitions of topics: for each
+// topic, the partitions are dealt out to its subscribed members in the order
+// they joined
+func (m *busMember) Consume(ctx context.Context, topics []string, consume func(Claim)) error {
+	b, g := m.bus, m.group
+	topics = append([]string(nil), topics...)
+	sort.Strings(topics)
+
+	b.mu.Lock()
+	if m.closed || b.closed {
+		b.mu.Unlock()
+		return ErrGroupClosed
+	}
+	if !m.joined || !equalStrings(m.topics, topics) {
+		if !m.joined {
+			g.members = append(g.members, m)
+			m.joined = true
+		}
+		m.topics = topics
+		g.rebalance()
+	}
+
+	// Wait for the sessions of earlier generations to end
+	for g.stale() {
+		changed := g.changed
+		b.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil
+		}
+		b.mu.Lock()
+		if m.closed || b.closed {
+			b.mu.Unlock()
+			return ErrGroupClosed
+		}
+	}
+
+	generation := g.generation
+	sessionCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	g.cancels = append(g.cancels, cancel)
+	g.running[generation]++
+	claims := m.claims(sessionCtx)
+	b.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, claim := range claims {
+		wg.Add(2)
+		go func(claim *busClaim) {
+			defer wg.Done()
+			b.feed(claim)
+		}(claim)
+		go func(claim *busClaim) {
+			defer wg.Done()
+			consume(claim)
+		}(claim)
+	}
+	<-sessionCtx.Done()
+	wg.Wait()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if g.running[generation]--; g.running[generation] == 0 {
+		delete(g.running, generation)
+	}
+	g.signal()
+	if m.closed || b.closed {
+		return ErrGroupClosed
+	}
+	return nil
+}
+
+// stale reports whether sessions of an earlier generation are still
+// running. The bus's mu must be held.
+func (g *busGroup) stale() bool {
+	for generation := range g.running {
+		if generation < g.generation {
+			return true
+		}
+	}
+	return false
+}
+
+// claims assigns the member its partitions, starting each at the group's
+// committed offset. A partition without one starts at the oldest or the
+// next message, which is committed so the group does not skip messages
+// published before a rebalance. The bus's mu must be held.
+func (m *busMember) claims(ctx context.Context) []*busClaim {
+	b, g := m.bus, m.group
+	offsets := b.offsets[g.name]
+	if offsets == nil {
+		offsets = make(map[string]map[int32]int64)
+		b.offsets[g.name] = offsets
+	}
+
+	var claims []*busClaim
+	for _, topic := range m.topics {
+		var subscribed []*busMember
+		for _, member := range g.members {
+			if member.subscribes(topic) {
+				subscribed = append(subscribed, member)
+			}
+		}
+		index := 0
+		for i, member := range subscribed {
+			if member == m {
+				index = i
+			}
+		}
+
+		if offsets[topic] == nil {
+			offsets[topic] = make(map[int32]int64)
+		}
+		for partition, p := range b.topic(topic) {
+			if partition%len(subscribed) != index {
+				continue
+			}
+			offset, ok := offsets[topic][int32(partition)]
+			if !ok && !m.fromOldest {
+				offset = int64(len(p.messages))
+				offsets[topic][int32(partition)] = offset
+			}
+			claims = append(claims, &busClaim{
+				ctx:       ctx,
+				bus:       b,
+				group:     g.name,
+				topic:     topic,
+				partition: int32(partition),
+				offset:    offset,
+				messages:  make(chan *Message),
+			})
+		}
+	}
+	return claims
+}
+
+func (m *busMember) subscribes(topic string) bool {
+	for _, t := range m.topics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// Close leaves the group, handing the member's partitions to the others
+func (m *busMember) Close() error {
+	b, g := m.bus, m.group
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	for i, member := range g.members {
+		if member == m {
+			g.members = append(g.members[:i], g.members[i+1:]...)
+			break
+		}
+	}
+	g.rebalance()
+	return nil
+}
+
+// busClaim is a partition claimed by a member of a group on a Bus
+type busClaim struct {
+	ctx       context.Context
+	bus       *Bus
+	group     string
+	topic     string
+	partition int32
+	offset    int64 // Of the next message to deliver
+	messages  chan *Message
+}
+
+func (c *busClaim) Context() context.Context  { return c.ctx }
+func (c *busClaim) Messages() <-chan *Message { return c.messages }
+
+func (c *busClaim) Commit(offset int64) {
+	c.bus.commit(c.group, c.topic, c.partition, offset)
+}
+
+// feed delivers the claimed partition's messages, waiting for new ones at
+// its end, until the claim is revoked
+func (b *Bus) feed(claim *busClaim) {
+	defer close(claim.messages)
+
+	b.mu.Lock()
+	p := b.topic(claim.topic)[claim.partition]
+	b.mu.Unlock()
+
+	for offset := claim.offset; ; {
+		b.mu.Lock()
+		appended := p.appended
+		var msg *Message
+		if offset < int64(len(p.messages)) {
+			msg = copyMessage(p.messages[offset])
+		}
+		b.mu.Unlock()
+
+		if msg == nil {
+			select {
+			case <-appended:
+				continue
+			case <-claim.ctx.Done():
+				return
+			}
+		}
+		select {
+		case claim.messages <- msg:
+			offset++
+		case <-claim.ctx.Done():
+			return
+		}
+	}
+}
+
+// commit records a group's offset, saving all offsets if the bus is
+// file-backed
+func (b *Bus) commit(group, topic string, partition int32, offset int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+	b.offsets[group][topic][partition] = offset
+	if b.dir == "" {
+		return
+	}
+
+	data, err := json.Marshal(b.offsets)
+	if err == nil {
+		path := filepath.Join(b.dir, offsetsFile)
+		if err = os.WriteFile(path+".tmp", data, 0o644); err == nil {
+			err = os.Rename(path+".tmp", path)
+		}
+	}
+	if err != nil {
+		// The messages since the last saved commit are delivered again
+		// after a restart
+		log.Printf("Failed to save consumer offsets: %v", err)
+	}
+}
+
+func copyMessage(msg *Message) *Message {
+	c := *msg
+	c.Headers = copyHeaders(msg.Headers)
+	return &c
+}
+
+func copyHeaders(headers map[string]string) map[string]string {
+	c := make(map[string]string, len(headers))
+	for k, v := range headers {
+		c[k] = v
+	}
+	return c
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,520 @@
+package kafkaclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it holds, failing the test after a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// runConsumer runs consumer until the test ends
+func runConsumer(t *testing.T, consumer *Consumer) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- consumer.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Run: %v", err)
+		}
+		_ = consumer.Close()
+	})
+}
+
+func TestBusKeepsKeysInOrder(t *testing.T) {
+	bus := NewBus(4)
+	defer bus.Close()
+
+	keys := []string{"a", "b", "c", "d", "e"}
+	for i := 0; i < 50; i++ {
+		key := keys[i%len(keys)]
+		if err := bus.Publish("orders", key, []byte(fmt.Sprint(i))); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+
+	partitionOf := make(map[string]int32)
+	lastOffset := make(map[int32]int64)
+	for _, msg := range bus.Messages("orders") {
+		key := string(msg.Key)
+		if p, ok := partitionOf[key]; ok && p != msg.Partition {
+			t.Errorf("key %s in partitions %d and %d", key, p, msg.Partition)
+		}
+		partitionOf[key] = msg.Partition
+		if last, ok := lastOffset[msg.Partition]; ok && msg.Offset != last+1 {
+			t.Errorf("partition %d offset %d after %d", msg.Partition, msg.Offset, last)
+		}
+		lastOffset[msg.Partition] = msg.Offset
+	}
+
+	// A consumer sees each key's messages in the order they were published
+	var mu sync.Mutex
+	seen := make(map[string][]int)
+	consumer, err := NewConsumer(ConsumerConfig{GroupID: "g", Subscriber: bus, FromOldest: true, Concurrency: 3})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	consumer.Handle("orders", func(_ context.Context, msg *Message) error {
+		n, err := strconv.Atoi(string(msg.Value))
+		if err != nil {
+			return Permanent(err)
+		}
+		mu.Lock()
+		seen[string(msg.Key)] = append(seen[string(msg.Key)], n)
+		mu.Unlock()
+		return nil
+	})
+	runConsumer(t, consumer)
+
+	waitFor(t, "all messages", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		total := 0
+		for _, values := range seen {
+			total += len(values)
+		}
+		return total == 50
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	for key, values := range seen {
+		if !sort.IntsAreSorted(values) {
+			t.Errorf("key %s consumed out of order: %v", key, values)
+		}
+	}
+}
+
+// claimTracker records the partitions each group member currently holds
+type claimTracker struct {
+	mu     sync.Mutex
+	claims map[string]map[int32]bool
+}
+
+func (c *claimTracker) consume(member string) func(Claim) {
+	return func(claim Claim) {
+		bc := claim.(*busClaim)
+		c.mu.Lock()
+		if c.claims[member] == nil {
+			c.claims[member] = make(map[int32]bool)
+		}
+		c.claims[member][bc.partition] = true
+		c.mu.Unlock()
+
+		<-claim.Context().Done()
+
+		c.mu.Lock()
+		delete(c.claims[member], bc.partition)
+		c.mu.Unlock()
+	}
+}
+
+func (c *claimTracker) holds(member string, partitions ...int32) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.claims[member]) != len(partitions) {
+		return false
+	}
+	for _, p := range partitions {
+		if !c.claims[member][p] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBusGroupMembersSharePartitions(t *testing.T) {
+	bus := NewBus(4)
+	defer bus.Close()
+	tracker := &claimTracker{claims: make(map[string]map[int32]bool)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	join := func(name string) ConsumerGroup {
+		member, err := bus.JoinGroup("g", true)
+		if err != nil {
+			t.Fatalf("JoinGroup: %v", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				if err := member.Consume(ctx, []string{"orders"}, tracker.consume(name)); err != nil {
+					return
+				}
+			}
+		}()
+		return member
+	}
+
+	first := join("first")
+	waitFor(t, "first member to claim every partition", func() bool {
+		return tracker.holds("first", 0, 1, 2, 3)
+	})
+
+	second := join("second")
+	waitFor(t, "members to split the partitions", func() bool {
+		return tracker.holds("first", 0, 2) && tracker.holds("second", 1, 3)
+	})
+
+	if err := second.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	waitFor(t, "first member to take over", func() bool {
+		return tracker.holds("first", 0, 1, 2, 3) && tracker.holds("second")
+	})
+
+	if err := first.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	wg.Wait()
+	if err := first.Consume(ctx, []string{"orders"}, tracker.consume("first")); !errors.Is(err, ErrGroupClosed) {
+		t.Errorf("Consume after Close = %v, want ErrGroupClosed", err)
+	}
+}
+
+func TestOpenBusResumesFromCommittedOffset(t *testing.T) {
+	dir := t.TempDir()
+
+	consume := func(bus *Bus, want int) []string {
+		var mu sync.Mutex
+		var values []string
+		consumer, err := NewConsumer(ConsumerConfig{GroupID: "g", Subscriber: bus, FromOldest: true})
+		if err != nil {
+			t.Fatalf("NewConsumer: %v", err)
+		}
+		consumer.Handle("orders", func(_ context.Context, msg *Message) error {
+			mu.Lock()
+			values = append(values, string(msg.Value))
+			mu.Unlock()
+			return nil
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- consumer.Run(ctx) }()
+		waitFor(t, fmt.Sprintf("%d messages", want), func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(values) >= want
+		})
+		// Give redeliveries a chance to show up
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		_ = consumer.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		return values
+	}
+
+	bus, err := OpenBus(dir, 2)
+	if err != nil {
+		t.Fatalf("OpenBus: %v", err)
+	}
+	for _, v := range []string{"1", "2", "3"} {
+		if err := bus.Publish("orders", "k", []byte(v)); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	if got := consume(bus, 3); len(got) != 3 {
+		t.Fatalf("consumed %v, want 3 messages", got)
+	}
+	if err := bus.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	bus, err = OpenBus(dir, 2)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer bus.Close()
+	if n := len(bus.Messages("orders")); n != 3 {
+		t.Fatalf("reopened bus has %d messages, want 3", n)
+	}
+	if err := bus.Publish("orders", "k", []byte("4")); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if got := consume(bus, 1); len(got) != 1 || got[0] != "4" {
+		t.Errorf("after reopening consumed %v, want [4]", got)
+	}
+}
+
+func TestOpenBusDropsTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	bus, err := OpenBus(dir, 1)
+	if err != nil {
+		t.Fatalf("OpenBus: %v", err)
+	}
+	if err := bus.Publish("orders", "", []byte("a")); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	_ = bus.Close()
+
+	// Simulate a crash halfway through writing a record
+	file, err := os.OpenFile(filepath.Join(dir, "orders.log"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = file.WriteString(`{"partition":0,"val`)
+	_ = file.Close()
+
+	bus, err = OpenBus(dir, 1)
+	if err != nil {
+		t.Fatalf("OpenBus after torn write: %v", err)
+	}
+	if err := bus.Publish("orders", "", []byte("b")); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	_ = bus.Close()
+
+	bus, err = OpenBus(dir, 1)
+	if err != nil {
+		t.Fatalf("OpenBus: %v", err)
+	}
+	defer bus.Close()
+	messages := bus.Messages("orders")
+	if len(messages) != 2 || string(messages[1].Value) != "b" || messages[1].Offset != 1 {
+		t.Errorf("messages after repair = %+v, want a and b", messages)
+	}
+}
+
+func TestBusDeadLettersFailingMessage(t *testing.T) {
+	bus := NewBus(2)
+	defer bus.Close()
+
+	consumer, err := NewConsumer(ConsumerConfig{
+		GroupID:    "g",
+		Subscriber: bus,
+		MaxRetries: -1,
+		DeadLetter: &DeadLetterConfig{
+			Publisher:   bus,
+			RetryDelays: []time.Duration{10 * time.Millisecond},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	var mu sync.Mutex
+	attempts := 0
+	consumer.Handle("orders", func(context.Context, *Message) error {
+		mu.Lock()
+		attempts++
+		mu.Unlock()
+		return errors.New("boom")
+	})
+	runConsumer(t, consumer)
+
+	// The group starts at new messages, so publish once it has joined
+	waitFor(t, "consumer to join", func() bool {
+		bus.mu.Lock()
+		defer bus.mu.Unlock()
+		g := bus.groups["g"]
+		return g != nil && len(g.running) > 0 && !g.stale()
+	})
+	if err := bus.PublishWithHeaders("orders", "k", []byte(`{}`), map[string]string{"trace": "t1"}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	waitFor(t, "dead letter", func() bool { return len(bus.Messages(DeadLetterTopic("g"))) == 1 })
+
+	if n := len(bus.Messages(RetryTopic("g", 1))); n != 1 {
+		t.Errorf("retry topic has %d messages, want 1", n)
+	}
+	letter := bus.Messages(DeadLetterTopic("g"))[0]
+	want := map[string]string{
+		"trace":         "t1",
+		headerTopic:     "orders",
+		headerPartition: fmt.Sprint(bus.Messages("orders")[0].Partition),
+		headerOffset:    "0",
+		headerGroup:     "g",
+		headerReason:    "boom",
+		headerTier:      "1",
+	}
+	for k, v := range want {
+		if letter.Headers[k] != v {
+			t.Errorf("header %s = %q, want %q", k, letter.Headers[k], v)
+		}
+	}
+	if string(letter.Key) != "k" {
+		t.Errorf("key = %q, want k", letter.Key)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 2 {
+		t.Errorf("handler called %d times, want 2", attempts)
+	}
+}
+
+func TestConsumerContinuesCorrelation(t *testing.T) {
+	bus := NewBus(1)
+	defer bus.Close()
+
+	ctx := NewCorrelation(context.Background())
+	event := TaskCreatedEvent{TaskID: 1, UserID: 2, Title: "t", Status: "todo", CreatedAt: "2024-05-01T12:00:00Z"}
+	first, err := NewEnvelope(ctx, "task-service", "task.created", "1", event)
+	if err != nil {
+		t.Fatalf("NewEnvelope: %v", err)
+	}
+	second, err := NewEnvelope(ctx, "task-service", "task.created", "1", event)
+	if err != nil {
+		t.Fatalf("NewEnvelope: %v", err)
+	}
+	if first.CorrelationID != CorrelationID(ctx) || second.CorrelationID != first.CorrelationID || first.ID == second.ID {
+		t.Fatalf("envelopes %+v and %+v do not share the correlation", first, second)
+	}
+	alone, err := NewEnvelope(context.Background(), "task-service", "task.created", "1", event)
+	if err != nil {
+		t.Fatalf("NewEnvelope: %v", err)
+	}
+	if alone.CorrelationID != alone.ID {
+		t.Errorf("uncorrelated envelope has correlation %s, want its ID %s", alone.CorrelationID, alone.ID)
+	}
+
+	value, err := json.Marshal(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Publish("task.created", "1", value); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	consumer, err := NewConsumer(ConsumerConfig{GroupID: "g", Subscriber: bus, FromOldest: true})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	got := make(chan string, 1)
+	consumer.Handle("task.created", func(ctx context.Context, _ *Message) error {
+		got <- CorrelationID(ctx)
+		return nil
+	})
+	runConsumer(t, consumer)
+
+	select {
+	case id := <-got:
+		if id != first.CorrelationID {
+			t.Errorf("handler correlation = %q, want %q", id, first.CorrelationID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the event")
+	}
+}
+
+func TestConsumerRetriesWithBackoff(t *testing.T) {
+	bus := NewBus(1)
+	defer bus.Close()
+	if err := bus.Publish("orders", "k", []byte(`{}`)); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	var mu sync.Mutex
+	var attempts []time.Time
+	failed := make(chan error, 1)
+	consumer, err := NewConsumer(ConsumerConfig{
+		GroupID:        "g",
+		Subscriber:     bus,
+		FromOldest:     true,
+		MaxRetries:     3,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     20 * time.Millisecond,
+		OnFailure: func(_ context.Context, _ *Message, err error) error {
+			failed <- err
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	consumer.Handle("orders", func(context.Context, *Message) error {
+		mu.Lock()
+		attempts = append(attempts, time.Now())
+		mu.Unlock()
+		return errors.New("boom")
+	})
+	runConsumer(t, consumer)
+
+	select {
+	case err := <-failed:
+		if err == nil || err.Error() != "boom" {
+			t.Errorf("OnFailure error = %v, want boom", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for OnFailure")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(attempts) != 4 {
+		t.Fatalf("handler called %d times, want 4", len(attempts))
+	}
+	// Backoffs of 10ms, 20ms and 20ms, capped by MaxBackoff
+	for i, want := range []time.Duration{10, 20, 20} {
+		if gap := attempts[i+1].Sub(attempts[i]); gap < want*time.Millisecond {
+			t.Errorf("retry %d after %v, want at least %dms", i+1, gap, want)
+		}
+	}
+}
+
+func TestConsumerDoesNotRetryInvalidEvents(t *testing.T) {
+	bus := NewBus(1)
+	defer bus.Close()
+	// An envelope whose data does not match the task.created schema
+	invalid := `{"specversion":"1.0","id":"1","type":"task.created","schemaversion":1,"data":{"task_id":"x"}}`
+	if err := bus.Publish("task.created", "1", []byte(invalid)); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	failed := make(chan error, 1)
+	consumer, err := NewConsumer(ConsumerConfig{
+		GroupID:    "g",
+		Subscriber: bus,
+		FromOldest: true,
+		MaxRetries: 3,
+		OnFailure: func(_ context.Context, _ *Message, err error) error {
+			failed <- err
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewConsumer: %v", err)
+	}
+	calls := 0
+	consumer.Handle("task.created", func(context.Context, *Message) error {
+		calls++
+		return nil
+	})
+	runConsumer(t, consumer)
+
+	select {
+	case err := <-failed:
+		if !IsPermanent(err) {
+			t.Errorf("OnFailure error = %v, want a permanent error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for OnFailure")
+	}
+	if calls != 0 {
+		t.Errorf("handler called %d times for an invalid event", calls)
+	}
+}
@@ -0,0 +1,150 @@
+// Command dlq inspects and resolves the dead letters of a consumer group:
+// the messages it failed to handle after every retry. Run it from
+// pkg/kafkaclient:
+//
+//	go run ./cmd/dlq -group analytics-consumer list
+//	go run ./cmd/dlq -group analytics-consumer show 0-12
+//	go run ./cmd/dlq -group analytics-consumer requeue 0-12 1-3
+//	go run ./cmd/dlq -group analytics-consumer requeue -all
+//	go run ./cmd/dlq -group analytics-consumer discard 0-12
+//
+// Requeued messages are handled again by the group; discarded ones are not.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/my-username/billion-user-app/pkg/kafkaclient"
+)
+
+const usage = `Usage: dlq -group GROUP [-brokers BROKERS] COMMAND
+
+Commands:
+  list                 List unresolved dead letters
+  show ID...           Print dead letters as JSON, with their value and headers
+  requeue ID...|-all   Send dead letters back to the group
+  discard ID...|-all   Drop dead letters
+`
+
+func main() {
+	defaultBrokers := os.Getenv("KAFKA_BROKERS")
+	if defaultBrokers == "" {
+		defaultBrokers = "localhost:9092"
+	}
+
+	group := flag.String("group", "", "consumer group whose dead letters to manage")
+	brokers := flag.String("brokers", defaultBrokers, "comma-separated Kafka brokers, defaults to $KAFKA_BROKERS")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+	if *group == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	queue, err := kafkaclient.OpenDeadLetterQueue(strings.Split(*brokers, ","), *group)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer queue.Close()
+
+	command, args := flag.Arg(0), flag.Args()[1:]
+	switch command {
+	case "list":
+		err = list(ctx, queue)
+	case "show":
+		err = show(ctx, queue, args)
+	case "requeue":
+		err = resolve(ctx, queue, args, queue.Requeue)
+	case "discard":
+		err = resolve(ctx, queue, args, queue.Discard)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func list(ctx context.Context, queue *kafkaclient.DeadLetterQueue) error {
+	letters, err := queue.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTOPIC\tKEY\tFAILED AT\tRETRIES\tREASON")
+	for _, letter := range letters {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", letter.ID, letter.Topic, letter.Key,
+			letter.FailedAt.Local().Format(time.DateTime), letter.Retries, letter.Reason)
+	}
+	return w.Flush()
+}
+
+func show(ctx context.Context, queue *kafkaclient.DeadLetterQueue, ids []string) error {
+	letters, err := queue.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	for _, id := range ids {
+		found := false
+		for _, letter := range letters {
+			if letter.ID == id {
+				found = true
+				if err := encoder.Encode(letter); err != nil {
+					return err
+				}
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w: %s", kafkaclient.ErrDeadLetterNotFound, id)
+		}
+	}
+	return nil
+}
+
+// resolve requeues or discards the given dead letters, or all of them for
+// -all
+func resolve(ctx context.Context, queue *kafkaclient.DeadLetterQueue, args []string, fn func(context.Context, ...string) error) error {
+	ids := args
+	if len(args) == 1 && args[0] == "-all" {
+		letters, err := queue.List(ctx)
+		if err != nil {
+			return err
+		}
+		if len(letters) == 0 {
+			fmt.Println("No dead letters")
+			return nil
+		}
+		ids = make([]string, 0, len(letters))
+		for _, letter := range letters {
+			ids = append(ids, letter.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return errors.New("no dead letters given")
+	}
+
+	if err := fn(ctx, ids...); err != nil {
+		return err
+	}
+	fmt.Printf("Resolved %d dead letters\n", len(ids))
+	return nil
+}
@@ -0,0 +1,415 @@
+package kafkaclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sync"
+	"time"
+)
+
+// Message is an event received from Kafka
+type Message struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   map[string]string
+	Timestamp time.Time
+
+	// Envelope is the decoded value, or nil for an event published before
+	// events were wrapped in envelopes
+	Envelope *Envelope
+}
+
+// Decode unmarshals the event into v: the envelope's data, or the whole
+// value of a bare event
+func (m *Message) Decode(v interface{}) error {
+	if m.Envelope != nil {
+		return m.Envelope.Decode(v)
+	}
+	return json.Unmarshal(m.Value, v)
+}
+
+// Handler processes one message. Returning an error retries the message with
+// backoff; wrap it with Permanent if retrying cannot help. ctx carries the
+// event's correlation ID, so envelopes built from it continue it.
+type Handler func(ctx context.Context, msg *Message) error
+
+// JSONHandler adapts a function taking a decoded event of type T to a
+// Handler. Messages that do not decode are failed without retrying.
+func JSONHandler[T any](fn func(ctx context.Context, event T) error) Handler {
+	return func(ctx context.Context, msg *Message) error {
+		var event T
+		if err := msg.Decode(&event); err != nil {
+			return Permanent(fmt.Errorf("failed to decode event: %w", err))
+		}
+		return fn(ctx, event)
+	}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying, such as a malformed event
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked with Permanent
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
+
+// FailureHandler is called with a message whose handler failed permanently
+// or ran out of retries. Returning nil skips the message; returning an error
+// stops consuming the partition until the next rebalance, which redelivers
+// the message.
+type FailureHandler func(ctx context.Context, msg *Message, err error) error
+
+// ConsumerConfig configures a Consumer
+type ConsumerConfig struct {
+	Brokers []string
+	GroupID string
+
+	// Subscriber joins the consumer group. Defaults to Kafka at Brokers;
+	// use a Bus to consume in process.
+	Subscriber Subscriber
+
+	// Concurrency is the number of messages handled at once per partition.
+	// Messages with the same key are always handled in order. Defaults to 1.
+	Concurrency int
+
+	// MaxRetries is how often a failed message is retried, waiting
+	// InitialBackoff and doubling up to MaxBackoff in between. Defaults to 5
+	// retries from 100ms up to 10s; a negative value disables retries.
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// OnFailure decides what happens to messages that could not be handled.
+	// Defaults to logging and skipping them. It is not called when
+	// DeadLetter is set.
+	OnFailure FailureHandler
+
+	// DeadLetter moves messages that could not be handled to retry and
+	// dead-letter topics instead of blocking or skipping them
+	DeadLetter *DeadLetterConfig
+
+	// FromOldest makes a new group start at the oldest retained message
+	// instead of only new ones
+	FromOldest bool
+
+	// Schemas validates events before they reach a handler. Defaults to
+	// Schemas; events that fail validation are failed without retrying.
+	Schemas *Registry
+}
+
+// Consumer reads topics as a member of a consumer group and dispatches
+// messages to the handler registered for their topic. Delivery is at least
+// once: offsets are committed only after the handler succeeds, so messages
+// in flight during a crash or rebalance are handled again and handlers must
+// be idempotent.
+type Consumer struct {
+	cfg      ConsumerConfig
+	group    ConsumerGroup
+	handlers map[string]Handler
+	retries  map[string]bool // Retry and requeue topics
+}
+
+// NewConsumer joins the consumer group cfg.GroupID
+func NewConsumer(cfg ConsumerConfig) (*Consumer, error) {
+	if cfg.GroupID == "" {
+		return nil, errors.New("kafkaclient: consumer group ID is required")
+	}
+	if cfg.DeadLetter != nil && cfg.DeadLetter.Publisher == nil {
+		return nil, errors.New("kafkaclient: dead-letter publisher is required")
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	} else if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = 5
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = 100 * time.Millisecond
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 10 * time.Second
+	}
+	if cfg.OnFailure == nil {
+		cfg.OnFailure = logFailure
+	}
+	if cfg.Schemas == nil {
+		cfg.Schemas = Schemas
+	}
+	if cfg.Subscriber == nil {
+		cfg.Subscriber = NewSubscriber(cfg.Brokers)
+	}
+
+	group, err := cfg.Subscriber.JoinGroup(cfg.GroupID, cfg.FromOldest)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Consumer{
+		cfg:      cfg,
+		group:    group,
+		handlers: make(map[string]Handler),
+		retries:  retryTopics(cfg),
+	}, nil
+}
+
+// Handle registers the handler for topic. Handlers must be registered
+// before Run.
+func (c *Consumer) Handle(topic string, handler Handler) {
+	c.handlers[topic] = handler
+}
+
+// Run consumes the registered topics until ctx is cancelled, rejoining the
+// group after every rebalance. When ctx is cancelled or a partition is
+// revoked the handlers' context is cancelled too; messages they do not
+// finish are not committed and are delivered again.
+func (c *Consumer) Run(ctx context.Context) error {
+	if len(c.handlers) == 0 {
+		return errors.New("kafkaclient: no topics to consume")
+	}
+	topics := make([]string, 0, len(c.handlers)+len(c.retries))
+	for topic := range c.handlers {
+		topics = append(topics, topic)
+	}
+	for topic := range c.retries {
+		topics = append(topics, topic)
+	}
+
+	backoff := c.cfg.InitialBackoff
+	for ctx.Err() == nil {
+		err := c.group.Consume(ctx, topics, c.consume)
+		if errors.Is(err, ErrGroupClosed) {
+			return nil
+		}
+		if err == nil {
+			backoff = c.cfg.InitialBackoff
+			continue
+		}
+
+		log.Printf("Consumer group %s failed, retrying in %s: %v", c.cfg.GroupID, backoff, err)
+		if !sleep(ctx, backoff) {
+			break
+		}
+		backoff = min(2*backoff, c.cfg.MaxBackoff)
+	}
+	return nil
+}
+
+// Close leaves the consumer group, committing the offsets of messages
+// handled so far
+func (c *Consumer) Close() error {
+	return c.group.Close()
+}
+
+// handle runs the topic's handler, retrying failures with backoff. It
+// returns an error only if the message must not be committed.
+func (c *Consumer) handle(ctx context.Context, msg *Message) error {
+	tier := 0
+	if c.retries[msg.Topic] {
+		var err error
+		if msg, tier, err = unwrapRetry(ctx, msg); err != nil {
+			return err
+		}
+	}
+
+	handler, ok := c.handlers[msg.Topic]
+	if !ok {
+		return nil
+	}
+
+	if err := c.validate(msg); err != nil {
+		return c.fail(ctx, msg, tier, err)
+	}
+	if msg.Envelope != nil {
+		// Events published by the handler continue this one's correlation
+		ctx = WithCorrelationID(ctx, msg.Envelope.CorrelationID)
+	}
+
+	backoff := c.cfg.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		err := handler(ctx, msg)
+		if err == nil {
+			return nil
+		}
+		if IsPermanent(err) || attempt >= c.cfg.MaxRetries {
+			return c.fail(ctx, msg, tier, err)
+		}
+		if !sleep(ctx, backoff) {
+			return ctx.Err()
+		}
+		backoff = min(2*backoff, c.cfg.MaxBackoff)
+	}
+}
+
+// fail gives up on a message after err, sending it to the next retry tier
+// or the dead-letter topic if they are configured
+func (c *Consumer) fail(ctx context.Context, msg *Message, tier int, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if c.cfg.DeadLetter != nil {
+		return c.reroute(msg, tier, err)
+	}
+	return c.cfg.OnFailure(ctx, msg, err)
+}
+
+// validate decodes the message's envelope and checks the event against its
+// schema. Bare events cannot be validated and are passed through.
+func (c *Consumer) validate(msg *Message) error {
+	envelope, err := ParseEnvelope(msg.Value)
+	if err == ErrNotEnvelope {
+		return nil
+	}
+	if err != nil {
+		return Permanent(err)
+	}
+	if err := c.cfg.Schemas.Validate(envelope.Type, envelope.SchemaVersion, envelope.Data); err != nil {
+		return Permanent(err)
+	}
+	msg.Envelope = envelope
+	return nil
+}
+
+func logFailure(_ context.Context, msg *Message, err error) error {
+	log.Printf("Skipping message %s/%d/%d: %v", msg.Topic, msg.Partition, msg.Offset, err)
+	return nil
+}
+
+// sleep waits for d, returning false if ctx is cancelled first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// consume spreads a partition's messages over workers by key, so messages
+// with the same key stay in order, and commits each offset once it and every
+// offset before it has been handled. It returns when the partition is
+// revoked, after the workers finish their current message.
+func (c *Consumer) consume(claim Claim) {
+	ctx, cancel := context.WithCancel(claim.Context())
+	defer cancel()
+
+	tracker := &offsetTracker{claim: claim}
+	workers := make([]chan *Message, c.cfg.Concurrency)
+	var wg sync.WaitGroup
+	for i := range workers {
+		workers[i] = make(chan *Message)
+		wg.Add(1)
+		go func(messages <-chan *Message) {
+			defer wg.Done()
+			for msg := range messages {
+				if err := c.handle(ctx, msg); err != nil {
+					// Leave the offset unmarked and give the partition
+					// up until the next rebalance
+					cancel()
+					continue
+				}
+				tracker.done(msg.Offset)
+			}
+		}(workers[i])
+	}
+	defer func() {
+		for _, messages := range workers {
+			close(messages)
+		}
+		wg.Wait()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return
+			}
+			tracker.add(msg.Offset)
+			select {
+			case workers[workerFor(msg, len(workers))] <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// workerFor picks the worker for msg. Keyless messages have no ordering to
+// preserve and are spread by offset.
+func workerFor(msg *Message, workers int) int {
+	if len(msg.Key) == 0 {
+		return int(msg.Offset % int64(workers))
+	}
+	h := fnv.New32a()
+	_, _ = h.Write(msg.Key)
+	return int(h.Sum32() % uint32(workers))
+}
+
+// offsetTracker commits a partition's offsets in order even though workers
+// finish messages out of order, so a commit never skips an unhandled message
+type offsetTracker struct {
+	claim Claim
+
+	mu      sync.Mutex
+	pending []trackedOffset // In the order received
+}
+
+type trackedOffset struct {
+	offset int64
+	done   bool
+}
+
+func (t *offsetTracker) add(offset int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = append(t.pending, trackedOffset{offset: offset})
+}
+
+func (t *offsetTracker) done(offset int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i := range t.pending {
+		if t.pending[i].offset == offset {
+			t.pending[i].done = true
+			break
+		}
+	}
+
+	handled := 0
+	for handled < len(t.pending) && t.pending[handled].done {
+		handled++
+	}
+	if handled == 0 {
+		return
+	}
+	// The committed offset is the next message to read
+	t.claim.Commit(t.pending[handled-1].offset + 1)
+	t.pending = t.pending[handled:]
+}
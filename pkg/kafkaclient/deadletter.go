@@ -0,0 +1,134 @@
+package kafkaclient
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers added to messages moved to retry and dead-letter topics. The
+// message's own headers are kept alongside them.
+const (
+	headerPrefix     = "failure-"
+	headerTopic      = "failure-topic"     // Topic the message was originally published to
+	headerPartition  = "failure-partition" // Its partition and offset there
+	headerOffset     = "failure-offset"
+	headerGroup      = "failure-group"      // Consumer group that failed to handle it
+	headerReason     = "failure-reason"     // The handler's last error
+	headerTime       = "failure-time"       // When it last failed, RFC 3339
+	headerTier       = "failure-tier"       // Retry tiers it has been through
+	headerRetryAt    = "failure-retry-at"   // When a retry tier may handle it
+	headerResolves   = "failure-resolves"   // ID of the dead letter a marker resolves
+	headerResolution = "failure-resolution" // "requeued" or "discarded"
+)
+
+// DeadLetterConfig moves messages that fail out of their partition, so one
+// poison message does not hold up the messages behind it. A message that
+// fails all of the consumer's in-process retries is published to the
+// group's first retry topic and handled again after the first delay, then
+// the second, and so on. Messages that fail every tier, or fail permanently,
+// go to the group's dead-letter topic with the failure reason, where they
+// stay until they are requeued or discarded with a DeadLetterQueue.
+type DeadLetterConfig struct {
+	// Publisher writes to the retry and dead-letter topics
+	Publisher Publisher
+
+	// RetryDelays are the delays of the retry tiers, e.g. a minute, ten
+	// minutes and an hour. Without any, failed messages are dead-lettered
+	// right away.
+	RetryDelays []time.Duration
+}
+
+// RetryTopic is the topic of a consumer group's retry tier, counted from 1.
+// Retries of all the topics the group consumes share it.
+func RetryTopic(group string, tier int) string {
+	return fmt.Sprintf("%s.retry.%d", group, tier)
+}
+
+// RequeueTopic is the topic that dead letters are requeued to, to be
+// handled again by the consumer group that failed them
+func RequeueTopic(group string) string {
+	return group + ".requeue"
+}
+
+// DeadLetterTopic is the topic of messages a consumer group gave up on
+func DeadLetterTopic(group string) string {
+	return group + ".dlq"
+}
+
+// retryTopics are the topics a consumer reads messages to retry from
+func retryTopics(cfg ConsumerConfig) map[string]bool {
+	topics := make(map[string]bool)
+	if cfg.DeadLetter == nil {
+		return topics
+	}
+	topics[RequeueTopic(cfg.GroupID)] = true
+	for tier := range cfg.DeadLetter.RetryDelays {
+		topics[RetryTopic(cfg.GroupID, tier+1)] = true
+	}
+	return topics
+}
+
+// unwrapRetry waits until a message from a retry tier is due and restores
+// the message that originally failed, along with the tiers it has been
+// through. Requeued messages are due right away and start over at tier 0.
+func unwrapRetry(ctx context.Context, msg *Message) (*Message, int, error) {
+	if retryAt, err := time.Parse(time.RFC3339Nano, msg.Headers[headerRetryAt]); err == nil {
+		if !sleep(ctx, time.Until(retryAt)) {
+			return nil, 0, ctx.Err()
+		}
+	}
+
+	tier, _ := strconv.Atoi(msg.Headers[headerTier])
+	partition, _ := strconv.ParseInt(msg.Headers[headerPartition], 10, 32)
+	offset, _ := strconv.ParseInt(msg.Headers[headerOffset], 10, 64)
+
+	original := *msg
+	original.Topic = msg.Headers[headerTopic]
+	original.Partition = int32(partition)
+	original.Offset = offset
+	original.Headers = originalHeaders(msg.Headers)
+	return &original, tier, nil
+}
+
+// reroute moves a message that failed after tier retry tiers to the next
+// tier, or to the dead-letter topic once it has been through them all or
+// failed permanently
+func (c *Consumer) reroute(msg *Message, tier int, cause error) error {
+	headers := originalHeaders(msg.Headers)
+	headers[headerTopic] = msg.Topic
+	headers[headerPartition] = strconv.FormatInt(int64(msg.Partition), 10)
+	headers[headerOffset] = strconv.FormatInt(msg.Offset, 10)
+	headers[headerGroup] = c.cfg.GroupID
+	headers[headerReason] = cause.Error()
+	headers[headerTime] = time.Now().UTC().Format(time.RFC3339Nano)
+
+	delays := c.cfg.DeadLetter.RetryDelays
+	topic := DeadLetterTopic(c.cfg.GroupID)
+	if tier < len(delays) && !IsPermanent(cause) {
+		topic = RetryTopic(c.cfg.GroupID, tier+1)
+		headers[headerRetryAt] = time.Now().Add(delays[tier]).UTC().Format(time.RFC3339Nano)
+		tier++
+	}
+	headers[headerTier] = strconv.Itoa(tier)
+
+	if err := c.cfg.DeadLetter.Publisher.PublishWithHeaders(topic, string(msg.Key), msg.Value, headers); err != nil {
+		log.Printf("Failed to move message %s/%d/%d to %s: %v", msg.Topic, msg.Partition, msg.Offset, topic, err)
+		return err
+	}
+	return nil
+}
+
+// originalHeaders copies headers without the ones added by reroute
+func originalHeaders(headers map[string]string) map[string]string {
+	original := make(map[string]string, len(headers))
+	for k, v := range headers {
+		if !strings.HasPrefix(k, headerPrefix) {
+			original[k] = v
+		}
+	}
+	return original
+}
@@ -0,0 +1,239 @@
+package kafkaclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// ErrDeadLetterNotFound is returned for an ID that is not an unresolved dead
+// letter
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// Resolutions of a dead letter
+const (
+	ResolutionRequeued  = "requeued"
+	ResolutionDiscarded = "discarded"
+)
+
+// DeadLetter is a message a consumer group gave up on
+type DeadLetter struct {
+	ID        string            `json:"id"`    // Its position in the dead-letter topic, "<partition>-<offset>"
+	Topic     string            `json:"topic"` // Where it was originally published
+	Partition int32             `json:"partition"`
+	Offset    int64             `json:"offset"`
+	Key       string            `json:"key,omitempty"`
+	Value     string            `json:"value"`
+	Headers   map[string]string `json:"headers,omitempty"` // Its original headers
+	Reason    string            `json:"reason"`
+	FailedAt  time.Time         `json:"failed_at"`
+	Retries   int               `json:"retries"` // Retry tiers it went through
+}
+
+// DeadLetterQueue inspects and resolves a consumer group's dead letters.
+// Kafka cannot delete single messages, so resolving one appends a marker to
+// the dead-letter topic and List leaves resolved ones out. Dead letters and
+// markers are removed by the topic's retention.
+type DeadLetterQueue struct {
+	group    string
+	topic    string
+	client   sarama.Client
+	consumer sarama.Consumer
+	producer Publisher
+}
+
+// OpenDeadLetterQueue connects to the dead-letter topic of a consumer group
+func OpenDeadLetterQueue(brokers []string, group string) (*DeadLetterQueue, error) {
+	config := sarama.NewConfig()
+	config.Metadata.AllowAutoTopicCreation = false
+	config.Producer.Return.Successes = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
+
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Kafka: %w", err)
+	}
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("failed to create Kafka consumer: %w", err)
+	}
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		_ = consumer.Close()
+		_ = client.Close()
+		return nil, fmt.Errorf("failed to create Kafka producer: %w", err)
+	}
+
+	return &DeadLetterQueue{
+		group:    group,
+		topic:    DeadLetterTopic(group),
+		client:   client,
+		consumer: consumer,
+		producer: &Client{producer: producer, brokers: brokers},
+	}, nil
+}
+
+// List returns the unresolved dead letters, oldest first within each
+// partition of the dead-letter topic
+func (q *DeadLetterQueue) List(ctx context.Context) ([]*DeadLetter, error) {
+	var letters []*DeadLetter
+	resolved := make(map[string]bool)
+
+	partitions, err := q.consumer.Partitions(q.topic)
+	if errors.Is(err, sarama.ErrUnknownTopicOrPartition) {
+		return nil, nil // Nothing was ever dead-lettered
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions of %s: %w", q.topic, err)
+	}
+
+	for _, partition := range partitions {
+		err := q.readPartition(ctx, partition, func(msg *sarama.ConsumerMessage) {
+			headers := newMessage(msg).Headers
+			if id, ok := headers[headerResolves]; ok {
+				resolved[id] = true
+				return
+			}
+			letters = append(letters, newDeadLetter(msg, headers))
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	unresolved := letters[:0]
+	for _, letter := range letters {
+		if !resolved[letter.ID] {
+			unresolved = append(unresolved, letter)
+		}
+	}
+	return unresolved, nil
+}
+
+// readPartition reads a partition of the dead-letter topic from its oldest
+// retained message up to its last one
+func (q *DeadLetterQueue) readPartition(ctx context.Context, partition int32, fn func(*sarama.ConsumerMessage)) error {
+	oldest, err := q.client.GetOffset(q.topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return fmt.Errorf("failed to read %s/%d: %w", q.topic, partition, err)
+	}
+	newest, err := q.client.GetOffset(q.topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return fmt.Errorf("failed to read %s/%d: %w", q.topic, partition, err)
+	}
+	if oldest >= newest {
+		return nil
+	}
+
+	pc, err := q.consumer.ConsumePartition(q.topic, partition, oldest)
+	if err != nil {
+		return fmt.Errorf("failed to read %s/%d: %w", q.topic, partition, err)
+	}
+	defer pc.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-pc.Messages():
+			if !ok {
+				return fmt.Errorf("failed to read %s/%d: partition consumer closed", q.topic, partition)
+			}
+			fn(msg)
+			if msg.Offset >= newest-1 {
+				return nil
+			}
+		}
+	}
+}
+
+// Requeue sends dead letters back to the consumer group that failed them,
+// through its requeue topic, and marks them resolved. They go through the
+// group's retry tiers again if they still fail. A crash between the two
+// steps leaves a letter listed after it was requeued, so requeuing it again
+// delivers it twice.
+func (q *DeadLetterQueue) Requeue(ctx context.Context, ids ...string) error {
+	return q.resolve(ctx, ids, ResolutionRequeued, func(letter *DeadLetter) error {
+		headers := make(map[string]string, len(letter.Headers)+3)
+		for k, v := range letter.Headers {
+			headers[k] = v
+		}
+		headers[headerTopic] = letter.Topic
+		headers[headerPartition] = strconv.FormatInt(int64(letter.Partition), 10)
+		headers[headerOffset] = strconv.FormatInt(letter.Offset, 10)
+		return q.producer.PublishWithHeaders(RequeueTopic(q.group), letter.Key, []byte(letter.Value), headers)
+	})
+}
+
+// Discard marks dead letters resolved without handling them
+func (q *DeadLetterQueue) Discard(ctx context.Context, ids ...string) error {
+	return q.resolve(ctx, ids, ResolutionDiscarded, nil)
+}
+
+// resolve runs fn on each dead letter and appends its resolution marker.
+// Every ID is looked up before any is resolved.
+func (q *DeadLetterQueue) resolve(ctx context.Context, ids []string, resolution string, fn func(*DeadLetter) error) error {
+	letters, err := q.List(ctx)
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]*DeadLetter, len(letters))
+	for _, letter := range letters {
+		byID[letter.ID] = letter
+	}
+	for _, id := range ids {
+		if byID[id] == nil {
+			return fmt.Errorf("%w: %s", ErrDeadLetterNotFound, id)
+		}
+	}
+
+	for _, id := range ids {
+		letter := byID[id]
+		if fn != nil {
+			if err := fn(letter); err != nil {
+				return fmt.Errorf("failed to resolve dead letter %s: %w", id, err)
+			}
+		}
+		marker := map[string]string{
+			headerResolves:   id,
+			headerResolution: resolution,
+			headerTime:       time.Now().UTC().Format(time.RFC3339Nano),
+		}
+		if err := q.producer.PublishWithHeaders(q.topic, letter.Key, nil, marker); err != nil {
+			return fmt.Errorf("failed to mark dead letter %s %s: %w", id, resolution, err)
+		}
+	}
+	return nil
+}
+
+// Close disconnects from Kafka
+func (q *DeadLetterQueue) Close() error {
+	_ = q.producer.Close()
+	_ = q.consumer.Close()
+	return q.client.Close()
+}
+
+func newDeadLetter(msg *sarama.ConsumerMessage, headers map[string]string) *DeadLetter {
+	partition, _ := strconv.ParseInt(headers[headerPartition], 10, 32)
+	offset, _ := strconv.ParseInt(headers[headerOffset], 10, 64)
+	retries, _ := strconv.Atoi(headers[headerTier])
+	failedAt, _ := time.Parse(time.RFC3339Nano, headers[headerTime])
+
+	return &DeadLetter{
+		ID:        fmt.Sprintf("%d-%d", msg.Partition, msg.Offset),
+		Topic:     headers[headerTopic],
+		Partition: int32(partition),
+		Offset:    offset,
+		Key:       string(msg.Key),
+		Value:     string(msg.Value),
+		Headers:   originalHeaders(headers),
+		Reason:    headers[headerReason],
+		FailedAt:  failedAt,
+		Retries:   retries,
+	}
+}
@@ -0,0 +1,118 @@
+package kafkaclient
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SpecVersion is the CloudEvents specification the envelope follows
+const SpecVersion = "1.0"
+
+// ErrNotEnvelope is returned by ParseEnvelope for messages published before
+// events were wrapped in envelopes
+var ErrNotEnvelope = errors.New("message is not an event envelope")
+
+// Envelope wraps every published event, following the CloudEvents JSON
+// format (https://cloudevents.io) with the schemaversion, correlationid and
+// partitionkey extensions. Events are published to the topic named after
+// their type.
+type Envelope struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`     // Unique per event, for deduplication
+	Source          string          `json:"source"` // The producing service, e.g. "user-service"
+	Type            string          `json:"type"`   // e.g. "user.created"
+	SchemaVersion   int             `json:"schemaversion"`
+	Time            time.Time       `json:"time"` // When the event occurred
+	DataContentType string          `json:"datacontenttype"`
+	CorrelationID   string          `json:"correlationid"`          // Shared by events caused by the same operation
+	PartitionKey    string          `json:"partitionkey,omitempty"` // The aggregate's ID, the Kafka message key
+	Data            json.RawMessage `json:"data"`
+}
+
+type correlationKey struct{}
+
+// WithCorrelationID returns a context whose events continue the correlation
+// with the given ID
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// NewCorrelation returns a context whose events share a new correlation ID
+func NewCorrelation(ctx context.Context) context.Context {
+	return WithCorrelationID(ctx, newEventID())
+}
+
+// CorrelationID returns the correlation ID carried by ctx, or "" if there is
+// none
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
+
+// NewEnvelope wraps event as the latest schema version of eventType,
+// validating it against that schema. key is the ID of the aggregate the
+// event is about. The event continues the correlation carried by ctx, if
+// any, and otherwise starts its own.
+func NewEnvelope(ctx context.Context, source, eventType, key string, event interface{}) (*Envelope, error) {
+	schema, ok := Schemas.Latest(eventType)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSchema, eventType)
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event: %w", err)
+	}
+	if err := schema.Validate(data); err != nil {
+		return nil, err
+	}
+
+	id := newEventID()
+	correlationID := CorrelationID(ctx)
+	if correlationID == "" {
+		correlationID = id
+	}
+	return &Envelope{
+		SpecVersion:     SpecVersion,
+		ID:              id,
+		Source:          source,
+		Type:            eventType,
+		SchemaVersion:   schema.Version,
+		Time:            time.Now().UTC(),
+		DataContentType: "application/json",
+		CorrelationID:   correlationID,
+		PartitionKey:    key,
+		Data:            data,
+	}, nil
+}
+
+// ParseEnvelope decodes a message value. Values without a specversion are
+// reported as ErrNotEnvelope.
+func ParseEnvelope(value []byte) (*Envelope, error) {
+	var envelope Envelope
+	if err := json.Unmarshal(value, &envelope); err != nil || envelope.SpecVersion == "" {
+		return nil, ErrNotEnvelope
+	}
+	if envelope.ID == "" || envelope.Type == "" || len(envelope.Data) == 0 {
+		return nil, fmt.Errorf("%w: envelope is missing id, type or data", ErrInvalidEvent)
+	}
+	return &envelope, nil
+}
+
+// Decode unmarshals the event data into v
+func (e *Envelope) Decode(v interface{}) error {
+	return json.Unmarshal(e.Data, v)
+}
+
+// newEventID returns a random UUID (version 4)
+func newEventID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -1,13 +1,25 @@
 package kafkaclient
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/IBM/sarama"
 )
 
+// Publisher publishes encoded events. *Client publishes to Kafka and *Bus
+// in process.
+type Publisher interface {
+	// Publish sends value to topic. Values with the same key go to the same
+	// partition and are consumed in order.
+	Publish(topic, key string, value []byte) error
+	PublishWithHeaders(topic, key string, value []byte, headers map[string]string) error
+	Close() error
+}
+
 // Client wraps Kafka producer for event publishing
 type Client struct {
 	producer sarama.SyncProducer
@@ -32,27 +44,60 @@ func NewClient(brokers []string) (*Client, error) {
 	}, nil
 }
 
-// PublishEvent publishes an event to a Kafka topic
-func (c *Client) PublishEvent(topic string, event interface{}) error {
-	eventJSON, err := json.Marshal(event)
-	if err != nil {
-		return fmt.Errorf("failed to marshal event: %w", err)
+// Connect creates a client, retrying with backoff until the brokers are
+// reachable or ctx is cancelled
+func Connect(ctx context.Context, brokers []string) (*Client, error) {
+	backoff := time.Second
+	for {
+		client, err := NewClient(brokers)
+		if err == nil {
+			return client, nil
+		}
+		log.Printf("Failed to connect to Kafka, retrying in %s: %v", backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, 30*time.Second)
 	}
+}
 
+// Publish sends an already encoded event to a Kafka topic. Events with the
+// same key go to the same partition and are consumed in order.
+func (c *Client) Publish(topic, key string, value []byte) error {
+	return c.PublishWithHeaders(topic, key, value, nil)
+}
+
+// PublishWithHeaders is Publish with Kafka record headers
+func (c *Client) PublishWithHeaders(topic, key string, value []byte, headers map[string]string) error {
 	msg := &sarama.ProducerMessage{
 		Topic: topic,
-		Value: sarama.StringEncoder(eventJSON),
+		Value: sarama.ByteEncoder(value),
+	}
+	if key != "" {
+		msg.Key = sarama.StringEncoder(key)
+	}
+	for k, v := range headers {
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
 	}
 
-	partition, offset, err := c.producer.SendMessage(msg)
-	if err != nil {
+	if _, _, err := c.producer.SendMessage(msg); err != nil {
 		return fmt.Errorf("failed to send message: %w", err)
 	}
-
-	log.Printf("Event published to topic %s, partition %d, offset %d", topic, partition, offset)
 	return nil
 }
 
+// PublishEnvelope publishes an event to the topic named after its type
+func (c *Client) PublishEnvelope(envelope *Envelope) error {
+	value, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	return c.Publish(envelope.Type, envelope.PartitionKey, value)
+}
+
 // Close closes the Kafka producer
 func (c *Client) Close() error {
 	return c.producer.Close()
@@ -80,6 +125,42 @@ type ProductCreatedEvent struct {
 	CreatedAt string  `json:"created_at"`
 }
 
+// AuthSecurityEvent is published on the "auth.security" topic when
+// auth-service detects suspicious activity on an account
+type AuthSecurityEvent struct {
+	UserID     uint64 `json:"user_id"`
+	Type       string `json:"type"`
+	FamilyID   string `json:"family_id,omitempty"`
+	IP         string `json:"ip,omitempty"`
+	Reason     string `json:"reason"`
+	OccurredAt string `json:"occurred_at"`
+}
+
+// AuthAdminEvent is published on the "auth.admin" topic when an administrator
+// changes a user's account, for example deactivating it
+type AuthAdminEvent struct {
+	UserID     uint64 `json:"user_id"`
+	ActorID    uint64 `json:"actor_id"`
+	Action     string `json:"action"`
+	Reason     string `json:"reason,omitempty"`
+	OccurredAt string `json:"occurred_at"`
+}
+
+// AuthAuditEvent is published on the "auth.audit" topic for every entry
+// appended to auth-service's audit log
+type AuthAuditEvent struct {
+	ID           uint64 `json:"id"`
+	ActorID      uint64 `json:"actor_id,omitempty"`
+	TargetUserID uint64 `json:"target_user_id,omitempty"`
+	Action       string `json:"action"`
+	Outcome      string `json:"outcome"`
+	Reason       string `json:"reason,omitempty"`
+	Email        string `json:"email,omitempty"`
+	IP           string `json:"ip,omitempty"`
+	UserAgent    string `json:"user_agent,omitempty"`
+	OccurredAt   string `json:"occurred_at"`
+}
+
 type TaskCreatedEvent struct {
 	TaskID    uint64 `json:"task_id"`
 	UserID    uint64 `json:"user_id"`
@@ -0,0 +1,365 @@
+package kafkaclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownSchema   = errors.New("unknown event schema")
+	ErrInvalidEvent    = errors.New("event does not match its schema")
+	ErrIncompatible    = errors.New("schema is incompatible with an earlier version")
+	ErrVersionOrder    = errors.New("schema versions must be registered in order")
+	errNotJSONObject   = errors.New("expected a JSON object")
+	errUnsupportedType = errors.New("unsupported Go type")
+)
+
+// FieldType is the JSON type of an event field
+type FieldType string
+
+const (
+	TypeString  FieldType = "string"
+	TypeNumber  FieldType = "number"
+	TypeInteger FieldType = "integer"
+	TypeBoolean FieldType = "boolean"
+	TypeObject  FieldType = "object"
+	TypeArray   FieldType = "array"
+)
+
+// Field describes a field of an event
+type Field struct {
+	Type     FieldType        `json:"type"`
+	Required bool             `json:"required,omitempty"`
+	Fields   map[string]Field `json:"fields,omitempty"` // Of an object; nil allows any
+	Items    *Field           `json:"items,omitempty"`  // Of an array
+}
+
+// Schema describes the data of one version of an event type
+type Schema struct {
+	Type    string           `json:"type"`
+	Version int              `json:"version"`
+	Fields  map[string]Field `json:"fields"`
+}
+
+// SchemaOf derives a schema from example, a struct as it is marshalled to
+// JSON. Fields tagged omitempty and pointers are optional, all others are
+// required; time.Time is a string.
+func SchemaOf(eventType string, version int, example interface{}) (*Schema, error) {
+	t := reflect.TypeOf(example)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%w: %s event must be a struct", errUnsupportedType, eventType)
+	}
+	fields, err := structFields(t)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", eventType, err)
+	}
+	return &Schema{Type: eventType, Version: version, Fields: fields}, nil
+}
+
+func structFields(t reflect.Type) (map[string]Field, error) {
+	fields := make(map[string]Field)
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if name == "-" && opts == "" {
+			continue
+		}
+
+		// Embedded structs without a name are flattened, like encoding/json does
+		if sf.Anonymous && name == "" && sf.Type.Kind() == reflect.Struct {
+			embedded, err := structFields(sf.Type)
+			if err != nil {
+				return nil, err
+			}
+			for k, v := range embedded {
+				fields[k] = v
+			}
+			continue
+		}
+
+		if name == "" {
+			name = sf.Name
+		}
+		field, err := fieldOf(sf.Type)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", name, err)
+		}
+		field.Required = sf.Type.Kind() != reflect.Pointer && !strings.Contains(","+opts+",", ",omitempty,")
+		fields[name] = field
+	}
+	return fields, nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func fieldOf(t reflect.Type) (Field, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return Field{Type: TypeString}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return Field{Type: TypeString}, nil
+	case reflect.Bool:
+		return Field{Type: TypeBoolean}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Field{Type: TypeInteger}, nil
+	case reflect.Float32, reflect.Float64:
+		return Field{Type: TypeNumber}, nil
+	case reflect.Map:
+		return Field{Type: TypeObject}, nil
+	case reflect.Struct:
+		fields, err := structFields(t)
+		if err != nil {
+			return Field{}, err
+		}
+		return Field{Type: TypeObject, Fields: fields}, nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return Field{Type: TypeString}, nil // Base64
+		}
+		items, err := fieldOf(t.Elem())
+		if err != nil {
+			return Field{}, err
+		}
+		return Field{Type: TypeArray, Items: &items}, nil
+	}
+	return Field{}, fmt.Errorf("%w %s", errUnsupportedType, t)
+}
+
+// Validate checks that data, a JSON object, has every required field and
+// that known fields have the right type. Unknown fields are allowed, so data
+// from a newer compatible version validates against an older schema.
+func (s *Schema) Validate(data []byte) error {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidEvent, err)
+	}
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%w: %v", ErrInvalidEvent, errNotJSONObject)
+	}
+	if err := validateFields(s.Fields, object, ""); err != nil {
+		return fmt.Errorf("%w: %s v%d: %v", ErrInvalidEvent, s.Type, s.Version, err)
+	}
+	return nil
+}
+
+func validateFields(fields map[string]Field, object map[string]interface{}, path string) error {
+	for _, name := range sortedNames(fields) {
+		field := fields[name]
+		value, present := object[name]
+		if !present || value == nil {
+			if field.Required {
+				return fmt.Errorf("%s%s is required", path, name)
+			}
+			continue
+		}
+		if err := validateValue(field, value, path+name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateValue(field Field, value interface{}, path string) error {
+	ok := false
+	switch field.Type {
+	case TypeString:
+		_, ok = value.(string)
+	case TypeBoolean:
+		_, ok = value.(bool)
+	case TypeNumber:
+		_, ok = value.(json.Number)
+	case TypeInteger:
+		if n, isNumber := value.(json.Number); isNumber {
+			ok = !strings.ContainsAny(n.String(), ".eE")
+		}
+	case TypeObject:
+		var object map[string]interface{}
+		if object, ok = value.(map[string]interface{}); ok && field.Fields != nil {
+			return validateFields(field.Fields, object, path+".")
+		}
+	case TypeArray:
+		var items []interface{}
+		if items, ok = value.([]interface{}); ok && field.Items != nil {
+			for i, item := range items {
+				if err := validateValue(*field.Items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if !ok {
+		return fmt.Errorf("%s must be of type %s", path, field.Type)
+	}
+	return nil
+}
+
+// CheckCompatibility reports whether events of the two schema versions can
+// be read with either: both require the same fields, and fields in both have
+// the same type. Optional fields may be added and removed. This lets
+// producers move to a new version before or after their consumers.
+func CheckCompatibility(older, newer *Schema) error {
+	if err := compatibleFields(older.Fields, newer.Fields, ""); err != nil {
+		return fmt.Errorf("%w: %s v%d to v%d: %v", ErrIncompatible, newer.Type, older.Version, newer.Version, err)
+	}
+	return nil
+}
+
+func compatibleFields(older, newer map[string]Field, path string) error {
+	for _, name := range sortedNames(older) {
+		o := older[name]
+		n, ok := newer[name]
+		switch {
+		case !ok && o.Required:
+			return fmt.Errorf("required field %s%s was removed", path, name)
+		case !ok:
+			continue
+		case o.Required && !n.Required:
+			return fmt.Errorf("required field %s%s became optional", path, name)
+		case !o.Required && n.Required:
+			return fmt.Errorf("optional field %s%s became required", path, name)
+		}
+		if err := compatibleField(o, n, path+name); err != nil {
+			return err
+		}
+	}
+	for _, name := range sortedNames(newer) {
+		if _, ok := older[name]; !ok && newer[name].Required {
+			return fmt.Errorf("required field %s%s was added", path, name)
+		}
+	}
+	return nil
+}
+
+func compatibleField(older, newer Field, path string) error {
+	if older.Type != newer.Type {
+		return fmt.Errorf("field %s changed type from %s to %s", path, older.Type, newer.Type)
+	}
+	switch older.Type {
+	case TypeObject:
+		if older.Fields != nil && newer.Fields != nil {
+			return compatibleFields(older.Fields, newer.Fields, path+".")
+		}
+	case TypeArray:
+		if older.Items != nil && newer.Items != nil {
+			return compatibleField(*older.Items, *newer.Items, path+"[]")
+		}
+	}
+	return nil
+}
+
+func sortedNames(fields map[string]Field) []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Registry holds the schema versions of each event type
+type Registry struct {
+	mu      sync.RWMutex
+	schemas map[string][]*Schema // By type, in version order starting at 1
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{schemas: make(map[string][]*Schema)}
+}
+
+// Register adds the next version of an event type. It must be compatible
+// with every earlier version.
+func (r *Registry) Register(schema *Schema) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	versions := r.schemas[schema.Type]
+	if schema.Version != len(versions)+1 {
+		return fmt.Errorf("%w: %s is at v%d, got v%d", ErrVersionOrder, schema.Type, len(versions), schema.Version)
+	}
+	for _, older := range versions {
+		if err := CheckCompatibility(older, schema); err != nil {
+			return err
+		}
+	}
+	r.schemas[schema.Type] = append(versions, schema)
+	return nil
+}
+
+// MustRegister derives a schema from example with SchemaOf and registers it,
+// panicking on error. It is meant for registering event types at startup.
+func (r *Registry) MustRegister(eventType string, version int, example interface{}) {
+	schema, err := SchemaOf(eventType, version, example)
+	if err == nil {
+		err = r.Register(schema)
+	}
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Latest returns the newest version of an event type
+func (r *Registry) Latest(eventType string) (*Schema, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	versions := r.schemas[eventType]
+	if len(versions) == 0 {
+		return nil, false
+	}
+	return versions[len(versions)-1], true
+}
+
+// Validate checks data against the given version of an event type. Versions
+// newer than the registry knows are checked against its latest one, which
+// compatibility guarantees they satisfy.
+func (r *Registry) Validate(eventType string, version int, data []byte) error {
+	r.mu.RLock()
+	versions := r.schemas[eventType]
+	r.mu.RUnlock()
+
+	if len(versions) == 0 || version < 1 {
+		return fmt.Errorf("%w: %s v%d", ErrUnknownSchema, eventType, version)
+	}
+	if version > len(versions) {
+		version = len(versions)
+	}
+	return versions[version-1].Validate(data)
+}
+
+// Schemas is the registry of the platform's events, used by NewEnvelope and
+// consumers unless configured otherwise. New versions of an event type are
+// registered here next to the struct that defines them.
+var Schemas = NewRegistry()
+
+func init() {
+	Schemas.MustRegister("user.created", 1, UserCreatedEvent{})
+	Schemas.MustRegister("user.updated", 1, UserUpdatedEvent{})
+	Schemas.MustRegister("product.created", 1, ProductCreatedEvent{})
+	Schemas.MustRegister("task.created", 1, TaskCreatedEvent{})
+	Schemas.MustRegister("auth.security", 1, AuthSecurityEvent{})
+	Schemas.MustRegister("auth.admin", 1, AuthAdminEvent{})
+	Schemas.MustRegister("auth.audit", 1, AuthAuditEvent{})
+}
@@ -0,0 +1,155 @@
+package kafkaclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/IBM/sarama"
+)
+
+// ErrGroupClosed is returned by ConsumerGroup.Consume once the member has
+// left the group
+var ErrGroupClosed = errors.New("kafkaclient: consumer group is closed")
+
+// Subscriber joins consumer groups: Kafka's for the Subscriber from
+// NewSubscriber, in-process ones for *Bus.
+type Subscriber interface {
+	// JoinGroup creates a member of group. A group without committed
+	// offsets starts at the oldest retained messages if fromOldest is set,
+	// otherwise at new ones.
+	JoinGroup(group string, fromOldest bool) (ConsumerGroup, error)
+}
+
+// ConsumerGroup is a member of a consumer group. The group's members share
+// the partitions of the topics they consume, so each message is delivered
+// to one member.
+type ConsumerGroup interface {
+	// Consume takes part in one generation of the group: it calls consume in
+	// its own goroutine for each partition assigned to the member and
+	// returns once ctx is cancelled or the group rebalances, after every
+	// consume call has returned. Call it in a loop to stay in the group.
+	Consume(ctx context.Context, topics []string, consume func(Claim)) error
+
+	// Close leaves the group. Consume then returns ErrGroupClosed.
+	Close() error
+}
+
+// Claim is a partition assigned to a consumer group member for one
+// generation of the group
+type Claim interface {
+	// Context is cancelled when the partition is revoked
+	Context() context.Context
+
+	// Messages delivers the partition's messages from the group's committed
+	// offset on
+	Messages() <-chan *Message
+
+	// Commit records that every message before offset has been handled. It
+	// may take effect later, but at the latest when the member leaves the
+	// group.
+	Commit(offset int64)
+}
+
+// kafkaSubscriber joins Kafka consumer groups
+type kafkaSubscriber struct {
+	brokers []string
+}
+
+// NewSubscriber creates a Subscriber for the Kafka cluster at brokers
+func NewSubscriber(brokers []string) Subscriber {
+	return &kafkaSubscriber{brokers: brokers}
+}
+
+func (s *kafkaSubscriber) JoinGroup(group string, fromOldest bool) (ConsumerGroup, error) {
+	config := sarama.NewConfig()
+	config.Consumer.Offsets.AutoCommit.Enable = true
+	config.Consumer.Offsets.Initial = sarama.OffsetNewest
+	if fromOldest {
+		config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	}
+	config.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{sarama.NewBalanceStrategySticky()}
+
+	consumerGroup, err := sarama.NewConsumerGroup(s.brokers, group, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka consumer group: %w", err)
+	}
+	return &kafkaGroup{group: consumerGroup}, nil
+}
+
+// kafkaGroup is a member of a Kafka consumer group
+type kafkaGroup struct {
+	group sarama.ConsumerGroup
+}
+
+func (g *kafkaGroup) Consume(ctx context.Context, topics []string, consume func(Claim)) error {
+	err := g.group.Consume(ctx, topics, &groupHandler{consume: consume})
+	if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+		return ErrGroupClosed
+	}
+	return err
+}
+
+func (g *kafkaGroup) Close() error {
+	return g.group.Close()
+}
+
+// groupHandler hands the partitions assigned during one generation of the
+// group to consume
+type groupHandler struct {
+	consume func(Claim)
+}
+
+func (h *groupHandler) Setup(sarama.ConsumerGroupSession) error   { return nil }
+func (h *groupHandler) Cleanup(sarama.ConsumerGroupSession) error { return nil }
+
+func (h *groupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	ctx, cancel := context.WithCancel(session.Context())
+	defer cancel()
+
+	messages := make(chan *Message)
+	go func() {
+		defer close(messages)
+		for raw := range claim.Messages() {
+			select {
+			case messages <- newMessage(raw):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	h.consume(&kafkaClaim{ctx: ctx, session: session, claim: claim, messages: messages})
+	return nil
+}
+
+// kafkaClaim is a partition of a Kafka topic claimed by a group member
+type kafkaClaim struct {
+	ctx      context.Context
+	session  sarama.ConsumerGroupSession
+	claim    sarama.ConsumerGroupClaim
+	messages chan *Message
+}
+
+func (c *kafkaClaim) Context() context.Context  { return c.ctx }
+func (c *kafkaClaim) Messages() <-chan *Message { return c.messages }
+
+func (c *kafkaClaim) Commit(offset int64) {
+	c.session.MarkOffset(c.claim.Topic(), c.claim.Partition(), offset, "")
+}
+
+func newMessage(raw *sarama.ConsumerMessage) *Message {
+	headers := make(map[string]string, len(raw.Headers))
+	for _, header := range raw.Headers {
+		headers[string(header.Key)] = string(header.Value)
+	}
+	return &Message{
+		Topic:     raw.Topic,
+		Partition: raw.Partition,
+		Offset:    raw.Offset,
+		Key:       raw.Key,
+		Value:     raw.Value,
+		Headers:   headers,
+		Timestamp: raw.Timestamp,
+	}
+}
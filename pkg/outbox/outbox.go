@@ -0,0 +1,232 @@
+// Package outbox implements the transactional outbox pattern: services write
+// their domain events to an outbox table in the same transaction as the
+// change that causes them, and a relay publishes them to Kafka afterwards.
+// An event is therefore published if and only if its change commits, even
+// when Kafka is unavailable at the time.
+//
+// Delivery is at least once: an event may be published again if the relay
+// stops between publishing it and recording that. Events are published in ID
+// order, and IDs are assigned at insert rather than at commit, so events of
+// different aggregates may be published out of commit order. The events of
+// one aggregate are in order, because the row lock its change takes makes a
+// second writer wait until the first commits; with the aggregate's ID as key
+// they land in one partition and reach consumers in order.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/my-username/billion-user-app/pkg/kafkaclient"
+	"gorm.io/gorm"
+)
+
+// Event is a domain event waiting in, or already published from, the outbox
+type Event struct {
+	ID          uint64 `gorm:"primaryKey"` // Publishing order
+	Topic       string `gorm:"not null"`
+	Key         string `gorm:"not null;default:''"` // The aggregate's ID, used as the Kafka message key
+	Payload     []byte `gorm:"not null"`            // The kafkaclient.Envelope as JSON
+	Attempts    int    `gorm:"not null;default:0"`
+	LastError   string
+	CreatedAt   time.Time
+	PublishedAt *time.Time `gorm:"index"`
+}
+
+// TableName stores events in "outbox_events"
+func (Event) TableName() string {
+	return "outbox_events"
+}
+
+// Enqueue adds an event to the outbox. Pass the transaction that makes the
+// change the event describes, so that both commit or neither does. The event
+// is published to the topic named after its type, keyed by its partition key.
+func Enqueue(tx *gorm.DB, envelope *kafkaclient.Envelope) error {
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	return tx.Create(&Event{Topic: envelope.Type, Key: envelope.PartitionKey, Payload: payload}).Error
+}
+
+// RelayConfig configures a Relay. Zero values select the defaults.
+type RelayConfig struct {
+	BatchSize    int           // Events published per transaction, default 100
+	PollInterval time.Duration // Wait when the outbox is empty, default 1s
+	MaxBackoff   time.Duration // Longest wait after failures, default 30s
+	Retention    time.Duration // How long published events are kept, default 24h
+}
+
+// Relay publishes the events in the outbox. Every replica of a service can
+// run one: a Postgres advisory lock lets only one at a time publish, which
+// keeps events in order.
+type Relay struct {
+	db        *gorm.DB
+	publisher kafkaclient.Publisher
+	cfg       RelayConfig
+}
+
+// relayLockKey identifies the advisory lock held while publishing. Each
+// service has its own database, so one key serves them all.
+const relayLockKey = 7_201_524_300
+
+// busPartitions is the number of partitions per topic of the bus Start falls
+// back to
+const busPartitions = 4
+
+// cleanupInterval is how often published events past the retention are deleted
+const cleanupInterval = 10 * time.Minute
+
+// NewRelay creates a relay publishing the events in db's outbox
+func NewRelay(db *gorm.DB, publisher kafkaclient.Publisher, cfg RelayConfig) *Relay {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 30 * time.Second
+	}
+	if cfg.Retention <= 0 {
+		cfg.Retention = 24 * time.Hour
+	}
+	return &Relay{db: db, publisher: publisher, cfg: cfg}
+}
+
+// Run publishes events until ctx is cancelled. Failures are retried with
+// exponential backoff; a failing event holds back the ones after it.
+func (r *Relay) Run(ctx context.Context) {
+	backoff := r.cfg.PollInterval
+	lastCleanup := time.Now()
+
+	for {
+		published, err := r.publishBatch()
+		wait := time.Duration(0)
+		switch {
+		case err != nil:
+			log.Printf("Outbox relay failed, retrying in %s: %v", backoff, err)
+			wait = backoff
+			backoff = min(2*backoff, r.cfg.MaxBackoff)
+		case published < r.cfg.BatchSize:
+			// Caught up, or another replica holds the lock
+			wait = r.cfg.PollInterval
+			backoff = r.cfg.PollInterval
+		default:
+			backoff = r.cfg.PollInterval
+		}
+
+		if time.Since(lastCleanup) >= cleanupInterval {
+			r.cleanup()
+			lastCleanup = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Start runs a relay in the background until ctx is cancelled, publishing to
+// Kafka at brokers once they are reachable. Without brokers, as when running
+// locally without Kafka, events are published to a file-backed
+// kafkaclient.Bus in busDir instead.
+func Start(ctx context.Context, db *gorm.DB, brokers []string, busDir string) {
+	brokers = nonEmpty(brokers)
+	if len(brokers) == 0 {
+		bus, err := kafkaclient.OpenBus(busDir, busPartitions)
+		if err != nil {
+			log.Printf("No Kafka brokers configured and failed to open event bus, events stay in the outbox: %v", err)
+			return
+		}
+		log.Printf("No Kafka brokers configured, publishing events to %s", busDir)
+		go func() {
+			defer bus.Close()
+			NewRelay(db, bus, RelayConfig{}).Run(ctx)
+		}()
+		return
+	}
+
+	go func() {
+		client, err := kafkaclient.Connect(ctx, brokers)
+		if err != nil {
+			log.Printf("Outbox relay stopped before Kafka was reachable: %v", err)
+			return
+		}
+		defer client.Close()
+		NewRelay(db, client, RelayConfig{}).Run(ctx)
+	}()
+}
+
+func nonEmpty(values []string) []string {
+	var out []string
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// publishBatch publishes the oldest unpublished events in order, stopping at
+// the first failure, and returns how many were published
+func (r *Relay) publishBatch() (int, error) {
+	published := 0
+	var publishErr error
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var locked bool
+		if err := tx.Raw("SELECT pg_try_advisory_xact_lock(?)", relayLockKey).Scan(&locked).Error; err != nil {
+			return err
+		}
+		if !locked {
+			return nil
+		}
+
+		var events []*Event
+		if err := tx.Where("published_at IS NULL").Order("id").Limit(r.cfg.BatchSize).Find(&events).Error; err != nil {
+			return err
+		}
+
+		ids := make([]uint64, 0, len(events))
+		for _, event := range events {
+			if publishErr = r.publisher.Publish(event.Topic, event.Key, event.Payload); publishErr != nil {
+				publishErr = fmt.Errorf("event %d to %s: %w", event.ID, event.Topic, publishErr)
+				if err := tx.Model(event).Updates(map[string]interface{}{
+					"attempts":   gorm.Expr("attempts + 1"),
+					"last_error": publishErr.Error(),
+				}).Error; err != nil {
+					return err
+				}
+				break
+			}
+			ids = append(ids, event.ID)
+		}
+
+		// Record the progress made before a failure too
+		if len(ids) > 0 {
+			if err := tx.Model(&Event{}).Where("id IN ?", ids).Update("published_at", time.Now()).Error; err != nil {
+				return err
+			}
+		}
+		published = len(ids)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return published, publishErr
+}
+
+// cleanup deletes published events older than the retention
+func (r *Relay) cleanup() {
+	cutoff := time.Now().Add(-r.cfg.Retention)
+	if err := r.db.Where("published_at < ?", cutoff).Delete(&Event{}).Error; err != nil {
+		log.Printf("Failed to clean up outbox: %v", err)
+	}
+}
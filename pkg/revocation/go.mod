@@ -0,0 +1,16 @@
+module github.com/my-username/billion-user-app/pkg/revocation
+
+go 1.21.0
+
+require (
+	github.com/my-username/billion-user-app/pkg/jwtutils v0.0.0
+	github.com/redis/go-redis/v9 v9.5.1
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/golang-jwt/jwt/v5 v5.2.0 // indirect
+)
+
+replace github.com/my-username/billion-user-app/pkg/jwtutils => ../jwtutils
@@ -0,0 +1,247 @@
+// Package revocation keeps the list of access tokens that were revoked before
+// their expiry. auth-service writes entries on logout, session revocation and
+// account deactivation; every service checks them through
+// jwtutils.JWTManager.
+//
+// Entries live in Redis and only need to outlive the access tokens they
+// revoke, so they expire after the access-token lifetime. Lookups are cached
+// locally for a few seconds, and new revocations are pushed to every
+// instance over Redis pub/sub so that the cache does not delay them.
+package revocation
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/my-username/billion-user-app/pkg/jwtutils"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	keyPrefix = "revoked:"
+	channel   = "revocations"
+
+	// How long a lookup that found nothing is trusted locally
+	negativeCacheTTL = 5 * time.Second
+	// Sweep the local cache once it grows past this many entries
+	maxCacheEntries = 10000
+
+	opTimeout = 200 * time.Millisecond
+	// How long lookups skip Redis after one failed, so an outage does not
+	// add opTimeout to every request
+	outageBackoff = time.Second
+)
+
+var errUnavailable = errors.New("revocation list unavailable")
+
+type cacheEntry struct {
+	value   string
+	found   bool
+	expires time.Time
+}
+
+// Store is a Redis-backed revocation list with a local cache
+type Store struct {
+	client   *redis.Client
+	tokenTTL time.Duration
+
+	mu        sync.Mutex
+	cache     map[string]cacheEntry
+	downUntil time.Time // Set while Redis is failing
+}
+
+// NewStore creates a revocation list. tokenTTL is the lifetime of access
+// tokens, which bounds how long entries are kept. The client must have
+// ContextTimeoutEnabled set, or a slow Redis holds requests up for its read
+// timeout rather than a fraction of a second.
+func NewStore(client *redis.Client, tokenTTL time.Duration) *Store {
+	return &Store{
+		client:   client,
+		tokenTTL: tokenTTL,
+		cache:    make(map[string]cacheEntry),
+	}
+}
+
+// RevokeToken revokes a single access token by its "jti" until it expires
+func (s *Store) RevokeToken(tokenID string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if tokenID == "" || ttl <= 0 {
+		return nil
+	}
+	return s.set(tokenKey(tokenID), "1", ttl)
+}
+
+// RevokeSession revokes every access token bound to a session
+func (s *Store) RevokeSession(sessionID string) error {
+	if sessionID == "" {
+		return nil
+	}
+	return s.set(sessionKey(sessionID), "1", s.tokenTTL)
+}
+
+// RevokeUser revokes every access token of the user issued at or before at.
+// Tokens issued in the same second are revoked too, since "iat" only has
+// second precision.
+func (s *Store) RevokeUser(userID uint64, at time.Time) error {
+	return s.set(userKey(userID), strconv.FormatInt(at.Unix(), 10), s.tokenTTL)
+}
+
+// IsRevoked implements jwtutils.RevocationChecker. It fails open: if Redis is
+// unreachable tokens are accepted until they expire, as they were before
+// revocation existed. While it is, only one lookup a second goes to Redis to
+// see if it is back; the others fail open right away. Revoking an
+// administrator's tokens also revokes the tokens they impersonate users with.
+func (s *Store) IsRevoked(claims *jwtutils.Claims) bool {
+	userKeys := map[string]bool{userKey(claims.UserID): true}
+	if claims.Actor != nil {
+		userKeys[userKey(claims.Actor.UserID)] = true
+	}
+
+	keys := make([]string, 0, len(userKeys)+2)
+	for key := range userKeys {
+		keys = append(keys, key)
+	}
+	if claims.ID != "" {
+		keys = append(keys, tokenKey(claims.ID))
+	}
+	if claims.SessionID != "" {
+		keys = append(keys, sessionKey(claims.SessionID))
+	}
+
+	entries, err := s.lookup(keys)
+	if err != nil {
+		return false
+	}
+
+	for key, entry := range entries {
+		if !entry.found {
+			continue
+		}
+		if !userKeys[key] {
+			return true
+		}
+
+		cutoff, err := strconv.ParseInt(entry.value, 10, 64)
+		if err == nil && claims.IssuedAt != nil && claims.IssuedAt.Unix() <= cutoff {
+			return true
+		}
+	}
+	return false
+}
+
+// Listen applies revocations published by other instances to the local
+// cache until ctx is cancelled. Without it revocations still take effect,
+// but only once cached lookups expire.
+func (s *Store) Listen(ctx context.Context) {
+	sub := s.client.Subscribe(ctx, channel)
+	defer sub.Close()
+
+	for msg := range sub.Channel() {
+		key, value, ok := strings.Cut(msg.Payload, " ")
+		if !ok || !strings.HasPrefix(key, keyPrefix) {
+			continue
+		}
+		s.remember(key, cacheEntry{value: value, found: true, expires: time.Now().Add(s.tokenTTL)})
+	}
+}
+
+func (s *Store) set(key, value string, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
+	defer cancel()
+
+	if err := s.client.Set(ctx, key, value, ttl).Err(); err != nil {
+		return err
+	}
+	s.remember(key, cacheEntry{value: value, found: true, expires: time.Now().Add(ttl)})
+
+	// Best effort: instances that miss the message pick the entry up from
+	// Redis once their cached lookup expires
+	_ = s.client.Publish(ctx, channel, key+" "+value).Err()
+	return nil
+}
+
+// lookup resolves keys from the local cache, fetching the rest from Redis in
+// a single round trip
+func (s *Store) lookup(keys []string) (map[string]cacheEntry, error) {
+	now := time.Now()
+	entries := make(map[string]cacheEntry, len(keys))
+	var missing []string
+
+	s.mu.Lock()
+	for _, key := range keys {
+		if entry, ok := s.cache[key]; ok && now.Before(entry.expires) {
+			entries[key] = entry
+		} else {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 && !s.downUntil.IsZero() {
+		if now.Before(s.downUntil) {
+			s.mu.Unlock()
+			return nil, errUnavailable
+		}
+		// Probe Redis with this lookup while the others keep failing open
+		s.downUntil = now.Add(outageBackoff)
+	}
+	s.mu.Unlock()
+
+	if len(missing) == 0 {
+		return entries, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
+	defer cancel()
+
+	values, err := s.client.MGet(ctx, missing...).Result()
+	s.mu.Lock()
+	if err != nil {
+		s.downUntil = time.Now().Add(outageBackoff)
+	} else {
+		s.downUntil = time.Time{}
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, key := range missing {
+		entry := cacheEntry{expires: now.Add(negativeCacheTTL)}
+		if value, ok := values[i].(string); ok {
+			entry = cacheEntry{value: value, found: true, expires: now.Add(s.tokenTTL)}
+		}
+		entries[key] = entry
+		s.remember(key, entry)
+	}
+	return entries, nil
+}
+
+func (s *Store) remember(key string, entry cacheEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.cache) >= maxCacheEntries {
+		now := time.Now()
+		for k, e := range s.cache {
+			if now.After(e.expires) {
+				delete(s.cache, k)
+			}
+		}
+	}
+	s.cache[key] = entry
+}
+
+func tokenKey(tokenID string) string {
+	return keyPrefix + "jti:" + tokenID
+}
+
+func sessionKey(sessionID string) string {
+	return keyPrefix + "sid:" + sessionID
+}
+
+func userKey(userID uint64) string {
+	return keyPrefix + "user:" + strconv.FormatUint(userID, 10)
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"log"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -9,17 +11,24 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	fiberlogger "github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/redis/go-redis/v9"
 
 	"github.com/my-username/billion-user-app/pkg/config"
 	"github.com/my-username/billion-user-app/pkg/database"
 	"github.com/my-username/billion-user-app/pkg/jwtutils"
-	"github.com/my-username/billion-user-app/pkg/kafkaclient"
 	app_logger "github.com/my-username/billion-user-app/pkg/logger"
+	"github.com/my-username/billion-user-app/pkg/outbox"
+	"github.com/my-username/billion-user-app/pkg/revocation"
 
 	"github.com/my-username/billion-user-app/services/auth-service/internal/domain"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/federation"
 	"github.com/my-username/billion-user-app/services/auth-service/internal/handler"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/lockout"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/mailer"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/passwords"
 	"github.com/my-username/billion-user-app/services/auth-service/internal/repository"
 	"github.com/my-username/billion-user-app/services/auth-service/internal/service"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/webauthn"
 )
 
 func main() {
@@ -40,28 +49,165 @@ func main() {
 	}
 
 	// Auto-migrate
-	if err := db.AutoMigrate(&domain.User{}, &domain.RefreshToken{}); err != nil {
+	if err := db.AutoMigrate(&domain.Role{}, &domain.User{}, &domain.RefreshToken{},
+		&domain.Session{}, &domain.APIKey{}, &domain.OAuthClient{}, &domain.AuthorizationCode{}, &domain.OAuthConsent{},
+		&domain.ExternalIdentity{}, &domain.RecoveryCode{}, &domain.PasswordHistory{}, &domain.OneTimeToken{},
+		&domain.AuditEntry{}, &domain.Passkey{}, &outbox.Event{}); err != nil {
 		appLogger.Fatal().Err(err).Msg("Failed to migrate database")
 	}
 
-	// Initialize Kafka client
-	var kafkaClient *kafkaclient.Client
-	brokers := strings.Split(cfg.KafkaBrokers, ",")
-	if len(brokers) > 0 && brokers[0] != "" {
-		kafkaClient, err = kafkaclient.NewClient(brokers)
+	// Publish the events written to the outbox
+	outbox.Start(context.Background(), db, strings.Split(cfg.KafkaBrokers, ","), filepath.Join(cfg.EventBusDir, "auth-service"))
+
+	// Initialize JWT manager. With a key directory configured, tokens are
+	// signed with a private key and the public keys are published as a JWKS;
+	// otherwise the legacy shared secret is used.
+	var jwtManager *jwtutils.JWTManager
+	if cfg.JWTKeysDir != "" {
+		keySet, err := jwtutils.LoadKeySet(cfg.JWTKeysDir, cfg.JWTActiveKeyID)
 		if err != nil {
-			appLogger.Warn().Err(err).Msg("Failed to connect to Kafka, continuing without events")
+			appLogger.Fatal().Err(err).Msg("Failed to load JWT signing keys")
 		}
-	}
+		jwtManager = jwtutils.NewSigningJWTManager(keySet, 15*time.Minute)
 
-	// Initialize JWT manager
-	jwtManager := jwtutils.NewJWTManager(cfg.JWTSecret, 15*time.Minute)
+		// Pick up rotated keys without a restart
+		go func() {
+			for range time.Tick(time.Minute) {
+				if err := keySet.Reload(); err != nil {
+					appLogger.Error().Err(err).Msg("Failed to reload JWT signing keys")
+				}
+			}
+		}()
+	} else {
+		jwtManager = jwtutils.NewJWTManager(cfg.JWTSecret, 15*time.Minute)
+	}
 
 	// Initialize repository
 	authRepo := repository.NewAuthRepository(db)
 
+	// The audit log is append-only
+	if err := authRepo.ProtectAuditLog(); err != nil {
+		appLogger.Fatal().Err(err).Msg("Failed to protect audit log")
+	}
+
+	// Initialize mailer
+	authMailer, err := mailer.New(mailer.Config{
+		Driver:    cfg.MailerDriver,
+		From:      cfg.MailFrom,
+		SMTPHost:  cfg.SMTPHost,
+		SMTPPort:  cfg.SMTPPort,
+		Username:  cfg.SMTPUsername,
+		Password:  cfg.SMTPPassword,
+		OutputDir: cfg.MailOutputDir,
+	})
+	if err != nil {
+		appLogger.Fatal().Err(err).Msg("Failed to initialize mailer")
+	}
+
+	// Failed-login tracking is shared between replicas through Redis. If Redis
+	// is unreachable we fall back to per-process counters.
+	var attemptStore lockout.Store
+	redisClient := redis.NewClient(&redis.Options{Addr: cfg.RedisAddress, ContextTimeoutEnabled: true})
+	if err := redisClient.Ping(context.Background()).Err(); err != nil {
+		appLogger.Warn().Err(err).Msg("Failed to connect to Redis, using in-memory login attempt tracking")
+		attemptStore = lockout.NewMemoryStore()
+	} else {
+		attemptStore = lockout.NewRedisStore(redisClient)
+	}
+
+	// Revoked access tokens are shared with the other services through Redis
+	revocations := revocation.NewStore(redisClient, 15*time.Minute)
+	go revocations.Listen(context.Background())
+	jwtManager.SetRevocationChecker(revocations)
+
+	// External identity providers redirect back to auth-service's callback
+	var identityProviders []*federation.Provider
+	for _, p := range cfg.OIDCProviders {
+		identityProviders = append(identityProviders, federation.NewProvider(federation.Config{
+			Name:         p.Name,
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RedirectURL:  cfg.OIDCIssuer + "/api/v1/login/external/" + p.Name + "/callback",
+			Scopes:       p.Scopes,
+		}))
+	}
+
+	// Passkeys are offered when a WebAuthn relying party is configured
+	var passkeys *webauthn.RelyingParty
+	if cfg.WebAuthnRPID != "" {
+		passkeys, err = webauthn.New(webauthn.Config{
+			RPID:    cfg.WebAuthnRPID,
+			RPName:  cfg.WebAuthnRPName,
+			Origins: cfg.WebAuthnOrigins,
+		})
+		if err != nil {
+			appLogger.Fatal().Err(err).Msg("Invalid WebAuthn configuration")
+		}
+	}
+
+	// New passwords are checked against the policy and, when a dataset is
+	// configured, known breaches
+	passwordPolicy := passwords.DefaultPolicy()
+	passwordPolicy.MinLength = cfg.PasswordMinLength
+	passwordPolicy.MinClasses = cfg.PasswordMinClasses
+	passwordPolicy.HistorySize = cfg.PasswordHistorySize
+	var breaches passwords.BreachChecker
+	if cfg.BreachedPasswordsDir != "" {
+		breaches = passwords.NewPrefixDir(cfg.BreachedPasswordsDir)
+	}
+
+	// New passwords are hashed with the configured algorithm; hashes made
+	// with the other one or with outdated costs are upgraded on login.
+	// Out-of-range costs would make hashing panic or fail on every login.
+	if cfg.BcryptCost < 4 || cfg.BcryptCost > 31 {
+		appLogger.Fatal().Int("cost", cfg.BcryptCost).Msg("BCRYPT_COST must be between 4 and 31")
+	}
+	if cfg.Argon2Parallelism < 1 || cfg.Argon2Parallelism > 255 {
+		appLogger.Fatal().Int("parallelism", cfg.Argon2Parallelism).Msg("ARGON2_PARALLELISM must be between 1 and 255")
+	}
+	if cfg.Argon2Iterations < 1 {
+		appLogger.Fatal().Int("iterations", cfg.Argon2Iterations).Msg("ARGON2_ITERATIONS must be at least 1")
+	}
+	if cfg.Argon2MemoryKiB < 8*cfg.Argon2Parallelism {
+		appLogger.Fatal().Int("memory_kib", cfg.Argon2MemoryKiB).Msg("ARGON2_MEMORY_KIB must be at least 8 times ARGON2_PARALLELISM")
+	}
+	bcryptHasher := passwords.Bcrypt{Cost: cfg.BcryptCost}
+	argon2Hasher := passwords.DefaultArgon2id()
+	argon2Hasher.Memory = uint32(cfg.Argon2MemoryKiB)
+	argon2Hasher.Iterations = uint32(cfg.Argon2Iterations)
+	argon2Hasher.Parallelism = uint8(cfg.Argon2Parallelism)
+	var passwordHasher *passwords.Hasher
+	switch cfg.PasswordHasher {
+	case "bcrypt":
+		passwordHasher = passwords.NewHasher(bcryptHasher, argon2Hasher)
+	case "argon2id":
+		passwordHasher = passwords.NewHasher(argon2Hasher, bcryptHasher)
+		// Unlike bcrypt, argon2id uses the whole password
+		passwordPolicy.MaxLength = 0
+	default:
+		appLogger.Fatal().Str("hasher", cfg.PasswordHasher).Msg("Unknown password hasher")
+	}
+
 	// Initialize service
-	authService := service.NewAuthService(authRepo, jwtManager, kafkaClient)
+	authService := service.NewAuthService(authRepo, jwtManager, service.Options{
+		Mailer:                   authMailer,
+		LoginGuard:               lockout.NewGuard(attemptStore, lockout.DefaultPolicy()),
+		MagicLinkLimiter:         lockout.NewLimiter(attemptStore, "magic-link-email", 3, 15*time.Minute),
+		MagicLinkIPLimiter:       lockout.NewLimiter(attemptStore, "magic-link-ip", 20, 15*time.Minute),
+		PasswordChecker:          passwords.NewChecker(passwordPolicy, breaches),
+		PasswordHasher:           passwordHasher,
+		Revocations:              revocations,
+		IdentityProviders:        identityProviders,
+		Passkeys:                 passkeys,
+		TokenSecret:              cfg.OneTimeTokenSecret,
+		AppBaseURL:               cfg.AppBaseURL,
+		Issuer:                   cfg.OIDCIssuer,
+		RequireEmailVerification: cfg.RequireEmailVerification,
+	})
+	if err := authService.SeedRoles(); err != nil {
+		appLogger.Fatal().Err(err).Msg("Failed to seed roles")
+	}
 
 	// Initialize handler
 	authHandler := handler.NewAuthHandler(authService)
@@ -91,16 +237,82 @@ func main() {
 		return c.JSON(fiber.Map{"status": "ok", "service": "auth-service"})
 	})
 
+	// Public keys for verifying access tokens
+	app.Get("/.well-known/jwks.json", authHandler.JWKS)
+
+	// OAuth2 and OpenID Connect endpoints for registered clients
+	app.Get("/.well-known/openid-configuration", authHandler.Discovery)
+	app.Get("/oauth/authorize", authHandler.Authorize)
+	app.Post("/oauth/token", authHandler.Token)
+	app.Post("/oauth/introspect", authHandler.Introspect)
+	app.Post("/oauth/revoke", authHandler.Revoke)
+	app.Get("/oauth/userinfo", authHandler.UserInfo)
+	app.Post("/oauth/userinfo", authHandler.UserInfo)
+
 	// Public routes
 	api := app.Group("/api/v1")
 	api.Post("/register", authHandler.Register)
 	api.Post("/login", authHandler.Login)
+	api.Post("/login/mfa", authHandler.VerifyMFA)
 	api.Post("/refresh", authHandler.RefreshToken)
 	api.Post("/logout", authHandler.Logout)
+	api.Post("/email/verify", authHandler.VerifyEmail)
+	api.Post("/email/resend", authHandler.ResendVerificationEmail)
+	api.Post("/password/forgot", authHandler.ForgotPassword)
+	api.Post("/password/reset", authHandler.ResetPassword)
+	api.Post("/login/magic", authHandler.RequestMagicLink)
+	api.Post("/login/magic/verify", authHandler.LoginWithMagicLink)
+	api.Post("/api-keys/exchange", authHandler.ExchangeAPIKey)
+	api.Get("/login/providers", authHandler.ListProviders)
+	api.Post("/login/external", authHandler.ExchangeExternalLogin)
+	api.Get("/login/external/:provider", authHandler.BeginExternalLogin)
+	api.Get("/login/external/:provider/callback", authHandler.ExternalLoginCallback)
+	api.Post("/login/passkey/begin", authHandler.BeginPasskeyLogin)
+	api.Post("/login/passkey", authHandler.LoginWithPasskey)
 
 	// Protected routes
-	protected := api.Group("/auth", handler.JWTMiddleware(jwtManager))
+	protected := api.Group("/auth", handler.JWTMiddleware(authService))
 	protected.Get("/profile", authHandler.GetProfile)
+	protected.Post("/password", authHandler.ChangePassword)
+	protected.Post("/mfa/enroll", authHandler.EnrollMFA)
+	protected.Post("/mfa/confirm", authHandler.ConfirmMFA)
+	protected.Post("/mfa/disable", authHandler.DisableMFA)
+	protected.Post("/mfa/recovery-codes", authHandler.RegenerateRecoveryCodes)
+	protected.Get("/sessions", authHandler.ListSessions)
+	protected.Delete("/sessions", authHandler.RevokeAllSessions)
+	protected.Delete("/sessions/:id", authHandler.RevokeSession)
+	protected.Get("/api-keys", authHandler.ListAPIKeys)
+	protected.Post("/api-keys", authHandler.CreateAPIKey)
+	protected.Delete("/api-keys/:id", authHandler.RevokeAPIKey)
+	protected.Get("/identities", authHandler.ListIdentities)
+	protected.Post("/identities/link", authHandler.CreateIdentityLinkToken)
+	protected.Delete("/identities/:id", authHandler.UnlinkIdentity)
+	protected.Get("/passkeys", authHandler.ListPasskeys)
+	protected.Post("/passkeys/register/begin", authHandler.BeginPasskeyRegistration)
+	protected.Post("/passkeys/register", authHandler.FinishPasskeyRegistration)
+	protected.Delete("/passkeys/:id", authHandler.DeletePasskey)
+	protected.Get("/oauth/consent", authHandler.GetConsent)
+	protected.Post("/oauth/authorize", authHandler.AuthorizeConsent)
+
+	// Admin routes
+	admin := api.Group("/admin", handler.JWTMiddleware(authService))
+	manageRoles := handler.RequirePermission(jwtutils.PermManageRoles)
+	admin.Get("/roles", manageRoles, authHandler.ListRoles)
+	admin.Post("/users/:id/roles", manageRoles, authHandler.AssignRole)
+	admin.Delete("/users/:id/roles/:role", manageRoles, authHandler.RevokeRole)
+	manageClients := handler.RequirePermission(jwtutils.PermManageClients)
+	admin.Get("/clients", manageClients, authHandler.ListClients)
+	admin.Post("/clients", manageClients, authHandler.RegisterClient)
+	admin.Delete("/clients/:client_id", manageClients, authHandler.RevokeClient)
+	manageAccounts := handler.RequirePermission(jwtutils.PermManageAccounts)
+	admin.Get("/users", manageAccounts, authHandler.SearchUsers)
+	admin.Get("/users/:id", manageAccounts, authHandler.GetUser)
+	admin.Post("/users/:id/deactivate", manageAccounts, authHandler.DeactivateUser)
+	admin.Post("/users/:id/activate", manageAccounts, authHandler.ActivateUser)
+	admin.Post("/users/:id/revoke-tokens", manageAccounts, authHandler.RevokeUserTokens)
+	admin.Post("/users/:id/reset-credentials", manageAccounts, authHandler.ResetUserCredentials)
+	admin.Post("/users/:id/impersonate", handler.RequirePermission(jwtutils.PermImpersonate), authHandler.ImpersonateUser)
+	admin.Get("/audit", handler.RequirePermission(jwtutils.PermReadAudit), authHandler.ListAuditLog)
 
 	// Start server
 	port := cfg.Port
@@ -0,0 +1,182 @@
+// Command fakeidp is a minimal OpenID provider for trying out external
+// login locally. It signs ID tokens with a throwaway key and lets whoever
+// opens the login page sign in as any email address, so it must never be
+// exposed outside a development machine.
+//
+// Run it next to auth-service with:
+//
+//	go run ./cmd/fakeidp -addr :4000
+//	OIDC_PROVIDERS=fake OIDC_PROVIDER_FAKE_ISSUER=http://localhost:4000 \
+//	OIDC_PROVIDER_FAKE_CLIENT_ID=auth-service OIDC_PROVIDER_FAKE_CLIENT_SECRET=secret \
+//	go run ./cmd/api
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"flag"
+	"html/template"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/my-username/billion-user-app/pkg/jwtutils"
+)
+
+// grant is an issued authorization code
+type grant struct {
+	clientID      string
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+	email         string
+	emailVerified bool
+	expiresAt     time.Time
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!doctype html>
+<title>Fake identity provider</title>
+<h1>Sign in to the fake identity provider</h1>
+<form method="get" action="/authorize">
+  {{range $name, $values := .}}{{range $values}}<input type="hidden" name="{{$name}}" value="{{.}}">{{end}}{{end}}
+  <p><label>Email <input name="email" type="email" value="alice@example.com" required></label></p>
+  <p><label><input name="email_verified" type="checkbox" value="true" checked> Email verified</label></p>
+  <button type="submit">Sign in</button>
+</form>
+`))
+
+func main() {
+	addr := flag.String("addr", ":4000", "listen address")
+	issuer := flag.String("issuer", "http://localhost:4000", "issuer URL, as configured in auth-service")
+	clientID := flag.String("client-id", "auth-service", "the only client allowed to log in")
+	clientSecret := flag.String("client-secret", "secret", "that client's secret")
+	flag.Parse()
+
+	key, err := jwtutils.GenerateSigningKey("fakeidp", jwtutils.AlgRS256)
+	if err != nil {
+		log.Fatalf("failed to generate signing key: %v", err)
+	}
+	keySet, err := jwtutils.NewKeySet([]*jwtutils.SigningKey{key}, "")
+	if err != nil {
+		log.Fatalf("failed to create key set: %v", err)
+	}
+	signer := jwtutils.NewSigningJWTManager(keySet, 5*time.Minute)
+
+	var mu sync.Mutex
+	grants := make(map[string]grant)
+
+	app := fiber.New()
+
+	app.Get("/.well-known/openid-configuration", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{
+			"issuer":                                *issuer,
+			"authorization_endpoint":                *issuer + "/authorize",
+			"token_endpoint":                        *issuer + "/token",
+			"jwks_uri":                              *issuer + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{jwtutils.AlgRS256},
+			"code_challenge_methods_supported":      []string{"S256"},
+		})
+	})
+
+	app.Get("/jwks", func(c *fiber.Ctx) error {
+		return c.JSON(keySet.JWKS())
+	})
+
+	// Without an email the login page is shown; submitting it comes back
+	// here with the same parameters and issues a code
+	app.Get("/authorize", func(c *fiber.Ctx) error {
+		query, err := url.ParseQuery(string(c.Request().URI().QueryString()))
+		if err != nil || query.Get("client_id") != *clientID || query.Get("redirect_uri") == "" {
+			return c.Status(fiber.StatusBadRequest).SendString("unknown client or missing redirect_uri")
+		}
+		if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+			return c.Status(fiber.StatusBadRequest).SendString("PKCE with S256 is required")
+		}
+
+		email := strings.ToLower(strings.TrimSpace(query.Get("email")))
+		if email == "" {
+			c.Type("html")
+			return loginPage.Execute(c, query)
+		}
+
+		random := make([]byte, 16)
+		_, _ = rand.Read(random)
+		code := base64.RawURLEncoding.EncodeToString(random)
+		mu.Lock()
+		grants[code] = grant{
+			clientID:      query.Get("client_id"),
+			redirectURI:   query.Get("redirect_uri"),
+			nonce:         query.Get("nonce"),
+			codeChallenge: query.Get("code_challenge"),
+			email:         email,
+			emailVerified: query.Get("email_verified") == "true",
+			expiresAt:     time.Now().Add(time.Minute),
+		}
+		mu.Unlock()
+
+		redirect, _ := url.Parse(query.Get("redirect_uri"))
+		params := redirect.Query()
+		params.Set("code", code)
+		params.Set("state", query.Get("state"))
+		redirect.RawQuery = params.Encode()
+		return c.Redirect(redirect.String(), fiber.StatusFound)
+	})
+
+	app.Post("/token", func(c *fiber.Ctx) error {
+		id, secret := basicAuth(c)
+		if id != *clientID || secret != *clientSecret {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid_client"})
+		}
+
+		mu.Lock()
+		g, ok := grants[c.FormValue("code")]
+		delete(grants, c.FormValue("code"))
+		mu.Unlock()
+
+		sum := sha256.Sum256([]byte(c.FormValue("code_verifier")))
+		if !ok || time.Now().After(g.expiresAt) || g.redirectURI != c.FormValue("redirect_uri") ||
+			base64.RawURLEncoding.EncodeToString(sum[:]) != g.codeChallenge {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid_grant"})
+		}
+
+		username, _, _ := strings.Cut(g.email, "@")
+		claims := jwtutils.NewIDTokenClaims(*issuer, "fake|"+g.email, g.clientID, g.nonce, time.Now())
+		claims.Email = g.email
+		claims.EmailVerified = &g.emailVerified
+		claims.PreferredUsername = username
+
+		idToken, err := signer.GenerateIDToken(claims)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "server_error"})
+		}
+
+		return c.JSON(fiber.Map{
+			"access_token": "fake-access-token",
+			"token_type":   "Bearer",
+			"expires_in":   300,
+			"id_token":     idToken,
+		})
+	})
+
+	log.Printf("Fake identity provider listening on %s as %s", *addr, *issuer)
+	log.Fatal(app.Listen(*addr))
+}
+
+// basicAuth returns the client credentials from the Authorization header
+func basicAuth(c *fiber.Ctx) (string, string) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Basic "))
+	if err != nil {
+		return "", ""
+	}
+	id, secret, _ := strings.Cut(string(raw), ":")
+	id, _ = url.QueryUnescape(id)
+	secret, _ = url.QueryUnescape(secret)
+	return id, secret
+}
@@ -4,12 +4,14 @@ go 1.21.0
 
 require (
 	github.com/gofiber/fiber/v2 v2.52.0
-	github.com/golang-jwt/jwt/v5 v5.2.0
 	github.com/my-username/billion-user-app/pkg/config v0.0.0
 	github.com/my-username/billion-user-app/pkg/database v0.0.0
 	github.com/my-username/billion-user-app/pkg/jwtutils v0.0.0
 	github.com/my-username/billion-user-app/pkg/kafkaclient v0.0.0
 	github.com/my-username/billion-user-app/pkg/logger v0.0.0
+	github.com/my-username/billion-user-app/pkg/outbox v0.0.0
+	github.com/my-username/billion-user-app/pkg/revocation v0.0.0
+	github.com/redis/go-redis/v9 v9.5.1
 	golang.org/x/crypto v0.18.0
 	gorm.io/gorm v1.25.5
 )
@@ -17,10 +19,13 @@ require (
 require (
 	github.com/IBM/sarama v1.42.1 // indirect
 	github.com/andybalholm/brotli v1.0.5 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 	github.com/eapache/go-resiliency v1.4.0 // indirect
 	github.com/eapache/go-xerial-snappy v0.0.0-20230731223053-c322873962e3 // indirect
 	github.com/eapache/queue v1.1.0 // indirect
+	github.com/golang-jwt/jwt/v5 v5.2.0 // indirect
 	github.com/golang/snappy v0.0.4 // indirect
 	github.com/google/uuid v1.5.0 // indirect
 	github.com/hashicorp/errwrap v1.0.0 // indirect
@@ -60,4 +65,6 @@ replace (
 	github.com/my-username/billion-user-app/pkg/jwtutils => ../../pkg/jwtutils
 	github.com/my-username/billion-user-app/pkg/kafkaclient => ../../pkg/kafkaclient
 	github.com/my-username/billion-user-app/pkg/logger => ../../pkg/logger
+	github.com/my-username/billion-user-app/pkg/outbox => ../../pkg/outbox
+	github.com/my-username/billion-user-app/pkg/revocation => ../../pkg/revocation
 )
@@ -3,6 +3,7 @@ package domain
 import (
 	"time"
 
+	"github.com/my-username/billion-user-app/pkg/jwtutils"
 	"gorm.io/gorm"
 )
 
@@ -13,28 +14,431 @@ type User struct {
 	Username  string         `json:"username" gorm:"uniqueIndex;not null"`
 	Password  string         `json:"-" gorm:"not null"` // Hashed password, never return in JSON
 	IsActive  bool           `json:"is_active" gorm:"default:true"`
+	Roles     []Role         `json:"roles,omitempty" gorm:"many2many:user_roles;"`
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+
+	// Email ownership, proven by following the verification link
+	EmailVerified   bool       `json:"email_verified" gorm:"default:false"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+
+	// TOTP multi-factor authentication. MFASecret is set at enrollment but
+	// only enforced once MFAEnabled is confirmed with a valid code.
+	MFAEnabled  bool   `json:"mfa_enabled" gorm:"default:false"`
+	MFASecret   string `json:"-"`
+	MFALastStep int64  `json:"-"` // Last accepted TOTP step, prevents code replay
 }
 
-// RefreshToken represents a refresh token for JWT rotation
-type RefreshToken struct {
+// RecoveryCode is a single-use fallback for a lost MFA device.
+// Only a hash of the code is stored.
+type RecoveryCode struct {
+	ID        uint64     `json:"id" gorm:"primaryKey"`
+	UserID    uint64     `json:"user_id" gorm:"not null;index"`
+	CodeHash  string     `json:"-" gorm:"not null"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// PasswordHistory keeps the hash of a password the user has replaced, so
+// recent passwords cannot be chosen again
+type PasswordHistory struct {
 	ID        uint64    `json:"id" gorm:"primaryKey"`
 	UserID    uint64    `json:"user_id" gorm:"not null;index"`
-	Token     string    `json:"token" gorm:"uniqueIndex;not null"`
-	ExpiresAt time.Time `json:"expires_at" gorm:"not null;index"`
+	Hash      string    `json:"-" gorm:"not null"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// One-time token purposes
+const (
+	TokenPurposeMFAChallenge      = "mfa_challenge"
+	TokenPurposeEmailVerification = "email_verification"
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeMagicLink         = "magic_link"
+	TokenPurposeExternalLogin     = "external_login" // Hands a federated login over to the frontend
+	TokenPurposeExternalLink      = "external_link"  // Starts linking an external identity
+	TokenPurposePasskeyRegister   = "passkey_register"
+	TokenPurposePasskeyLogin      = "passkey_login"
+)
+
+// OneTimeToken is a short-lived, single-use token bound to a user and a
+// purpose, such as completing an MFA challenge or resetting a password. Only
+// a hash of the token is stored.
+type OneTimeToken struct {
+	ID        uint64     `json:"id" gorm:"primaryKey"`
+	UserID    uint64     `json:"user_id" gorm:"not null;index"`
+	Purpose   string     `json:"purpose" gorm:"not null;index"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex;not null"`
+	Attempts  int        `json:"attempts" gorm:"default:0"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null;index"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// APIKey is a long-lived personal access token for scripts and integrations.
+// It acts on behalf of its owner, restricted to Scopes. Only a hash of the key
+// is stored; Prefix is kept so users can recognise their keys.
+type APIKey struct {
+	ID         uint64     `json:"id" gorm:"primaryKey"`
+	UserID     uint64     `json:"user_id" gorm:"not null;index"`
+	Name       string     `json:"name" gorm:"not null"`
+	Prefix     string     `json:"prefix" gorm:"not null"`
+	KeyHash    string     `json:"-" gorm:"uniqueIndex;not null"`
+	Scopes     []string   `json:"scopes" gorm:"type:text;serializer:json"`
+	ExpiresAt  *time.Time `json:"expires_at"` // Nil for keys that never expire
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// IsActive reports whether the key can still be used at now
+func (k *APIKey) IsActive(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// Passkey is a WebAuthn credential the user can log in with instead of a
+// password. PublicKey is the COSE-encoded key the authenticator signs with;
+// SignCount is the authenticator's counter at its last use, used to detect
+// cloned authenticators.
+type Passkey struct {
+	ID           uint64     `json:"id" gorm:"primaryKey"`
+	UserID       uint64     `json:"user_id" gorm:"not null;index"`
+	Name         string     `json:"name" gorm:"not null"`
+	CredentialID []byte     `json:"-" gorm:"uniqueIndex;not null"`
+	PublicKey    []byte     `json:"-" gorm:"not null"`
+	SignCount    uint32     `json:"-"`
+	Transports   []string   `json:"transports" gorm:"type:text;serializer:json"`
+	BackedUp     bool       `json:"backed_up"` // Synced between the user's devices
+	LastUsedAt   *time.Time `json:"last_used_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// OAuth2 grant types a client can be registered for
+const (
+	GrantClientCredentials = "client_credentials"
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+)
+
+// OAuthClient is a registered OAuth2 client: another service obtaining
+// tokens for itself with the client-credentials grant, or an application
+// signing users in through the authorization-code flow. Only a hash of the
+// secret is stored; public clients (SPAs, mobile apps) have none and must
+// use PKCE.
+type OAuthClient struct {
+	ID           uint64     `json:"id" gorm:"primaryKey"`
+	ClientID     string     `json:"client_id" gorm:"uniqueIndex;not null"`
+	Name         string     `json:"name" gorm:"not null"`
+	SecretHash   string     `json:"-" gorm:"not null"`
+	Public       bool       `json:"public" gorm:"default:false"`
+	Scopes       []string   `json:"scopes" gorm:"type:text;serializer:json"`        // Scopes the client may request
+	Audiences    []string   `json:"audiences" gorm:"type:text;serializer:json"`     // Services it may call
+	GrantTypes   []string   `json:"grant_types" gorm:"type:text;serializer:json"`   // Empty means client_credentials
+	RedirectURIs []string   `json:"redirect_uris" gorm:"type:text;serializer:json"` // Exact-match callback URLs
+	RevokedAt    *time.Time `json:"revoked_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// AllowsGrant reports whether the client may use grant
+func (c *OAuthClient) AllowsGrant(grant string) bool {
+	if len(c.GrantTypes) == 0 {
+		return grant == GrantClientCredentials
+	}
+	for _, g := range c.GrantTypes {
+		if g == grant {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsRedirectURI reports whether uri is one of the client's registered
+// redirect URIs. Matching is exact, as required for OAuth 2.1.
+func (c *OAuthClient) AllowsRedirectURI(uri string) bool {
+	for _, u := range c.RedirectURIs {
+		if u == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthorizationCode is issued to a client once the user approved its
+// authorization request, and exchanged for tokens at the token endpoint.
+// Only a hash of the code is stored. SessionID is set on redemption so that
+// tokens can be revoked if the code is replayed.
+type AuthorizationCode struct {
+	ID                  uint64     `json:"id" gorm:"primaryKey"`
+	CodeHash            string     `json:"-" gorm:"uniqueIndex;not null"`
+	ClientID            string     `json:"client_id" gorm:"not null;index"`
+	UserID              uint64     `json:"user_id" gorm:"not null"`
+	RedirectURI         string     `json:"redirect_uri" gorm:"not null"`
+	Scopes              []string   `json:"scopes" gorm:"type:text;serializer:json"`
+	Nonce               string     `json:"-"`
+	CodeChallenge       string     `json:"-" gorm:"not null"`
+	CodeChallengeMethod string     `json:"-" gorm:"not null"`
+	SessionID           string     `json:"-"`
+	AuthTime            time.Time  `json:"auth_time"`
+	ExpiresAt           time.Time  `json:"expires_at" gorm:"not null"`
+	UsedAt              *time.Time `json:"used_at"`
+	CreatedAt           time.Time  `json:"created_at"`
+}
+
+// OAuthConsent records the scopes a user has allowed a client to access, so
+// they are not asked again for the same scopes
+type OAuthConsent struct {
+	ID        uint64    `json:"id" gorm:"primaryKey"`
+	UserID    uint64    `json:"user_id" gorm:"not null;uniqueIndex:idx_consent_user_client"`
+	ClientID  string    `json:"client_id" gorm:"not null;uniqueIndex:idx_consent_user_client"`
+	Scopes    []string  `json:"scopes" gorm:"type:text;serializer:json"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Covers reports whether the user already allowed every one of scopes
+func (c *OAuthConsent) Covers(scopes []string) bool {
+	granted := make(map[string]bool, len(c.Scopes))
+	for _, s := range c.Scopes {
+		granted[s] = true
+	}
+	for _, s := range scopes {
+		if !granted[s] {
+			return false
+		}
+	}
+	return true
+}
+
+// ExternalIdentity links a user to their account at an external OpenID
+// provider. A provider's subject identifies the account for good; the email
+// is only kept for display.
+type ExternalIdentity struct {
+	ID          uint64    `json:"id" gorm:"primaryKey"`
+	UserID      uint64    `json:"user_id" gorm:"not null;index"`
+	Provider    string    `json:"provider" gorm:"not null;uniqueIndex:idx_identity_provider_subject"`
+	Subject     string    `json:"subject" gorm:"not null;uniqueIndex:idx_identity_provider_subject"`
+	Email       string    `json:"email"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastLoginAt time.Time `json:"last_login_at"`
+}
+
+// Actions recorded in the audit log. Authentication events are recorded
+// whether they succeed or not; administrative actions only once done.
+const (
+	AuditActionRegister         = "auth.register"
+	AuditActionLogin            = "auth.login"
+	AuditActionMagicLinkLogin   = "auth.login.magic_link"
+	AuditActionExternalLogin    = "auth.login.external"
+	AuditActionMFAVerify        = "auth.login.mfa"
+	AuditActionPasskeyLogin     = "auth.login.passkey"
+	AuditActionPasskeyAdded     = "auth.passkey.add"
+	AuditActionPasskeyRemoved   = "auth.passkey.remove"
+	AuditActionRefresh          = "auth.refresh"
+	AuditActionLogout           = "auth.logout"
+	AuditActionPasswordChange   = "auth.password.change"
+	AuditActionPasswordReset    = "auth.password.reset"
+	AuditActionUserDeactivated  = "user.deactivated"
+	AuditActionUserActivated    = "user.activated"
+	AuditActionTokensRevoked    = "user.tokens_revoked"
+	AuditActionCredentialsReset = "user.credentials_reset"
+	AuditActionImpersonated     = "user.impersonated"
+	AuditActionRoleAssigned     = "user.role_assigned"
+	AuditActionRoleRevoked      = "user.role_revoked"
+)
+
+// Outcomes of audited actions
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+)
+
+// AuditEntry records a security-relevant event: an authentication attempt
+// or an action an administrator took on a user's account. ActorID is the
+// user who acted, TargetUserID the account acted on; both are the same for
+// self-service actions and zero when the account is unknown, such as a
+// login with an unregistered email. Entries are only ever appended.
+type AuditEntry struct {
+	ID           uint64    `json:"id" gorm:"primaryKey"`
+	ActorID      uint64    `json:"actor_id" gorm:"not null;index"`
+	Action       string    `json:"action" gorm:"not null;index"`
+	TargetUserID uint64    `json:"target_user_id" gorm:"not null;index"`
+	Email        string    `json:"email,omitempty" gorm:"index"`
+	Outcome      string    `json:"outcome" gorm:"not null;default:success;index"`
+	Reason       string    `json:"reason"`
+	IP           string    `json:"ip"`
+	UserAgent    string    `json:"user_agent"`
+	CreatedAt    time.Time `json:"created_at" gorm:"index"`
+}
+
+// Role groups a set of permissions that can be granted to users
+type Role struct {
+	ID          uint64    `json:"id" gorm:"primaryKey"`
+	Name        string    `json:"name" gorm:"uniqueIndex;not null"`
+	Description string    `json:"description"`
+	Permissions []string  `json:"permissions" gorm:"type:text;serializer:json"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// RefreshToken represents a refresh token for JWT rotation.
+// Every login starts a new token family; each rotation issues a child token
+// in the same family and marks the parent as used. Presenting a used token
+// again means it was replayed, and the whole family is revoked.
+type RefreshToken struct {
+	ID        uint64     `json:"id" gorm:"primaryKey"`
+	UserID    uint64     `json:"user_id" gorm:"not null;index"`
+	Token     string     `json:"token" gorm:"uniqueIndex;not null"`
+	FamilyID  string     `json:"family_id" gorm:"index"`
+	ParentID  *uint64    `json:"parent_id" gorm:"index"`
+	UsedAt    *time.Time `json:"used_at"`    // Set when the token is rotated
+	RevokedAt *time.Time `json:"revoked_at"` // Set when the family is revoked
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null;index"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Session is a single login on a device. Its ID is the FamilyID of the
+// refresh tokens issued for it and the "sid" claim of its access tokens, so
+// revoking a session ends both.
+type Session struct {
+	ID         string     `json:"id" gorm:"primaryKey"`
+	UserID     uint64     `json:"user_id" gorm:"not null;index"`
+	ClientID   string     `json:"client_id,omitempty" gorm:"index"`                  // Set for third-party OAuth2 clients
+	Scopes     []string   `json:"scopes,omitempty" gorm:"type:text;serializer:json"` // Granted to ClientID
+	IP         string     `json:"ip"`
+	UserAgent  string     `json:"user_agent"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt time.Time  `json:"last_used_at"` // Updated on every token refresh
+	ExpiresAt  time.Time  `json:"expires_at" gorm:"not null;index"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+// IsActive reports whether the session can still be used at now
+func (s *Session) IsActive(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// IsUsed reports whether the token has already been rotated
+func (t *RefreshToken) IsUsed() bool {
+	return t.UsedAt != nil
+}
+
+// IsRevoked reports whether the token's family has been revoked
+func (t *RefreshToken) IsRevoked() bool {
+	return t.RevokedAt != nil
+}
+
 // TableName specifies the table name for User
 func (User) TableName() string {
 	return "users"
 }
 
+// TableName specifies the table name for Role
+func (Role) TableName() string {
+	return "roles"
+}
+
+// RoleNames returns the names of the roles granted to the user
+func (u *User) RoleNames() []string {
+	names := make([]string, 0, len(u.Roles))
+	for _, role := range u.Roles {
+		names = append(names, role.Name)
+	}
+	return names
+}
+
+// Permissions returns the de-duplicated union of the permissions granted by
+// the user's roles
+func (u *User) Permissions() []string {
+	seen := make(map[string]bool)
+	var permissions []string
+	for _, role := range u.Roles {
+		for _, p := range role.Permissions {
+			if !seen[p] {
+				seen[p] = true
+				permissions = append(permissions, p)
+			}
+		}
+	}
+	return permissions
+}
+
+// DefaultRoles returns the built-in roles seeded on startup
+func DefaultRoles() []Role {
+	return []Role{
+		{
+			Name:        jwtutils.RoleUser,
+			Description: "Regular account, may only manage its own resources",
+			Permissions: []string{},
+		},
+		{
+			Name:        jwtutils.RoleModerator,
+			Description: "Support staff, may manage other users' profiles, products, tasks and media",
+			Permissions: []string{
+				jwtutils.PermManageUsers,
+				jwtutils.PermManageProducts,
+				jwtutils.PermManageTasks,
+				jwtutils.PermManageMedia,
+			},
+		},
+		{
+			Name:        jwtutils.RoleAdmin,
+			Description: "Full access, including role management and account deletion",
+			Permissions: []string{jwtutils.PermAll},
+		},
+	}
+}
+
+// TableName specifies the table name for RecoveryCode
+func (RecoveryCode) TableName() string {
+	return "mfa_recovery_codes"
+}
+
+// TableName specifies the table name for PasswordHistory
+func (PasswordHistory) TableName() string {
+	return "password_history"
+}
+
+// TableName specifies the table name for OneTimeToken
+func (OneTimeToken) TableName() string {
+	return "one_time_tokens"
+}
+
+// TableName specifies the table name for APIKey
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+// TableName specifies the table name for OAuthClient
+func (OAuthClient) TableName() string {
+	return "oauth_clients"
+}
+
+// TableName specifies the table name for AuthorizationCode
+func (AuthorizationCode) TableName() string {
+	return "oauth_authorization_codes"
+}
+
+// TableName specifies the table name for OAuthConsent
+func (OAuthConsent) TableName() string {
+	return "oauth_consents"
+}
+
+// TableName specifies the table name for ExternalIdentity
+func (ExternalIdentity) TableName() string {
+	return "external_identities"
+}
+
+// TableName specifies the table name for AuditEntry
+func (AuditEntry) TableName() string {
+	return "audit_log"
+}
+
+// TableName specifies the table name for Session
+func (Session) TableName() string {
+	return "sessions"
+}
+
 // TableName specifies the table name for RefreshToken
 func (RefreshToken) TableName() string {
 	return "refresh_tokens"
 }
-
-
@@ -0,0 +1,193 @@
+// Package federation signs users in with external OpenID providers, such as a
+// corporate identity provider or a social login. Each Provider is a generic
+// OpenID Connect relying party: it discovers the provider's endpoints from
+// its issuer URL, sends the user there with the authorization-code flow and
+// PKCE, and verifies the returned ID token against the provider's JWKS.
+package federation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/my-username/billion-user-app/pkg/jwtutils"
+)
+
+var (
+	// ErrDiscovery means the provider's configuration could not be loaded
+	ErrDiscovery = errors.New("failed to discover OpenID provider")
+	// ErrExchange means the provider rejected the authorization code
+	ErrExchange = errors.New("failed to exchange authorization code")
+	// ErrInvalidIDToken means the ID token failed verification
+	ErrInvalidIDToken = errors.New("invalid ID token")
+)
+
+// Config configures a provider. RedirectURL is auth-service's callback for
+// it, which must be registered with the provider.
+type Config struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// Identity is what a provider asserts about the signed-in user
+type Identity struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+}
+
+// metadata is the part of the provider's discovery document we use
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is an external OpenID provider. Its endpoints are discovered on
+// first use, so auth-service starts even if the provider is down.
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	mu       sync.Mutex
+	meta     *metadata
+	verifier *jwtutils.JWTManager
+}
+
+// NewProvider creates a provider from cfg
+func NewProvider(cfg Config) *Provider {
+	return &Provider{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Name returns the provider's name, as used in URLs
+func (p *Provider) Name() string {
+	return p.cfg.Name
+}
+
+// AuthCodeURL returns the URL to send the user to. state, nonce and the PKCE
+// S256 codeChallenge must be checked again when the user comes back.
+func (p *Provider) AuthCodeURL(state, nonce, codeChallenge string) (string, error) {
+	meta, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + query.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the identity from the
+// verified ID token, which must carry nonce
+func (p *Provider) Exchange(code, codeVerifier, nonce string) (*Identity, error) {
+	meta, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequest(http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrExchange, err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrExchange, err)
+	}
+	if resp.StatusCode != http.StatusOK || body.IDToken == "" {
+		return nil, fmt.Errorf("%w: status %d %s", ErrExchange, resp.StatusCode, body.Error)
+	}
+
+	claims, err := p.verifier.ValidateIDToken(body.IDToken, meta.Issuer, p.cfg.ClientID)
+	if err != nil || claims.Subject == "" || claims.Nonce != nonce {
+		return nil, ErrInvalidIDToken
+	}
+
+	return &Identity{
+		Subject:           claims.Subject,
+		Email:             strings.ToLower(claims.Email),
+		EmailVerified:     claims.EmailVerified != nil && *claims.EmailVerified,
+		PreferredUsername: claims.PreferredUsername,
+	}, nil
+}
+
+// discover loads the provider's discovery document once. Failures are not
+// cached, so a provider that was down is retried on the next login.
+func (p *Provider) discover() (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.meta != nil {
+		return p.meta, nil
+	}
+
+	resp, err := p.client.Get(strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: status %d", ErrDiscovery, resp.StatusCode)
+	}
+
+	var meta metadata
+	if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+
+	// The issuer in the document must be the one we were configured with
+	// (OpenID Connect Discovery section 4.3)
+	if meta.Issuer != p.cfg.Issuer || meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: incomplete or mismatched configuration", ErrDiscovery)
+	}
+
+	p.meta = &meta
+	p.verifier = jwtutils.NewVerifyingJWTManager(jwtutils.NewRemoteKeySet(meta.JWKSURI))
+	return p.meta, nil
+}
@@ -0,0 +1,270 @@
+package federation
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/my-username/billion-user-app/pkg/jwtutils"
+)
+
+const (
+	clientID     = "billion-user-app"
+	clientSecret = "s3cret"
+	redirectURL  = "https://app.example.com/api/v1/login/oidc/corp/callback"
+)
+
+// authorization is what the issuer remembers about a code it handed out
+type authorization struct {
+	challenge string
+	nonce     string
+}
+
+// testIssuer is an in-process OpenID provider. Its authorize step is done by
+// authorize rather than a browser; tokenClaims can change the ID token it
+// issues.
+type testIssuer struct {
+	t      *testing.T
+	server *httptest.Server
+	signer *jwtutils.JWTManager
+
+	// discoveryIssuer overrides the issuer in the discovery document
+	discoveryIssuer string
+	// tokenClaims changes the ID token's claims before it is signed
+	tokenClaims func(*jwtutils.IDTokenClaims)
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	key, err := jwtutils.GenerateSigningKey("test-key", jwtutils.AlgEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := jwtutils.NewKeySet([]*jwtutils.SigningKey{key}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := &testIssuer{
+		t:      t,
+		signer: jwtutils.NewSigningJWTManager(keys, 5*time.Minute),
+		codes:  make(map[string]authorization),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", issuer.discovery)
+	mux.HandleFunc("/token", issuer.token)
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(issuer.signer.JWKS())
+	})
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+func (i *testIssuer) url() string {
+	return i.server.URL
+}
+
+func (i *testIssuer) provider() *Provider {
+	return NewProvider(Config{
+		Name:         "corp",
+		Issuer:       i.url(),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"openid", "email", "profile"},
+	})
+}
+
+func (i *testIssuer) discovery(w http.ResponseWriter, r *http.Request) {
+	issuer := i.url()
+	if i.discoveryIssuer != "" {
+		issuer = i.discoveryIssuer
+	}
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 issuer,
+		"authorization_endpoint": i.url() + "/authorize?tenant=corp",
+		"token_endpoint":         i.url() + "/token",
+		"jwks_uri":               i.url() + "/jwks",
+	})
+}
+
+// authorize plays the user signing in at the provider and returns the code
+// it redirects back with
+func (i *testIssuer) authorize(authURL string) string {
+	i.t.Helper()
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		i.t.Fatalf("parse auth URL: %v", err)
+	}
+	query := parsed.Query()
+	if query.Get("client_id") != clientID || query.Get("redirect_uri") != redirectURL ||
+		query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" {
+		i.t.Fatalf("unexpected authorization request %s", authURL)
+	}
+
+	code := "code-" + query.Get("state")
+	i.mu.Lock()
+	i.codes[code] = authorization{challenge: query.Get("code_challenge"), nonce: query.Get("nonce")}
+	i.mu.Unlock()
+	return code
+}
+
+func (i *testIssuer) token(w http.ResponseWriter, r *http.Request) {
+	fail := func(status int, code string) {
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": code})
+	}
+
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != clientID || secret != clientSecret {
+		fail(http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" ||
+		r.PostForm.Get("redirect_uri") != redirectURL {
+		fail(http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	i.mu.Lock()
+	auth, ok := i.codes[r.PostForm.Get("code")]
+	delete(i.codes, r.PostForm.Get("code"))
+	i.mu.Unlock()
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != auth.challenge {
+		fail(http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	claims := jwtutils.NewIDTokenClaims(i.url(), "subject-1", clientID, auth.nonce, time.Now())
+	verified := true
+	claims.Email = "Jane@Example.com"
+	claims.EmailVerified = &verified
+	claims.PreferredUsername = "jane"
+	if i.tokenClaims != nil {
+		i.tokenClaims(claims)
+	}
+	idToken, err := i.signer.GenerateIDToken(claims)
+	if err != nil {
+		fail(http.StatusInternalServerError, "server_error")
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "token_type": "Bearer"})
+}
+
+// pkce returns a code verifier and its S256 challenge
+func pkce(verifier string) (string, string) {
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func TestProviderSignsIn(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := issuer.provider()
+	verifier, challenge := pkce("verifier-0123456789-0123456789-0123456789")
+
+	authURL, err := provider.AuthCodeURL("state-1", "nonce-1", challenge)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	parsed, _ := url.Parse(authURL)
+	if parsed.Path != "/authorize" || parsed.Query().Get("tenant") != "corp" {
+		t.Errorf("auth URL %s does not extend the authorization endpoint", authURL)
+	}
+	if got := parsed.Query().Get("scope"); got != "openid email profile" {
+		t.Errorf("scope = %q", got)
+	}
+
+	identity, err := provider.Exchange(issuer.authorize(authURL), verifier, "nonce-1")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	want := Identity{Subject: "subject-1", Email: "jane@example.com", EmailVerified: true, PreferredUsername: "jane"}
+	if *identity != want {
+		t.Errorf("identity = %+v, want %+v", *identity, want)
+	}
+}
+
+func TestExchangeRejectsBadCodes(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := issuer.provider()
+	verifier, challenge := pkce("verifier-0123456789-0123456789-0123456789")
+
+	authURL, err := provider.AuthCodeURL("state-1", "nonce-1", challenge)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	code := issuer.authorize(authURL)
+
+	if _, err := provider.Exchange(code, "another-verifier", "nonce-1"); !errors.Is(err, ErrExchange) {
+		t.Errorf("wrong verifier: error = %v, want ErrExchange", err)
+	}
+	// The issuer burnt the code on the failed attempt
+	if _, err := provider.Exchange(code, verifier, "nonce-1"); !errors.Is(err, ErrExchange) {
+		t.Errorf("reused code: error = %v, want ErrExchange", err)
+	}
+}
+
+func TestExchangeRejectsIDToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		nonce  string
+		claims func(*jwtutils.IDTokenClaims)
+	}{
+		{name: "nonce mismatch", nonce: "another-nonce"},
+		{name: "wrong issuer", nonce: "nonce-1", claims: func(c *jwtutils.IDTokenClaims) {
+			c.Issuer = "https://evil.example"
+		}},
+		{name: "wrong audience", nonce: "nonce-1", claims: func(c *jwtutils.IDTokenClaims) {
+			c.Audience = []string{"another-client"}
+		}},
+		{name: "no subject", nonce: "nonce-1", claims: func(c *jwtutils.IDTokenClaims) {
+			c.Subject = ""
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newTestIssuer(t)
+			issuer.tokenClaims = tt.claims
+			provider := issuer.provider()
+			verifier, challenge := pkce("verifier-0123456789-0123456789-0123456789")
+
+			authURL, err := provider.AuthCodeURL("state-1", "nonce-1", challenge)
+			if err != nil {
+				t.Fatalf("AuthCodeURL: %v", err)
+			}
+			if _, err := provider.Exchange(issuer.authorize(authURL), verifier, tt.nonce); !errors.Is(err, ErrInvalidIDToken) {
+				t.Errorf("Exchange() error = %v, want ErrInvalidIDToken", err)
+			}
+		})
+	}
+}
+
+func TestDiscoveryRejectsMismatchedIssuer(t *testing.T) {
+	issuer := newTestIssuer(t)
+	issuer.discoveryIssuer = "https://evil.example"
+	provider := issuer.provider()
+
+	if _, err := provider.AuthCodeURL("state", "nonce", "challenge"); !errors.Is(err, ErrDiscovery) {
+		t.Errorf("AuthCodeURL() error = %v, want ErrDiscovery", err)
+	}
+
+	// Failures are not cached
+	issuer.discoveryIssuer = ""
+	if _, err := provider.AuthCodeURL("state", "nonce", "challenge"); err != nil {
+		t.Errorf("AuthCodeURL after the provider recovered: %v", err)
+	}
+}
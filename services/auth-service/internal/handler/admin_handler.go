@@ -0,0 +1,265 @@
+package handler
+
+import (
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/my-username/billion-user-app/pkg/jwtutils"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/domain"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/repository"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/service"
+)
+
+// AdminActionRequest carries the reason recorded in the audit log
+type AdminActionRequest struct {
+	Reason string `json:"reason"`
+}
+
+// ResetCredentialsRequest represents an administrative credential reset
+type ResetCredentialsRequest struct {
+	Reason   string `json:"reason"`
+	ResetMFA bool   `json:"reset_mfa"`
+}
+
+// SearchUsers lists accounts, optionally filtered by a search term on email
+// and username and by ?active=true|false
+func (h *AuthHandler) SearchUsers(c *fiber.Ctx) error {
+	offset, _ := strconv.Atoi(c.Query("offset", "0"))
+	limit, _ := strconv.Atoi(c.Query("limit", "20"))
+	if limit <= 0 {
+		limit = 20
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	search := service.UserSearch{
+		Query:  c.Query("q"),
+		Offset: offset,
+		Limit:  limit,
+	}
+	if raw := c.Query("active"); raw != "" {
+		active, err := strconv.ParseBool(raw)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Query parameter 'active' must be true or false",
+			})
+		}
+		search.Active = &active
+	}
+
+	users, total, err := h.authService.SearchUsers(search)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to search users",
+		})
+	}
+
+	items := make([]fiber.Map, 0, len(users))
+	for _, user := range users {
+		items = append(items, adminUserResponse(user))
+	}
+
+	return c.JSON(fiber.Map{
+		"users":  items,
+		"total":  total,
+		"offset": offset,
+		"limit":  limit,
+	})
+}
+
+// GetUser returns any user's account details
+func (h *AuthHandler) GetUser(c *fiber.Ctx) error {
+	userID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+
+	user, err := h.authService.GetUserByID(userID)
+	if err != nil {
+		return adminError(c, err, "Failed to get user")
+	}
+
+	return c.JSON(adminUserResponse(user))
+}
+
+// DeactivateUser disables an account and logs it out everywhere
+func (h *AuthHandler) DeactivateUser(c *fiber.Ctx) error {
+	return h.setUserActive(c, false)
+}
+
+// ActivateUser re-enables a deactivated account
+func (h *AuthHandler) ActivateUser(c *fiber.Ctx) error {
+	return h.setUserActive(c, true)
+}
+
+func (h *AuthHandler) setUserActive(c *fiber.Ctx, active bool) error {
+	actor, ok := adminActor(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid token",
+		})
+	}
+
+	userID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+
+	// The reason is optional, so an empty body is fine
+	var req AdminActionRequest
+	_ = c.BodyParser(&req)
+
+	user, err := h.authService.SetUserActive(actor, userID, active, req.Reason)
+	if err != nil {
+		return adminError(c, err, "Failed to update user")
+	}
+
+	return c.JSON(adminUserResponse(user))
+}
+
+// RevokeUserTokens ends all of a user's sessions and revokes their tokens
+// and API keys
+func (h *AuthHandler) RevokeUserTokens(c *fiber.Ctx) error {
+	actor, ok := adminActor(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid token",
+		})
+	}
+
+	userID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+
+	var req AdminActionRequest
+	_ = c.BodyParser(&req)
+
+	if err := h.authService.RevokeUserTokens(actor, userID, req.Reason); err != nil {
+		return adminError(c, err, "Failed to revoke tokens")
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "All tokens revoked",
+	})
+}
+
+// ResetUserCredentials replaces a user's password, optionally turns off
+// MFA, and emails them a link to choose a new password
+func (h *AuthHandler) ResetUserCredentials(c *fiber.Ctx) error {
+	actor, ok := adminActor(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid token",
+		})
+	}
+
+	userID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+
+	var req ResetCredentialsRequest
+	_ = c.BodyParser(&req)
+
+	if err := h.authService.ResetUserCredentials(actor, userID, req.ResetMFA, req.Reason); err != nil {
+		return adminError(c, err, "Failed to reset credentials")
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Credentials reset, the user has been emailed a link to choose a new password",
+	})
+}
+
+// ImpersonateUser issues a short-lived access token to see the product as
+// the user sees it. A reason is required for the audit log.
+func (h *AuthHandler) ImpersonateUser(c *fiber.Ctx) error {
+	actor, ok := adminActor(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid token",
+		})
+	}
+
+	userID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+
+	var req AdminActionRequest
+	_ = c.BodyParser(&req)
+
+	impersonation, err := h.authService.ImpersonateUser(actor, userID, req.Reason)
+	if err != nil {
+		return adminError(c, err, "Failed to impersonate user")
+	}
+
+	return c.JSON(fiber.Map{
+		"access_token": impersonation.AccessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int64(impersonation.ExpiresIn.Seconds()),
+		"user":         adminUserResponse(impersonation.User),
+	})
+}
+
+// adminActor identifies the administrator making the request
+func adminActor(c *fiber.Ctx) (service.AdminActor, bool) {
+	claims, ok := c.Locals("claims").(*jwtutils.Claims)
+	if !ok {
+		return service.AdminActor{}, false
+	}
+	return service.AdminActor{UserID: claims.UserID, Username: claims.Username, Client: clientInfo(c)}, true
+}
+
+// adminUserResponse renders an account for administrators
+func adminUserResponse(user *domain.User) fiber.Map {
+	return fiber.Map{
+		"id":             user.ID,
+		"email":          user.Email,
+		"username":       user.Username,
+		"is_active":      user.IsActive,
+		"roles":          user.RoleNames(),
+		"mfa_enabled":    user.MFAEnabled,
+		"email_verified": user.EmailVerified,
+		"created_at":     user.CreatedAt,
+	}
+}
+
+func adminError(c *fiber.Ctx, err error, fallback string) error {
+	switch err {
+	case repository.ErrUserNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	case service.ErrOwnAccount:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "You cannot deactivate your own account",
+		})
+	case service.ErrReasonRequired:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "A reason is required",
+		})
+	case service.ErrImpersonationNotAllowed:
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "This account cannot be impersonated",
+		})
+	case service.ErrUserInactive:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "User account is inactive",
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": fallback,
+	})
+}
@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/my-username/billion-user-app/pkg/jwtutils"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/service"
+)
+
+// Longest lifetime a user can choose for an API key
+const maxAPIKeyLifetimeDays = 365
+
+// CreateAPIKeyRequest represents an API key creation request. A zero
+// ExpiresInDays creates a key that never expires.
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name" validate:"required,max=100"`
+	Scopes        []string `json:"scopes" validate:"required"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+// CreateAPIKey issues a new API key for the current user
+func (h *AuthHandler) CreateAPIKey(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(*jwtutils.Claims)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid token",
+		})
+	}
+
+	var req CreateAPIKeyRequest
+	if err := c.BodyParser(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > maxAPIKeyLifetimeDays {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "expires_in_days must be between 0 and 365",
+		})
+	}
+
+	lifetime := time.Duration(req.ExpiresInDays) * 24 * time.Hour
+	key, secret, err := h.authService.CreateAPIKey(claims.UserID, strings.TrimSpace(req.Name), req.Scopes, lifetime)
+	if err != nil {
+		if err == service.ErrInvalidScope {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":  "At least one valid scope is required",
+				"scopes": jwtutils.Scopes(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create API key",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"id":         key.ID,
+		"name":       key.Name,
+		"prefix":     key.Prefix,
+		"scopes":     key.Scopes,
+		"expires_at": key.ExpiresAt,
+		"created_at": key.CreatedAt,
+		"key":        secret,
+		"message":    "Store this key now, it will not be shown again",
+	})
+}
+
+// ListAPIKeys returns the current user's API keys, without their secrets
+func (h *AuthHandler) ListAPIKeys(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(*jwtutils.Claims)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid token",
+		})
+	}
+
+	keys, err := h.authService.ListAPIKeys(claims.UserID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list API keys",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"api_keys": keys,
+	})
+}
+
+// RevokeAPIKey disables one of the current user's API keys
+func (h *AuthHandler) RevokeAPIKey(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(*jwtutils.Claims)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid token",
+		})
+	}
+
+	keyID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid API key ID",
+		})
+	}
+
+	if err := h.authService.RevokeAPIKey(claims.UserID, keyID); err != nil {
+		if err == service.ErrAPIKeyNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "API key not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to revoke API key",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "API key revoked",
+	})
+}
+
+// ExchangeAPIKey trades the API key in the Authorization header for a
+// short-lived access token. Services call it to authenticate API key
+// requests.
+func (h *AuthHandler) ExchangeAPIKey(c *fiber.Ctx) error {
+	authHeader := c.Get("Authorization")
+	if len(authHeader) <= 7 || authHeader[:7] != "Bearer " {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid authorization header",
+		})
+	}
+
+	accessToken, expiresIn, err := h.authService.ExchangeAPIKey(authHeader[7:])
+	if err != nil {
+		if err == service.ErrInvalidAPIKey {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid, expired or revoked API key",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to exchange API key",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int64(expiresIn.Seconds()),
+	})
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/domain"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/service"
+)
+
+// ListAuditLog returns audit log entries, newest first. Entries can be
+// filtered by ?user_id= (actor or target), ?action=, ?outcome=, ?ip= and a
+// time range given as RFC 3339 ?since= and ?until=.
+func (h *AuthHandler) ListAuditLog(c *fiber.Ctx) error {
+	offset, _ := strconv.Atoi(c.Query("offset", "0"))
+	limit, _ := strconv.Atoi(c.Query("limit", "20"))
+	if limit <= 0 {
+		limit = 20
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	query := service.AuditQuery{
+		Action:  c.Query("action"),
+		Outcome: c.Query("outcome"),
+		IP:      c.Query("ip"),
+		Offset:  offset,
+		Limit:   limit,
+	}
+
+	if raw := c.Query("user_id"); raw != "" {
+		userID, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid user ID",
+			})
+		}
+		query.UserID = userID
+	}
+
+	if query.Outcome != "" && query.Outcome != domain.AuditOutcomeSuccess && query.Outcome != domain.AuditOutcomeFailure {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Query parameter 'outcome' must be success or failure",
+		})
+	}
+
+	for param, dest := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+		raw := c.Query(param)
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Query parameter '" + param + "' must be an RFC 3339 timestamp",
+			})
+		}
+		*dest = t
+	}
+
+	entries, total, err := h.authService.ListAuditLog(query)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list audit log",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"entries": entries,
+		"total":   total,
+		"offset":  offset,
+		"limit":   limit,
+	})
+}
@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/lockout"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/passwords"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/service"
+)
+
+// EmailRequest carries an email address
+type EmailRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// TokenRequest carries a one-time token from an email link
+type TokenRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// ResetPasswordRequest represents a password reset request
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=8"`
+}
+
+// VerifyEmail confirms an email address from a verification link
+func (h *AuthHandler) VerifyEmail(c *fiber.Ctx) error {
+	var req TokenRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if err := h.authService.VerifyEmail(req.Token); err != nil {
+		if err == service.ErrInvalidToken {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid or expired token",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to verify email",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Email verified successfully",
+	})
+}
+
+// ResendVerificationEmail sends a new verification link
+func (h *AuthHandler) ResendVerificationEmail(c *fiber.Ctx) error {
+	var req EmailRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if err := h.authService.SendVerificationEmail(req.Email); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to send verification email",
+		})
+	}
+
+	// Same response whether or not the address is registered
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message": "If the address needs verification, an email is on its way",
+	})
+}
+
+// ForgotPassword sends a password reset link
+func (h *AuthHandler) ForgotPassword(c *fiber.Ctx) error {
+	var req EmailRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if err := h.authService.RequestPasswordReset(req.Email); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to request password reset",
+		})
+	}
+
+	// Same response whether or not the address is registered
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message": "If an account exists for this address, a reset link is on its way",
+	})
+}
+
+// ResetPassword sets a new password from a reset link
+func (h *AuthHandler) ResetPassword(c *fiber.Ctx) error {
+	var req ResetPasswordRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if err := h.authService.ResetPassword(req.Token, req.Password, clientInfo(c)); err != nil {
+		if policyErr, ok := err.(*passwords.PolicyError); ok {
+			return passwordPolicyResponse(c, policyErr)
+		}
+		if err == service.ErrInvalidToken {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid or expired token",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to reset password",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Password reset successfully",
+	})
+}
+
+// RequestMagicLink emails a passwordless login link
+func (h *AuthHandler) RequestMagicLink(c *fiber.Ctx) error {
+	var req EmailRequest
+	if err := c.BodyParser(&req); err != nil || req.Email == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if err := h.authService.RequestMagicLink(req.Email, clientInfo(c)); err != nil {
+		if blocked, ok := err.(*lockout.BlockedError); ok {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(blocked.RetryAfter.Seconds())+1))
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error":       "Too many login links requested, please wait before retrying",
+				"retry_after": int(blocked.RetryAfter.Seconds()) + 1,
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to send login link",
+		})
+	}
+
+	// Same response whether or not the address is registered
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message": "If an account exists for this address, a login link is on its way",
+	})
+}
+
+// LoginWithMagicLink logs in with the token from a magic link
+func (h *AuthHandler) LoginWithMagicLink(c *fiber.Ctx) error {
+	var req TokenRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	result, err := h.authService.LoginWithMagicLink(req.Token, clientInfo(c))
+	if err != nil {
+		switch err {
+		case service.ErrInvalidToken:
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid, expired or already used login link",
+			})
+		case service.ErrUserInactive:
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "User account is inactive",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to login",
+		})
+	}
+
+	return c.JSON(loginResponse(result))
+}
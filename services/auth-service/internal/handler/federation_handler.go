@@ -0,0 +1,190 @@
+package handler
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/my-username/billion-user-app/pkg/jwtutils"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/service"
+)
+
+// externalLoginCookie carries the signed state of an external login from the
+// redirect to the provider to its callback
+const externalLoginCookie = "external_login"
+
+// ExternalLoginRequest represents the exchange of a completed external login
+type ExternalLoginRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// ListProviders returns the external identity providers users can sign in with
+func (h *AuthHandler) ListProviders(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"providers": h.authService.ExternalProviders(),
+	})
+}
+
+// BeginExternalLogin redirects the browser to an external provider. With a
+// link_token query parameter the identity is linked to the token's user.
+func (h *AuthHandler) BeginExternalLogin(c *fiber.Ctx) error {
+	provider := c.Params("provider")
+
+	authURL, state, err := h.authService.BeginExternalLogin(provider, c.Query("link_token"))
+	if err != nil {
+		switch err {
+		case service.ErrUnknownProvider:
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Unknown identity provider",
+			})
+		case service.ErrInvalidToken:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid or expired link token",
+			})
+		}
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": "Identity provider is unavailable",
+		})
+	}
+
+	c.Cookie(&fiber.Cookie{
+		Name:     externalLoginCookie,
+		Value:    state,
+		Path:     "/api/v1/login/external/" + provider,
+		Expires:  time.Now().Add(10 * time.Minute),
+		Secure:   c.Protocol() == "https",
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+	return c.Redirect(authURL, fiber.StatusFound)
+}
+
+// ExternalLoginCallback receives the user back from the provider and sends
+// them on to the frontend
+func (h *AuthHandler) ExternalLoginCallback(c *fiber.Ctx) error {
+	provider := c.Params("provider")
+	state := c.Cookies(externalLoginCookie)
+
+	// The state is single-use
+	c.Cookie(&fiber.Cookie{
+		Name:     externalLoginCookie,
+		Path:     "/api/v1/login/external/" + provider,
+		Expires:  time.Unix(0, 0),
+		Secure:   c.Protocol() == "https",
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+
+	redirectTo := h.authService.CompleteExternalLogin(provider, state, service.ExternalCallback{
+		State: c.Query("state"),
+		Code:  c.Query("code"),
+		Error: c.Query("error"),
+	})
+	return c.Redirect(redirectTo, fiber.StatusFound)
+}
+
+// ExchangeExternalLogin trades the token the frontend received after an
+// external login for access and refresh tokens (or an MFA challenge)
+func (h *AuthHandler) ExchangeExternalLogin(c *fiber.Ctx) error {
+	var req ExternalLoginRequest
+	if err := c.BodyParser(&req); err != nil || req.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	result, err := h.authService.ExchangeExternalLogin(req.Token, clientInfo(c))
+	if err != nil {
+		switch err {
+		case service.ErrInvalidToken:
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid or expired login token",
+			})
+		case service.ErrUserInactive:
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "User account is inactive",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to login",
+		})
+	}
+
+	return c.JSON(loginResponse(result))
+}
+
+// ListIdentities returns the external identities linked to the current user
+func (h *AuthHandler) ListIdentities(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(*jwtutils.Claims)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid token",
+		})
+	}
+
+	identities, err := h.authService.ListIdentities(claims.UserID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list identities",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"identities": identities,
+	})
+}
+
+// CreateIdentityLinkToken returns a token to start linking an external
+// identity: the frontend navigates to
+// /api/v1/login/external/:provider?link_token=<token>
+func (h *AuthHandler) CreateIdentityLinkToken(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(*jwtutils.Claims)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid token",
+		})
+	}
+
+	token, err := h.authService.CreateIdentityLinkToken(claims.UserID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create link token",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"link_token": token,
+	})
+}
+
+// UnlinkIdentity removes one of the current user's external identities
+func (h *AuthHandler) UnlinkIdentity(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(*jwtutils.Claims)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid token",
+		})
+	}
+
+	identityID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid identity ID",
+		})
+	}
+
+	if err := h.authService.UnlinkIdentity(claims.UserID, identityID); err != nil {
+		if err == service.ErrIdentityNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Identity not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to unlink identity",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Identity unlinked",
+	})
+}
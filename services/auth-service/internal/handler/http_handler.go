@@ -1,8 +1,14 @@
 package handler
 
 import (
+	"errors"
+	"strconv"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/my-username/billion-user-app/pkg/jwtutils"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/lockout"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/passwords"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/repository"
 	"github.com/my-username/billion-user-app/services/auth-service/internal/service"
 )
 
@@ -32,6 +38,12 @@ type RefreshTokenRequest struct {
 	RefreshToken string `json:"refresh_token" validate:"required"`
 }
 
+// AssignRoleRequest represents a role assignment request
+type AssignRoleRequest struct {
+	Role   string `json:"role" validate:"required"`
+	Reason string `json:"reason"`
+}
+
 // Register handles user registration
 func (h *AuthHandler) Register(c *fiber.Ctx) error {
 	var req RegisterRequest
@@ -41,8 +53,11 @@ func (h *AuthHandler) Register(c *fiber.Ctx) error {
 		})
 	}
 
-	user, err := h.authService.Register(req.Email, req.Username, req.Password)
+	user, err := h.authService.Register(req.Email, req.Username, req.Password, clientInfo(c))
 	if err != nil {
+		if policyErr, ok := err.(*passwords.PolicyError); ok {
+			return passwordPolicyResponse(c, policyErr)
+		}
 		if err == service.ErrInvalidCredentials || err.Error() == "user already exists" {
 			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
 				"error": "User already exists",
@@ -72,8 +87,11 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 		})
 	}
 
-	accessToken, refreshToken, err := h.authService.Login(req.Email, req.Password)
+	result, err := h.authService.Login(req.Email, req.Password, clientInfo(c))
 	if err != nil {
+		if blocked, ok := err.(*lockout.BlockedError); ok {
+			return blockedResponse(c, blocked)
+		}
 		if err == service.ErrInvalidCredentials {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"error": "Invalid credentials",
@@ -84,18 +102,61 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 				"error": "User account is inactive",
 			})
 		}
+		if err == service.ErrEmailNotVerified {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Email address is not verified",
+			})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to login",
 		})
 	}
 
-	return c.JSON(fiber.Map{
-		"access_token":  accessToken,
-		"refresh_token": refreshToken,
-		"token_type":    "Bearer",
+	return c.JSON(loginResponse(result))
+}
+
+// clientInfo extracts client metadata from the request
+func clientInfo(c *fiber.Ctx) service.ClientInfo {
+	return service.ClientInfo{
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	}
+}
+
+// blockedResponse answers a login attempt rejected by brute-force
+// protection. Locked accounts get 423 so clients can tell them apart from
+// plain rate limiting (429).
+func blockedResponse(c *fiber.Ctx, blocked *lockout.BlockedError) error {
+	retryAfter := int(blocked.RetryAfter.Seconds()) + 1
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+
+	if errors.Is(blocked, lockout.ErrAccountLocked) {
+		return c.Status(fiber.StatusLocked).JSON(fiber.Map{
+			"error":       "Account temporarily locked due to too many failed login attempts",
+			"retry_after": retryAfter,
+		})
+	}
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"error":       "Too many failed login attempts, please wait before retrying",
+		"retry_after": retryAfter,
 	})
 }
 
+// loginResponse renders either the token pair or the MFA challenge
+func loginResponse(result *service.LoginResult) fiber.Map {
+	if result.MFARequired {
+		return fiber.Map{
+			"mfa_required": true,
+			"mfa_token":    result.MFAToken,
+		}
+	}
+	return fiber.Map{
+		"access_token":  result.AccessToken,
+		"refresh_token": result.RefreshToken,
+		"token_type":    "Bearer",
+	}
+}
+
 // RefreshToken handles token refresh
 func (h *AuthHandler) RefreshToken(c *fiber.Ctx) error {
 	var req RefreshTokenRequest
@@ -105,8 +166,13 @@ func (h *AuthHandler) RefreshToken(c *fiber.Ctx) error {
 		})
 	}
 
-	accessToken, refreshToken, err := h.authService.RefreshToken(req.RefreshToken)
+	accessToken, refreshToken, err := h.authService.RefreshToken(req.RefreshToken, clientInfo(c))
 	if err != nil {
+		if err == service.ErrTokenReused {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Refresh token reuse detected, please log in again",
+			})
+		}
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"error": "Invalid refresh token",
 		})
@@ -128,7 +194,7 @@ func (h *AuthHandler) Logout(c *fiber.Ctx) error {
 		})
 	}
 
-	if err := h.authService.Logout(req.RefreshToken); err != nil {
+	if err := h.authService.Logout(req.RefreshToken, clientInfo(c)); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to logout",
 		})
@@ -156,15 +222,139 @@ func (h *AuthHandler) GetProfile(c *fiber.Ctx) error {
 	}
 
 	return c.JSON(fiber.Map{
-		"id":        user.ID,
-		"email":     user.Email,
-		"username":  user.Username,
-		"is_active": user.IsActive,
+		"id":             user.ID,
+		"email":          user.Email,
+		"username":       user.Username,
+		"is_active":      user.IsActive,
+		"roles":          user.RoleNames(),
+		"mfa_enabled":    user.MFAEnabled,
+		"email_verified": user.EmailVerified,
+	})
+}
+
+// JWKS publishes the public keys used to sign access tokens
+func (h *AuthHandler) JWKS(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(h.authService.JWKS())
+}
+
+// ListRoles returns all roles and their permissions
+func (h *AuthHandler) ListRoles(c *fiber.Ctx) error {
+	roles, err := h.authService.ListRoles()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list roles",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"roles": roles,
 	})
 }
 
+// AssignRole grants a role to a user
+func (h *AuthHandler) AssignRole(c *fiber.Ctx) error {
+	actor, ok := adminActor(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid token",
+		})
+	}
+
+	userID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+
+	var req AssignRoleRequest
+	if err := c.BodyParser(&req); err != nil || req.Role == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	user, err := h.authService.AssignRole(actor, userID, req.Role, req.Reason)
+	if err != nil {
+		return roleError(c, err, "Failed to assign role")
+	}
+
+	return c.JSON(fiber.Map{
+		"id":    user.ID,
+		"roles": user.RoleNames(),
+	})
+}
+
+// RevokeRole removes a role from a user
+func (h *AuthHandler) RevokeRole(c *fiber.Ctx) error {
+	actor, ok := adminActor(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid token",
+		})
+	}
+
+	userID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+
+	var req AdminActionRequest
+	_ = c.BodyParser(&req)
+
+	user, err := h.authService.RevokeRole(actor, userID, c.Params("role"), req.Reason)
+	if err != nil {
+		return roleError(c, err, "Failed to revoke role")
+	}
+
+	return c.JSON(fiber.Map{
+		"id":    user.ID,
+		"roles": user.RoleNames(),
+	})
+}
+
+func roleError(c *fiber.Ctx, err error, fallback string) error {
+	switch err {
+	case repository.ErrUserNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	case repository.ErrRoleNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Role not found",
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": fallback,
+	})
+}
+
+// RequirePermission rejects requests whose token lacks the given permission.
+// It must run after JWTMiddleware.
+func RequirePermission(permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, ok := c.Locals("claims").(*jwtutils.Claims)
+		if !ok || !claims.HasPermission(permission) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Insufficient permissions",
+			})
+		}
+		return c.Next()
+	}
+}
+
+// TokenValidator validates access tokens. It is implemented by
+// *jwtutils.JWTManager and by service.AuthService, which additionally checks
+// that the token's session is still active.
+type TokenValidator interface {
+	ValidateToken(token string) (*jwtutils.Claims, error)
+}
+
 // JWTMiddleware validates JWT tokens
-func JWTMiddleware(jwtManager *jwtutils.JWTManager) fiber.Handler {
+func JWTMiddleware(validator TokenValidator) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		authHeader := c.Get("Authorization")
 		if authHeader == "" {
@@ -182,13 +372,21 @@ func JWTMiddleware(jwtManager *jwtutils.JWTManager) fiber.Handler {
 
 		token := authHeader[7:]
 
-		claims, err := jwtManager.ValidateToken(token)
+		claims, err := validator.ValidateToken(token)
 		if err != nil {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"error": "Invalid or expired token",
 			})
 		}
 
+		// Every change made here touches the account's credentials or other
+		// accounts, so impersonators may only look
+		if !claims.AllowsMethod(c.Method()) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not allowed while impersonating a user",
+			})
+		}
+
 		c.Locals("claims", claims)
 		c.Locals("user_id", claims.UserID)
 
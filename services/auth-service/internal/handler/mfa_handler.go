@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/my-username/billion-user-app/pkg/jwtutils"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/lockout"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/service"
+)
+
+// MFALoginRequest completes a login that returned an MFA challenge
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	Code     string `json:"code" validate:"required"`
+}
+
+// MFACodeRequest carries a TOTP or recovery code
+type MFACodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// VerifyMFA exchanges an MFA challenge token and code for a token pair
+func (h *AuthHandler) VerifyMFA(c *fiber.Ctx) error {
+	var req MFALoginRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	result, err := h.authService.VerifyMFA(req.MFAToken, req.Code, clientInfo(c))
+	if err != nil {
+		if blocked, ok := err.(*lockout.BlockedError); ok {
+			return blockedResponse(c, blocked)
+		}
+		return mfaError(c, err, "Failed to verify MFA code")
+	}
+
+	return c.JSON(loginResponse(result))
+}
+
+// EnrollMFA starts MFA enrollment and returns the secret and otpauth URI
+func (h *AuthHandler) EnrollMFA(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(*jwtutils.Claims)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid token",
+		})
+	}
+
+	enrollment, err := h.authService.EnrollMFA(claims.UserID)
+	if err != nil {
+		return mfaError(c, err, "Failed to start MFA enrollment")
+	}
+
+	return c.JSON(fiber.Map{
+		"secret":      enrollment.Secret,
+		"otpauth_uri": enrollment.URI,
+	})
+}
+
+// ConfirmMFA enables MFA and returns the recovery codes
+func (h *AuthHandler) ConfirmMFA(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(*jwtutils.Claims)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid token",
+		})
+	}
+
+	var req MFACodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	codes, err := h.authService.ConfirmMFA(claims.UserID, req.Code)
+	if err != nil {
+		return mfaError(c, err, "Failed to confirm MFA")
+	}
+
+	return c.JSON(fiber.Map{
+		"message":        "MFA enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableMFA turns MFA off
+func (h *AuthHandler) DisableMFA(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(*jwtutils.Claims)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid token",
+		})
+	}
+
+	var req MFACodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if err := h.authService.DisableMFA(claims.UserID, req.Code); err != nil {
+		return mfaError(c, err, "Failed to disable MFA")
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "MFA disabled",
+	})
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes
+func (h *AuthHandler) RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(*jwtutils.Claims)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid token",
+		})
+	}
+
+	var req MFACodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	codes, err := h.authService.RegenerateRecoveryCodes(claims.UserID, req.Code)
+	if err != nil {
+		return mfaError(c, err, "Failed to regenerate recovery codes")
+	}
+
+	return c.JSON(fiber.Map{
+		"recovery_codes": codes,
+	})
+}
+
+func mfaError(c *fiber.Ctx, err error, fallback string) error {
+	switch err {
+	case service.ErrInvalidMFACode:
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid MFA code",
+		})
+	case service.ErrInvalidMFAChallenge:
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired MFA challenge",
+		})
+	case service.ErrUserInactive:
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "User account is inactive",
+		})
+	case service.ErrMFAAlreadyEnabled:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "MFA is already enabled",
+		})
+	case service.ErrMFANotEnrolled, service.ErrMFANotEnabled:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": fallback,
+	})
+}
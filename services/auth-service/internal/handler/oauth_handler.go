@@ -0,0 +1,362 @@
+package handler
+
+import (
+	"encoding/base64"
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/service"
+)
+
+// RegisterClientRequest represents an OAuth2 client registration request.
+// Grant types default to client_credentials; public clients have no secret
+// and may only use the authorization-code flow.
+type RegisterClientRequest struct {
+	Name         string   `json:"name" validate:"required"`
+	Public       bool     `json:"public"`
+	Scopes       []string `json:"scopes" validate:"required"`
+	Audiences    []string `json:"audiences"`
+	GrantTypes   []string `json:"grant_types"`
+	RedirectURIs []string `json:"redirect_uris"`
+}
+
+// Token is the OAuth2 token endpoint (RFC 6749 section 3.2). Clients
+// authenticate with HTTP Basic or with client_id and client_secret form
+// fields; public clients send only client_id.
+func (h *AuthHandler) Token(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set(fiber.HeaderPragma, "no-cache")
+
+	switch c.FormValue("grant_type") {
+	case "client_credentials":
+		return h.clientCredentialsGrant(c)
+	case "authorization_code":
+		return h.authorizationCodeGrant(c)
+	case "refresh_token":
+		return h.refreshTokenGrant(c)
+	case "":
+		return oauthError(c, fiber.StatusBadRequest, "invalid_request", "grant_type is required")
+	}
+	return oauthError(c, fiber.StatusBadRequest, "unsupported_grant_type", "")
+}
+
+func (h *AuthHandler) clientCredentialsGrant(c *fiber.Ctx) error {
+	clientID, secret, ok := clientAuth(c)
+	if !ok {
+		c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="auth-service"`)
+		return oauthError(c, fiber.StatusUnauthorized, "invalid_client", "Client authentication required")
+	}
+
+	var audience []string
+	for _, aud := range c.Request().PostArgs().PeekMulti("audience") {
+		audience = append(audience, string(aud))
+	}
+
+	token, err := h.authService.IssueClientToken(clientID, secret, strings.Fields(c.FormValue("scope")), audience)
+	if err != nil {
+		switch err {
+		case service.ErrInvalidClient:
+			return oauthError(c, fiber.StatusUnauthorized, "invalid_client", "")
+		case service.ErrUnauthorizedClient:
+			return oauthError(c, fiber.StatusBadRequest, "unauthorized_client", "")
+		case service.ErrInvalidScope:
+			return oauthError(c, fiber.StatusBadRequest, "invalid_scope", "")
+		case service.ErrInvalidAudience:
+			return oauthError(c, fiber.StatusBadRequest, "invalid_target", "")
+		}
+		return oauthError(c, fiber.StatusInternalServerError, "server_error", "")
+	}
+
+	return c.JSON(fiber.Map{
+		"access_token": token.AccessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int64(token.ExpiresIn.Seconds()),
+		"scope":        strings.Join(token.Scopes, " "),
+	})
+}
+
+func (h *AuthHandler) authorizationCodeGrant(c *fiber.Ctx) error {
+	clientID, secret, ok := clientAuth(c)
+	if !ok {
+		c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="auth-service"`)
+		return oauthError(c, fiber.StatusUnauthorized, "invalid_client", "Client authentication required")
+	}
+
+	code, verifier := c.FormValue("code"), c.FormValue("code_verifier")
+	if code == "" || verifier == "" {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_request", "code and code_verifier are required")
+	}
+
+	tokens, err := h.authService.ExchangeAuthorizationCode(clientID, secret, code, c.FormValue("redirect_uri"), verifier, clientInfo(c))
+	if err != nil {
+		return tokenGrantError(c, err)
+	}
+	return tokenResponse(c, tokens)
+}
+
+func (h *AuthHandler) refreshTokenGrant(c *fiber.Ctx) error {
+	clientID, secret, ok := clientAuth(c)
+	if !ok {
+		c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="auth-service"`)
+		return oauthError(c, fiber.StatusUnauthorized, "invalid_client", "Client authentication required")
+	}
+
+	refreshToken := c.FormValue("refresh_token")
+	if refreshToken == "" {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_request", "refresh_token is required")
+	}
+
+	tokens, err := h.authService.RefreshClientTokens(clientID, secret, refreshToken, clientInfo(c))
+	if err != nil {
+		return tokenGrantError(c, err)
+	}
+	return tokenResponse(c, tokens)
+}
+
+// tokenGrantError maps errors of the authorization-code and refresh-token
+// grants to RFC 6749 section 5.2 responses
+func tokenGrantError(c *fiber.Ctx, err error) error {
+	switch err {
+	case service.ErrInvalidClient:
+		return oauthError(c, fiber.StatusUnauthorized, "invalid_client", "")
+	case service.ErrUnauthorizedClient:
+		return oauthError(c, fiber.StatusBadRequest, "unauthorized_client", "")
+	case service.ErrInvalidGrant:
+		return oauthError(c, fiber.StatusBadRequest, "invalid_grant", "")
+	}
+	return oauthError(c, fiber.StatusInternalServerError, "server_error", "")
+}
+
+func tokenResponse(c *fiber.Ctx, tokens *service.OAuthTokens) error {
+	body := fiber.Map{
+		"access_token": tokens.AccessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int64(tokens.ExpiresIn.Seconds()),
+		"scope":        strings.Join(tokens.Scopes, " "),
+	}
+	if tokens.RefreshToken != "" {
+		body["refresh_token"] = tokens.RefreshToken
+	}
+	if tokens.IDToken != "" {
+		body["id_token"] = tokens.IDToken
+	}
+	return c.JSON(body)
+}
+
+// Introspect is the token introspection endpoint (RFC 7662). Confidential
+// clients, such as resource servers, post a token and learn whether it is
+// active and what it grants.
+func (h *AuthHandler) Introspect(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "no-store")
+
+	clientID, secret, ok := clientAuth(c)
+	if !ok {
+		c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="auth-service"`)
+		return oauthError(c, fiber.StatusUnauthorized, "invalid_client", "Client authentication required")
+	}
+
+	token := c.FormValue("token")
+	if token == "" {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_request", "token is required")
+	}
+
+	result, err := h.authService.IntrospectToken(clientID, secret, token, c.FormValue("token_type_hint"))
+	if err != nil {
+		if err == service.ErrInvalidClient {
+			c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="auth-service"`)
+			return oauthError(c, fiber.StatusUnauthorized, "invalid_client", "")
+		}
+		return oauthError(c, fiber.StatusInternalServerError, "server_error", "")
+	}
+
+	if !result.Active {
+		return c.JSON(fiber.Map{"active": false})
+	}
+
+	body := fiber.Map{
+		"active":     true,
+		"token_type": result.TokenType,
+		"sub":        result.Subject,
+		"exp":        result.ExpiresAt.Unix(),
+		"iat":        result.IssuedAt.Unix(),
+	}
+	if len(result.Scopes) > 0 {
+		body["scope"] = strings.Join(result.Scopes, " ")
+	}
+	if result.ClientID != "" {
+		body["client_id"] = result.ClientID
+	}
+	if result.Username != "" {
+		body["username"] = result.Username
+	}
+	if len(result.Audience) > 0 {
+		body["aud"] = result.Audience
+	}
+	if result.Issuer != "" {
+		body["iss"] = result.Issuer
+	}
+	if result.TokenID != "" {
+		body["jti"] = result.TokenID
+	}
+	if result.Actor != nil {
+		body["act"] = result.Actor
+	}
+	return c.JSON(body)
+}
+
+// Revoke is the token revocation endpoint (RFC 7009). Clients revoke their
+// own access or refresh tokens, e.g. on logout. Unknown tokens get the same
+// empty 200 response as revoked ones.
+func (h *AuthHandler) Revoke(c *fiber.Ctx) error {
+	clientID, secret, ok := clientAuth(c)
+	if !ok {
+		c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="auth-service"`)
+		return oauthError(c, fiber.StatusUnauthorized, "invalid_client", "Client authentication required")
+	}
+
+	token := c.FormValue("token")
+	if token == "" {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_request", "token is required")
+	}
+
+	if err := h.authService.RevokeOAuthToken(clientID, secret, token, c.FormValue("token_type_hint")); err != nil {
+		switch err {
+		case service.ErrInvalidClient:
+			c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="auth-service"`)
+			return oauthError(c, fiber.StatusUnauthorized, "invalid_client", "")
+		case service.ErrUnsupportedTokenType:
+			return oauthError(c, fiber.StatusBadRequest, "unsupported_token_type", "")
+		}
+		return oauthError(c, fiber.StatusInternalServerError, "server_error", "")
+	}
+
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// clientAuth extracts client credentials from the Authorization header or,
+// failing that, the request body. The secret is empty for public clients.
+func clientAuth(c *fiber.Ctx) (string, string, bool) {
+	authHeader := c.Get(fiber.HeaderAuthorization)
+	if strings.HasPrefix(authHeader, "Basic ") {
+		raw, err := base64.StdEncoding.DecodeString(authHeader[6:])
+		if err != nil {
+			return "", "", false
+		}
+		id, secret, ok := strings.Cut(string(raw), ":")
+		if !ok {
+			return "", "", false
+		}
+		// Credentials are form-encoded before being put in the header
+		id, errID := url.QueryUnescape(id)
+		secret, errSecret := url.QueryUnescape(secret)
+		return id, secret, errID == nil && errSecret == nil && id != ""
+	}
+
+	id, secret := c.FormValue("client_id"), c.FormValue("client_secret")
+	return id, secret, id != ""
+}
+
+// oauthError renders an RFC 6749 error response
+func oauthError(c *fiber.Ctx, status int, code, description string) error {
+	body := fiber.Map{"error": code}
+	if description != "" {
+		body["error_description"] = description
+	}
+	return c.Status(status).JSON(body)
+}
+
+// RegisterClient registers a new OAuth2 client
+func (h *AuthHandler) RegisterClient(c *fiber.Ctx) error {
+	var req RegisterClientRequest
+	if err := c.BodyParser(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	client, secret, err := h.authService.RegisterClient(service.ClientRegistration{
+		Name:         strings.TrimSpace(req.Name),
+		Public:       req.Public,
+		Scopes:       req.Scopes,
+		Audiences:    req.Audiences,
+		GrantTypes:   req.GrantTypes,
+		RedirectURIs: req.RedirectURIs,
+	})
+	if err != nil {
+		switch err {
+		case service.ErrInvalidScope:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "At least one valid scope is required",
+			})
+		case service.ErrInvalidAudience:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "At least one audience is required for the client_credentials grant",
+			})
+		case service.ErrInvalidGrantType:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Unsupported grant type",
+			})
+		case service.ErrInvalidRedirectURI:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "The authorization_code grant requires absolute redirect URIs without a fragment",
+			})
+		case service.ErrInvalidClientConfig:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Public clients cannot use the client_credentials grant",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to register client",
+		})
+	}
+
+	body := fiber.Map{
+		"client_id":     client.ClientID,
+		"name":          client.Name,
+		"public":        client.Public,
+		"scopes":        client.Scopes,
+		"audiences":     client.Audiences,
+		"grant_types":   client.GrantTypes,
+		"redirect_uris": client.RedirectURIs,
+		"created_at":    client.CreatedAt,
+	}
+	// Public clients have no secret
+	if !client.Public {
+		body["client_secret"] = secret
+		body["message"] = "Store the client secret now, it will not be shown again"
+	}
+	return c.Status(fiber.StatusCreated).JSON(body)
+}
+
+// ListClients returns all active OAuth2 clients
+func (h *AuthHandler) ListClients(c *fiber.Ctx) error {
+	clients, err := h.authService.ListClients()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list clients",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"clients": clients,
+	})
+}
+
+// RevokeClient disables an OAuth2 client
+func (h *AuthHandler) RevokeClient(c *fiber.Ctx) error {
+	if err := h.authService.RevokeClient(c.Params("client_id")); err != nil {
+		if err == service.ErrClientNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Client not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to revoke client",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Client revoked",
+	})
+}
@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/my-username/billion-user-app/pkg/jwtutils"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/service"
+)
+
+// AuthorizeRequest represents the consent decision posted by the frontend.
+// It repeats the parameters of the original authorization request.
+type AuthorizeRequest struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	Nonce               string `json:"nonce"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+	Approve             bool   `json:"approve"`
+}
+
+func (r AuthorizeRequest) authorizationRequest() service.AuthorizationRequest {
+	return service.AuthorizationRequest{
+		ResponseType:        r.ResponseType,
+		ClientID:            r.ClientID,
+		RedirectURI:         r.RedirectURI,
+		Scopes:              strings.Fields(r.Scope),
+		State:               r.State,
+		Nonce:               r.Nonce,
+		CodeChallenge:       r.CodeChallenge,
+		CodeChallengeMethod: r.CodeChallengeMethod,
+	}
+}
+
+// authorizationQuery reads an authorization request from the query string
+func authorizationQuery(c *fiber.Ctx) service.AuthorizationRequest {
+	return AuthorizeRequest{
+		ResponseType:        c.Query("response_type"),
+		ClientID:            c.Query("client_id"),
+		RedirectURI:         c.Query("redirect_uri"),
+		Scope:               c.Query("scope"),
+		State:               c.Query("state"),
+		Nonce:               c.Query("nonce"),
+		CodeChallenge:       c.Query("code_challenge"),
+		CodeChallengeMethod: c.Query("code_challenge_method"),
+	}.authorizationRequest()
+}
+
+// Discovery serves the OpenID Provider configuration
+func (h *AuthHandler) Discovery(c *fiber.Ctx) error {
+	return c.JSON(h.authService.Discovery())
+}
+
+// Authorize is the OAuth2 authorization endpoint (RFC 6749 section 3.1). It
+// sends the browser to the frontend's consent page, where the user signs in
+// if needed and approves the client.
+func (h *AuthHandler) Authorize(c *fiber.Ctx) error {
+	req := authorizationQuery(c)
+
+	consentURL, err := h.authService.BeginAuthorization(req)
+	if err != nil {
+		if msg, ok := unredirectableError(err); ok {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": msg,
+			})
+		}
+		return c.Redirect(authorizationErrorRedirect(req, err), fiber.StatusFound)
+	}
+
+	return c.Redirect(consentURL, fiber.StatusFound)
+}
+
+// GetConsent describes an authorization request to the signed-in user
+func (h *AuthHandler) GetConsent(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(*jwtutils.Claims)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid token",
+		})
+	}
+
+	consent, err := h.authService.GetConsent(claims.UserID, authorizationQuery(c))
+	if err != nil {
+		if msg, ok := unredirectableError(err); ok {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": msg,
+			})
+		}
+		if code := authorizationErrorCode(err); code != "server_error" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid authorization request: " + code,
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load authorization request",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"client": fiber.Map{
+			"client_id": consent.Client.ClientID,
+			"name":      consent.Client.Name,
+		},
+		"scopes":  consent.Scopes,
+		"granted": consent.Granted,
+	})
+}
+
+// AuthorizeConsent records the user's decision and returns where the
+// frontend should send the browser next
+func (h *AuthHandler) AuthorizeConsent(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(*jwtutils.Claims)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid token",
+		})
+	}
+
+	var body AuthorizeRequest
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	req := body.authorizationRequest()
+	redirectTo, err := h.authService.Authorize(claims, req, body.Approve)
+	if err != nil {
+		if msg, ok := unredirectableError(err); ok {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": msg,
+			})
+		}
+		if authorizationErrorCode(err) == "server_error" {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to authorize client",
+			})
+		}
+		redirectTo = authorizationErrorRedirect(req, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"redirect_to": redirectTo,
+	})
+}
+
+// UserInfo is the OpenID Connect UserInfo endpoint
+func (h *AuthHandler) UserInfo(c *fiber.Ctx) error {
+	authHeader := c.Get(fiber.HeaderAuthorization)
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	if authHeader == "" || token == authHeader {
+		c.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="auth-service"`)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "invalid_token",
+		})
+	}
+
+	info, err := h.authService.UserInfo(token)
+	if err != nil {
+		if err == service.ErrInsufficientScope {
+			c.Set(fiber.HeaderWWWAuthenticate, `Bearer error="insufficient_scope", scope="openid"`)
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "insufficient_scope",
+			})
+		}
+		c.Set(fiber.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "invalid_token",
+		})
+	}
+
+	return c.JSON(info)
+}
+
+// unredirectableError reports errors that mean the redirect URI cannot be
+// trusted, so they must not be sent back to it (RFC 6749 section 4.1.2.1)
+func unredirectableError(err error) (string, bool) {
+	switch err {
+	case service.ErrInvalidClient:
+		return "Unknown or revoked client", true
+	case service.ErrInvalidRedirectURI:
+		return "Redirect URI is not registered for this client", true
+	}
+	return "", false
+}
+
+// authorizationErrorCode maps an authorization error to its RFC 6749
+// section 4.1.2.1 error code
+func authorizationErrorCode(err error) string {
+	switch err {
+	case service.ErrUnsupportedResponseType:
+		return "unsupported_response_type"
+	case service.ErrUnauthorizedClient:
+		return "unauthorized_client"
+	case service.ErrInvalidScope:
+		return "invalid_scope"
+	case service.ErrInvalidRequest:
+		return "invalid_request"
+	}
+	return "server_error"
+}
+
+func authorizationErrorRedirect(req service.AuthorizationRequest, err error) string {
+	return service.AuthorizationRedirect(req.RedirectURI, url.Values{
+		"error": {authorizationErrorCode(err)},
+		"state": {req.State},
+	})
+}
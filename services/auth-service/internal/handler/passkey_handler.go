@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/my-username/billion-user-app/pkg/jwtutils"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/service"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/webauthn"
+)
+
+// The begin endpoints return {"publicKey": options}, ready to be passed to
+// navigator.credentials.create or navigator.credentials.get once the
+// base64url fields are decoded. The browser's credential is sent back with
+// its binary fields base64url encoded.
+
+// RegisterPasskeyRequest represents the browser's answer to a passkey
+// registration
+type RegisterPasskeyRequest struct {
+	Name       string                         `json:"name" validate:"max=100"`
+	Credential *webauthn.RegistrationResponse `json:"credential" validate:"required"`
+}
+
+// BeginPasskeyRegistration starts adding a passkey to the current user
+func (h *AuthHandler) BeginPasskeyRegistration(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(*jwtutils.Claims)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid token",
+		})
+	}
+
+	options, err := h.authService.BeginPasskeyRegistration(claims.UserID)
+	if err != nil {
+		return passkeyError(c, err, "Failed to start passkey registration")
+	}
+
+	return c.JSON(fiber.Map{
+		"publicKey": options,
+	})
+}
+
+// FinishPasskeyRegistration stores the passkey the browser created
+func (h *AuthHandler) FinishPasskeyRegistration(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(*jwtutils.Claims)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid token",
+		})
+	}
+
+	var req RegisterPasskeyRequest
+	if err := c.BodyParser(&req); err != nil || req.Credential == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	passkey, err := h.authService.FinishPasskeyRegistration(claims.UserID, req.Name, req.Credential, clientInfo(c))
+	if err != nil {
+		return passkeyError(c, err, "Failed to register passkey")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"passkey": passkey,
+	})
+}
+
+// ListPasskeys returns the current user's passkeys
+func (h *AuthHandler) ListPasskeys(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(*jwtutils.Claims)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid token",
+		})
+	}
+
+	passkeys, err := h.authService.ListPasskeys(claims.UserID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list passkeys",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"passkeys": passkeys,
+	})
+}
+
+// DeletePasskey removes one of the current user's passkeys
+func (h *AuthHandler) DeletePasskey(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(*jwtutils.Claims)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid token",
+		})
+	}
+
+	passkeyID, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid passkey ID",
+		})
+	}
+
+	if err := h.authService.DeletePasskey(claims.UserID, passkeyID, clientInfo(c)); err != nil {
+		if err == service.ErrPasskeyNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Passkey not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete passkey",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Passkey deleted",
+	})
+}
+
+// BeginPasskeyLogin starts a passkey login. The browser offers every passkey
+// it holds for this site, so no account needs to be named.
+func (h *AuthHandler) BeginPasskeyLogin(c *fiber.Ctx) error {
+	options, err := h.authService.BeginPasskeyLogin()
+	if err != nil {
+		return passkeyError(c, err, "Failed to start passkey login")
+	}
+
+	return c.JSON(fiber.Map{
+		"publicKey": options,
+	})
+}
+
+// LoginWithPasskey logs in with the browser's passkey assertion. The response
+// is the same as for a password login.
+func (h *AuthHandler) LoginWithPasskey(c *fiber.Ctx) error {
+	var req webauthn.AssertionResponse
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	result, err := h.authService.LoginWithPasskey(&req, clientInfo(c))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidPasskey):
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid passkey",
+			})
+		case err == service.ErrUserInactive:
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "User account is inactive",
+			})
+		case err == service.ErrEmailNotVerified:
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Email address is not verified",
+			})
+		}
+		return passkeyError(c, err, "Failed to login")
+	}
+
+	return c.JSON(loginResponse(result))
+}
+
+// passkeyError responds to errors shared by the passkey endpoints
+func passkeyError(c *fiber.Ctx, err error, fallback string) error {
+	switch {
+	case err == service.ErrPasskeysDisabled:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Passkeys are not enabled",
+		})
+	case errors.Is(err, service.ErrInvalidPasskey):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": fallback,
+	})
+}
@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/my-username/billion-user-app/pkg/jwtutils"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/passwords"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/service"
+)
+
+// ChangePasswordRequest represents a password change by a signed-in user
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required"`
+}
+
+// ChangePassword replaces the current user's password and logs out their
+// other sessions
+func (h *AuthHandler) ChangePassword(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(*jwtutils.Claims)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid token",
+		})
+	}
+
+	var req ChangePasswordRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	err := h.authService.ChangePassword(claims.UserID, claims.SessionID, req.CurrentPassword, req.NewPassword, clientInfo(c))
+	if err != nil {
+		if policyErr, ok := err.(*passwords.PolicyError); ok {
+			return passwordPolicyResponse(c, policyErr)
+		}
+		if err == service.ErrInvalidCredentials {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Current password is incorrect",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to change password",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Password changed successfully",
+	})
+}
+
+// passwordPolicyResponse lists every rule a rejected password breaks
+func passwordPolicyResponse(c *fiber.Ctx, policyErr *passwords.PolicyError) error {
+	return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+		"error":      "Password does not meet the password policy",
+		"violations": policyErr.Violations,
+	})
+}
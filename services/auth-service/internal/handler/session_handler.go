@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/my-username/billion-user-app/pkg/jwtutils"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/service"
+)
+
+// ListSessions returns the current user's active sessions
+func (h *AuthHandler) ListSessions(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(*jwtutils.Claims)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid token",
+		})
+	}
+
+	sessions, err := h.authService.ListSessions(claims.UserID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list sessions",
+		})
+	}
+
+	items := make([]fiber.Map, 0, len(sessions))
+	for _, session := range sessions {
+		items = append(items, fiber.Map{
+			"id":           session.ID,
+			"client_id":    session.ClientID,
+			"ip":           session.IP,
+			"user_agent":   session.UserAgent,
+			"created_at":   session.CreatedAt,
+			"last_used_at": session.LastUsedAt,
+			"expires_at":   session.ExpiresAt,
+			"current":      session.ID == claims.SessionID,
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"sessions": items,
+	})
+}
+
+// RevokeSession logs out one of the current user's sessions
+func (h *AuthHandler) RevokeSession(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(*jwtutils.Claims)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid token",
+		})
+	}
+
+	if err := h.authService.RevokeSession(claims.UserID, c.Params("id")); err != nil {
+		if err == service.ErrSessionNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Session not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to revoke session",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Session revoked",
+	})
+}
+
+// RevokeAllSessions logs the current user out everywhere, including this
+// session
+func (h *AuthHandler) RevokeAllSessions(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(*jwtutils.Claims)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid token",
+		})
+	}
+
+	if err := h.authService.RevokeAllSessions(claims.UserID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to revoke sessions",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Logged out of all sessions",
+	})
+}
@@ -0,0 +1,164 @@
+// Package lockout protects login against password guessing. Failed attempts
+// are counted per account and per client IP: after a few failures each
+// further attempt has to wait an exponentially growing delay, and past a
+// threshold the account (or IP) is locked for a while.
+package lockout
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrAccountLocked means the account hit the failure threshold
+	ErrAccountLocked = errors.New("account temporarily locked")
+	// ErrTooManyAttempts means the caller must wait before trying again
+	ErrTooManyAttempts = errors.New("too many failed attempts")
+)
+
+// Policy configures thresholds and delays
+type Policy struct {
+	Window             time.Duration // How long failures are remembered
+	FreeAttempts       int64         // Failures allowed before delays kick in
+	BaseDelay          time.Duration // First delay, doubled on every failure
+	MaxDelay           time.Duration
+	MaxAccountFailures int64 // Failures that lock the account
+	MaxIPFailures      int64 // Failures that block the IP
+	LockoutDuration    time.Duration
+}
+
+// DefaultPolicy returns the policy used by auth-service
+func DefaultPolicy() Policy {
+	return Policy{
+		Window:             15 * time.Minute,
+		FreeAttempts:       3,
+		BaseDelay:          time.Second,
+		MaxDelay:           30 * time.Second,
+		MaxAccountFailures: 10,
+		MaxIPFailures:      50,
+		LockoutDuration:    15 * time.Minute,
+	}
+}
+
+// BlockedError reports a rejected attempt and when the caller may retry
+type BlockedError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *BlockedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *BlockedError) Unwrap() error {
+	return e.Err
+}
+
+// Guard applies a Policy on top of a Store
+type Guard struct {
+	store  Store
+	policy Policy
+}
+
+// NewGuard creates a guard
+func NewGuard(store Store, policy Policy) *Guard {
+	return &Guard{store: store, policy: policy}
+}
+
+// Check returns a *BlockedError if an attempt for account from ip must be
+// rejected right now. Store errors fail open: a Redis outage must not lock
+// everybody out.
+func (g *Guard) Check(account, ip string) error {
+	if d, _ := g.store.BlockedFor(lockKey(account)); d > 0 {
+		return &BlockedError{Err: ErrAccountLocked, RetryAfter: d}
+	}
+
+	for _, key := range []string{delayKey(account), ipLockKey(ip), ipDelayKey(ip)} {
+		if d, _ := g.store.BlockedFor(key); d > 0 {
+			return &BlockedError{Err: ErrTooManyAttempts, RetryAfter: d}
+		}
+	}
+	return nil
+}
+
+// RecordFailure counts a failed attempt and applies delays or lockouts.
+// It reports whether this failure locked the account.
+func (g *Guard) RecordFailure(account, ip string) (bool, error) {
+	locked := false
+
+	accountFailures, err := g.store.Increment(accountKey(account), g.policy.Window)
+	if err != nil {
+		return false, err
+	}
+
+	if accountFailures >= g.policy.MaxAccountFailures {
+		if err := g.store.Block(lockKey(account), g.policy.LockoutDuration); err != nil {
+			return false, err
+		}
+		_ = g.store.Reset(accountKey(account))
+		locked = true
+	} else if d := g.delay(accountFailures); d > 0 {
+		_ = g.store.Block(delayKey(account), d)
+	}
+
+	if ip != "" {
+		ipFailures, err := g.store.Increment(ipKey(ip), g.policy.Window)
+		if err != nil {
+			return locked, err
+		}
+		if ipFailures >= g.policy.MaxIPFailures {
+			_ = g.store.Block(ipLockKey(ip), g.policy.LockoutDuration)
+			_ = g.store.Reset(ipKey(ip))
+		} else if d := g.delay(ipFailures - g.policy.MaxAccountFailures); d > 0 {
+			// An IP gets more leeway than a single account, since many
+			// users can share one address
+			_ = g.store.Block(ipDelayKey(ip), d)
+		}
+	}
+
+	return locked, nil
+}
+
+// RecordSuccess clears the account's failures after a successful login
+func (g *Guard) RecordSuccess(account string) error {
+	return g.store.Reset(accountKey(account))
+}
+
+// Unlock lifts an account lockout, e.g. after a password reset
+func (g *Guard) Unlock(account string) error {
+	for _, key := range []string{lockKey(account), delayKey(account)} {
+		if err := g.store.Unblock(key); err != nil {
+			return err
+		}
+	}
+	return g.store.Reset(accountKey(account))
+}
+
+// delay returns the wait imposed after the given number of failures
+func (g *Guard) delay(failures int64) time.Duration {
+	over := failures - g.policy.FreeAttempts
+	if over <= 0 {
+		return 0
+	}
+
+	d := g.policy.BaseDelay
+	for i := int64(1); i < over && d < g.policy.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > g.policy.MaxDelay {
+		d = g.policy.MaxDelay
+	}
+	return d
+}
+
+func normalize(account string) string {
+	return strings.ToLower(strings.TrimSpace(account))
+}
+
+func accountKey(account string) string { return "account:" + normalize(account) }
+func lockKey(account string) string    { return "account-lock:" + normalize(account) }
+func delayKey(account string) string   { return "account-delay:" + normalize(account) }
+func ipKey(ip string) string           { return "ip:" + ip }
+func ipLockKey(ip string) string       { return "ip-lock:" + ip }
+func ipDelayKey(ip string) string      { return "ip-delay:" + ip }
@@ -0,0 +1,36 @@
+package lockout
+
+import "time"
+
+// Limiter caps how often an action may be performed per key, such as login
+// emails sent to one address. It uses fixed windows: once a key exceeds the
+// limit it is blocked for a full window.
+type Limiter struct {
+	store  Store
+	name   string
+	limit  int64
+	window time.Duration
+}
+
+// NewLimiter allows limit actions per key within window. name keeps the
+// counters of different limiters sharing a Store apart.
+func NewLimiter(store Store, name string, limit int64, window time.Duration) *Limiter {
+	return &Limiter{store: store, name: name, limit: limit, window: window}
+}
+
+// Allow counts an action for key and returns a *BlockedError once the limit
+// is exceeded. Like Guard it fails open on store errors.
+func (l *Limiter) Allow(key string) error {
+	blockKey := "rate-block:" + l.name + ":" + normalize(key)
+	if d, _ := l.store.BlockedFor(blockKey); d > 0 {
+		return &BlockedError{Err: ErrTooManyAttempts, RetryAfter: d}
+	}
+
+	count, err := l.store.Increment("rate:"+l.name+":"+normalize(key), l.window)
+	if err != nil || count <= l.limit {
+		return nil
+	}
+
+	_ = l.store.Block(blockKey, l.window)
+	return &BlockedError{Err: ErrTooManyAttempts, RetryAfter: l.window}
+}
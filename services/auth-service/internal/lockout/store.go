@@ -0,0 +1,173 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Store keeps failure counters and time-boxed blocks. Counters and blocks
+// expire on their own, so nothing has to be cleaned up.
+type Store interface {
+	// Increment adds a failure to key and returns the count in the current
+	// window. The window starts with the first failure.
+	Increment(key string, window time.Duration) (int64, error)
+	// Reset clears the counter for key
+	Reset(key string) error
+	// Block prevents attempts on key for d
+	Block(key string, d time.Duration) error
+	// Unblock lifts a block early
+	Unblock(key string) error
+	// BlockedFor returns how long key remains blocked, or zero
+	BlockedFor(key string) (time.Duration, error)
+}
+
+const redisTimeout = 500 * time.Millisecond
+
+// RedisStore shares counters between all auth-service replicas
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore creates a store on the given Redis client
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func (s *RedisStore) Increment(key string, window time.Duration) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	pipe := s.client.TxPipeline()
+	incr := pipe.Incr(ctx, "lockout:count:"+key)
+	pipe.ExpireNX(ctx, "lockout:count:"+key, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+func (s *RedisStore) Reset(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return s.client.Del(ctx, "lockout:count:"+key).Err()
+}
+
+func (s *RedisStore) Block(key string, d time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return s.client.Set(ctx, "lockout:block:"+key, 1, d).Err()
+}
+
+func (s *RedisStore) Unblock(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return s.client.Del(ctx, "lockout:block:"+key).Err()
+}
+
+func (s *RedisStore) BlockedFor(key string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	ttl, err := s.client.PTTL(ctx, "lockout:block:"+key).Result()
+	if err != nil {
+		return 0, err
+	}
+	// Negative values mean the key does not exist or has no expiry
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// MemoryStore is a single-process fallback used when Redis is unavailable.
+// Counters are not shared between replicas.
+type MemoryStore struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	counts  map[string]memoryCounter
+	blocked map[string]time.Time
+}
+
+type memoryCounter struct {
+	count     int64
+	expiresAt time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		now:     time.Now,
+		counts:  make(map[string]memoryCounter),
+		blocked: make(map[string]time.Time),
+	}
+}
+
+func (s *MemoryStore) Increment(key string, window time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	c, ok := s.counts[key]
+	if !ok {
+		c = memoryCounter{expiresAt: now.Add(window)}
+	}
+	c.count++
+	s.counts[key] = c
+	return c.count, nil
+}
+
+func (s *MemoryStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.counts, key)
+	return nil
+}
+
+func (s *MemoryStore) Block(key string, d time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blocked[key] = s.now().Add(d)
+	return nil
+}
+
+func (s *MemoryStore) Unblock(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.blocked, key)
+	return nil
+}
+
+func (s *MemoryStore) BlockedFor(key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	until, ok := s.blocked[key]
+	if !ok {
+		return 0, nil
+	}
+	remaining := until.Sub(s.now())
+	if remaining <= 0 {
+		delete(s.blocked, key)
+		return 0, nil
+	}
+	return remaining, nil
+}
+
+// sweep drops expired counters and blocks so the maps cannot grow forever
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, c := range s.counts {
+		if now.After(c.expiresAt) {
+			delete(s.counts, key)
+		}
+	}
+	for key, until := range s.blocked {
+		if now.After(until) {
+			delete(s.blocked, key)
+		}
+	}
+}
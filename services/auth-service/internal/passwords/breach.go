@@ -0,0 +1,59 @@
+package passwords
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BreachChecker reports whether a password is known from a data breach
+type BreachChecker interface {
+	Breached(password string) (bool, error)
+}
+
+// PrefixDir is an offline breached-password dataset in the k-anonymity
+// format of Have I Been Pwned's range API: passwords are identified by their
+// uppercase hex SHA-1, and the file <dir>/<first 5 hex digits>.txt lists the
+// remaining 35 digits of every breached hash with that prefix as
+// "SUFFIX:COUNT" lines. The official downloader can produce this layout.
+//
+// Only the one file for the password's prefix is read, so the dataset does
+// not have to fit in memory. A missing prefix file counts as not breached,
+// which lets a partial dataset be used for testing.
+type PrefixDir struct {
+	dir string
+}
+
+// NewPrefixDir uses the dataset stored in dir
+func NewPrefixDir(dir string) *PrefixDir {
+	return &PrefixDir{dir: dir}
+}
+
+func (d *PrefixDir) Breached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	f, err := os.Open(filepath.Join(d.dir, prefix+".txt"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		candidate, _, _ := strings.Cut(scanner.Text(), ":")
+		if strings.EqualFold(strings.TrimSpace(candidate), suffix) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,189 @@
+package passwords
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUnknownHash means no configured algorithm recognises a stored hash
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+// Algorithm is one way of hashing passwords. Hashes are self-describing:
+// they encode the algorithm and its parameters, so they can still be
+// verified after the configuration changes.
+type Algorithm interface {
+	Hash(password string) (string, error)
+	Verify(encoded, password string) (bool, error)
+	// Recognizes reports whether encoded was produced by this algorithm
+	Recognizes(encoded string) bool
+	// Outdated reports whether encoded uses other parameters than the ones
+	// new hashes would get
+	Outdated(encoded string) bool
+}
+
+// Hasher hashes new passwords with a preferred algorithm and verifies
+// hashes made by any of the algorithms it knows
+type Hasher struct {
+	preferred  Algorithm
+	algorithms []Algorithm
+}
+
+// NewHasher hashes with preferred and also accepts hashes from legacy
+// algorithms
+func NewHasher(preferred Algorithm, legacy ...Algorithm) *Hasher {
+	return &Hasher{preferred: preferred, algorithms: append([]Algorithm{preferred}, legacy...)}
+}
+
+// DefaultHasher uses bcrypt at its default cost and accepts argon2id hashes
+func DefaultHasher() *Hasher {
+	return NewHasher(Bcrypt{Cost: bcrypt.DefaultCost}, DefaultArgon2id())
+}
+
+// Hash hashes a password for storage
+func (h *Hasher) Hash(password string) (string, error) {
+	return h.preferred.Hash(password)
+}
+
+// Verify checks password against a stored hash. rehash is set when the
+// password matched but the hash should be replaced by Hash(password),
+// because it was made with another algorithm or outdated parameters.
+func (h *Hasher) Verify(encoded, password string) (match, rehash bool, err error) {
+	for _, algorithm := range h.algorithms {
+		if !algorithm.Recognizes(encoded) {
+			continue
+		}
+		match, err := algorithm.Verify(encoded, password)
+		if err != nil || !match {
+			return false, false, err
+		}
+		return true, algorithm != h.preferred || algorithm.Outdated(encoded), nil
+	}
+	return false, false, ErrUnknownHash
+}
+
+// Bcrypt hashes with bcrypt at Cost
+type Bcrypt struct {
+	Cost int
+}
+
+func (b Bcrypt) Hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+func (b Bcrypt) Verify(encoded, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b Bcrypt) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (b Bcrypt) Outdated(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != b.Cost
+}
+
+// Argon2id hashes with argon2id and encodes hashes in the PHC string format
+// used by the reference implementation:
+// $argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<key>
+type Argon2id struct {
+	Memory      uint32 // In KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2id returns the parameters recommended by OWASP
+func DefaultArgon2id() Argon2id {
+	return Argon2id{
+		Memory:      19 * 1024,
+		Iterations:  2,
+		Parallelism: 1,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+// argon2idParams are the parameters decoded from a stored hash
+type argon2idParams struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+func (a Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, a.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.Iterations, a.Memory, a.Parallelism, a.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, a.Memory, a.Iterations, a.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (a Argon2id) Verify(encoded, password string) (bool, error) {
+	params, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	key := argon2.IDKey([]byte(password), params.salt, params.iterations, params.memory, params.parallelism,
+		uint32(len(params.key)))
+	return subtle.ConstantTimeCompare(key, params.key) == 1, nil
+}
+
+func (a Argon2id) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (a Argon2id) Outdated(encoded string) bool {
+	params, err := decodeArgon2id(encoded)
+	return err != nil || params.memory != a.Memory || params.iterations != a.Iterations ||
+		params.parallelism != a.Parallelism || uint32(len(params.salt)) != a.SaltLength ||
+		uint32(len(params.key)) != a.KeyLength
+}
+
+func decodeArgon2id(encoded string) (*argon2idParams, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, ErrUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, ErrUnknownHash
+	}
+
+	var params argon2idParams
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism); err != nil {
+		return nil, ErrUnknownHash
+	}
+
+	var err error
+	if params.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, ErrUnknownHash
+	}
+	if params.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(params.key) == 0 {
+		return nil, ErrUnknownHash
+	}
+	return &params, nil
+}
@@ -0,0 +1,130 @@
+// Package passwords decides which passwords users may choose: a configurable
+// policy on length, character classes and personal information, plus a check
+// against a dataset of passwords known from data breaches. It also hashes
+// passwords for storage.
+package passwords
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Policy describes acceptable passwords
+type Policy struct {
+	MinLength      int  // In characters
+	MaxLength      int  // In bytes; bcrypt ignores everything past 72
+	MinClasses     int  // Of lowercase, uppercase, digits and symbols
+	RejectPersonal bool // Reject passwords containing the username or email
+	HistorySize    int  // Recent passwords, including the current one, that may not be reused
+}
+
+// DefaultPolicy returns the policy used by auth-service
+func DefaultPolicy() Policy {
+	return Policy{
+		MinLength:      8,
+		MaxLength:      72,
+		MinClasses:     2,
+		RejectPersonal: true,
+		HistorySize:    5,
+	}
+}
+
+// PolicyError lists every rule a password breaks, so users can fix them all
+// at once
+type PolicyError struct {
+	Violations []string
+}
+
+func (e *PolicyError) Error() string {
+	return "password " + strings.Join(e.Violations, ", ")
+}
+
+// Checker validates new passwords against a Policy and, if configured, a
+// breached-password dataset
+type Checker struct {
+	policy   Policy
+	breaches BreachChecker
+}
+
+// NewChecker creates a Checker. breaches may be nil to skip the breach check.
+func NewChecker(policy Policy, breaches BreachChecker) *Checker {
+	return &Checker{policy: policy, breaches: breaches}
+}
+
+// Policy returns the policy the checker enforces
+func (c *Checker) Policy() Policy {
+	return c.policy
+}
+
+// Check returns a *PolicyError if password may not be used by the account
+// with the given username and email. Like the lockout package it fails open
+// when the breach dataset cannot be read.
+func (c *Checker) Check(password, username, email string) error {
+	var violations []string
+
+	if c.policy.MinLength > 0 && utf8.RuneCountInString(password) < c.policy.MinLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters long", c.policy.MinLength))
+	}
+	if c.policy.MaxLength > 0 && len(password) > c.policy.MaxLength {
+		violations = append(violations, fmt.Sprintf("must be at most %d bytes long", c.policy.MaxLength))
+	}
+	if c.policy.MinClasses > 1 && characterClasses(password) < c.policy.MinClasses {
+		violations = append(violations, fmt.Sprintf(
+			"must mix at least %d of lowercase letters, uppercase letters, digits and symbols", c.policy.MinClasses))
+	}
+	if c.policy.RejectPersonal && containsPersonal(password, username, email) {
+		violations = append(violations, "must not contain your username or email address")
+	}
+
+	if c.breaches != nil {
+		if breached, err := c.breaches.Breached(password); err == nil && breached {
+			violations = append(violations, "has appeared in a data breach and must not be used")
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// ReusedError is returned when a password is among the account's recent ones
+func (c *Checker) ReusedError() error {
+	return &PolicyError{Violations: []string{
+		fmt.Sprintf("must not be one of your last %d passwords", c.policy.HistorySize),
+	}}
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
+
+// containsPersonal reports whether password contains the username or the
+// local part of the email. Very short values are ignored, since they would
+// reject too many unrelated passwords.
+func containsPersonal(password, username, email string) bool {
+	password = strings.ToLower(password)
+	local, _, _ := strings.Cut(email, "@")
+	for _, value := range []string{username, local} {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if utf8.RuneCountInString(value) >= 3 && strings.Contains(password, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -4,16 +4,44 @@ import (
 	"errors"
 	"time"
 
+	"github.com/my-username/billion-user-app/pkg/kafkaclient"
+	"github.com/my-username/billion-user-app/pkg/outbox"
 	"github.com/my-username/billion-user-app/services/auth-service/internal/domain"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// eventSource identifies this service as the producer of its events
+const eventSource = "auth-service"
+
 var (
-	ErrUserNotFound      = errors.New("user not found")
-	ErrUserAlreadyExists = errors.New("user already exists")
-	ErrTokenNotFound     = errors.New("refresh token not found")
+	ErrUserNotFound         = errors.New("user not found")
+	ErrUserAlreadyExists    = errors.New("user already exists")
+	ErrTokenNotFound        = errors.New("refresh token not found")
+	ErrRoleNotFound         = errors.New("role not found")
+	ErrTokenAlreadyUsed     = errors.New("refresh token already used")
+	ErrOneTimeTokenNotFound = errors.New("one-time token not found")
+	ErrSessionNotFound      = errors.New("session not found")
+	ErrAPIKeyNotFound       = errors.New("API key not found")
+	ErrClientNotFound       = errors.New("OAuth client not found")
+	ErrCodeNotFound         = errors.New("authorization code not found")
+	ErrCodeAlreadyUsed      = errors.New("authorization code already used")
+	ErrConsentNotFound      = errors.New("consent not found")
+	ErrIdentityNotFound     = errors.New("external identity not found")
+	ErrPasskeyNotFound      = errors.New("passkey not found")
+	ErrMFAStepUsed          = errors.New("TOTP step already used")
 )
 
+// AuditFilter selects audit log entries. Zero fields match everything.
+type AuditFilter struct {
+	UserID  uint64 // Matches the actor or the target
+	Action  string
+	Outcome string
+	IP      string
+	Since   time.Time
+	Until   time.Time
+}
+
 // AuthRepository defines the interface for auth data operations
 type AuthRepository interface {
 	CreateUser(user *domain.User) error
@@ -21,10 +49,68 @@ type AuthRepository interface {
 	GetUserByID(id uint64) (*domain.User, error)
 	GetUserByUsername(username string) (*domain.User, error)
 	UpdateUser(user *domain.User) error
+	UpdateUserFields(user *domain.User, fields ...string) error
+	AdvanceMFAStep(userID uint64, step int64) error
+	ReplacePasswordHash(userID uint64, oldHash, newHash string) error
+	SearchUsers(query string, active *bool, offset, limit int) ([]*domain.User, int64, error)
 	SaveRefreshToken(token *domain.RefreshToken) error
 	GetRefreshToken(token string) (*domain.RefreshToken, error)
+	GetRefreshTokenByParent(parentID uint64) (*domain.RefreshToken, error)
 	DeleteRefreshToken(token string) error
+	MarkRefreshTokenUsed(id uint64) error
 	DeleteExpiredTokens() error
+	ReplaceRecoveryCodes(userID uint64, codes []*domain.RecoveryCode) error
+	UseRecoveryCode(userID uint64, codeHash string) error
+	DeleteRecoveryCodes(userID uint64) error
+	ListPasswordHistory(userID uint64, limit int) ([]*domain.PasswordHistory, error)
+	AddPasswordHistory(entry *domain.PasswordHistory, keep int) error
+	SaveOneTimeToken(token *domain.OneTimeToken) error
+	GetOneTimeToken(purpose, tokenHash string) (*domain.OneTimeToken, error)
+	IncrementOneTimeTokenAttempts(id uint64) error
+	UseOneTimeToken(id uint64) error
+	InvalidateOneTimeTokens(userID uint64, purpose string) error
+	CreateSession(session *domain.Session) error
+	GetSession(id string) (*domain.Session, error)
+	ListActiveSessions(userID uint64) ([]*domain.Session, error)
+	TouchSession(id, ip, userAgent string, expiresAt time.Time) error
+	RevokeSession(id string) error
+	RevokeAllSessions(userID uint64) error
+	CreateAPIKey(key *domain.APIKey) error
+	GetAPIKey(id uint64) (*domain.APIKey, error)
+	GetAPIKeyByHash(keyHash string) (*domain.APIKey, error)
+	ListAPIKeys(userID uint64) ([]*domain.APIKey, error)
+	TouchAPIKey(id uint64) error
+	RevokeAPIKey(id uint64) error
+	RevokeAllAPIKeys(userID uint64) error
+	CreateOAuthClient(client *domain.OAuthClient) error
+	GetOAuthClient(clientID string) (*domain.OAuthClient, error)
+	ListOAuthClients() ([]*domain.OAuthClient, error)
+	RevokeOAuthClient(clientID string) error
+	SaveAuthorizationCode(code *domain.AuthorizationCode) error
+	GetAuthorizationCode(codeHash string) (*domain.AuthorizationCode, error)
+	RedeemAuthorizationCode(id uint64, sessionID string) error
+	GetConsent(userID uint64, clientID string) (*domain.OAuthConsent, error)
+	SaveConsent(consent *domain.OAuthConsent) error
+	CreateExternalIdentity(identity *domain.ExternalIdentity) error
+	GetExternalIdentity(provider, subject string) (*domain.ExternalIdentity, error)
+	ListExternalIdentities(userID uint64) ([]*domain.ExternalIdentity, error)
+	TouchExternalIdentity(id uint64, email string) error
+	DeleteExternalIdentity(userID, id uint64) error
+	CreatePasskey(passkey *domain.Passkey) error
+	GetPasskeyByCredentialID(credentialID []byte) (*domain.Passkey, error)
+	ListPasskeys(userID uint64) ([]*domain.Passkey, error)
+	UpdatePasskeyUsage(id uint64, signCount uint32, backedUp bool) error
+	DeletePasskey(userID, id uint64) error
+	CreateAuditEntry(entry *domain.AuditEntry) error
+	ListAuditEntries(filter AuditFilter, offset, limit int) ([]*domain.AuditEntry, int64, error)
+	ProtectAuditLog() error
+	EnsureRole(role *domain.Role) error
+	GetRoleByName(name string) (*domain.Role, error)
+	ListRoles() ([]*domain.Role, error)
+	AssignRole(userID uint64, role *domain.Role) error
+	RemoveRole(userID uint64, role *domain.Role) error
+	EnqueueEvent(topic, key string, event interface{}) error
+	Transaction(fn func(tx AuthRepository) error) error
 }
 
 type authRepository struct {
@@ -36,6 +122,24 @@ func NewAuthRepository(db *gorm.DB) AuthRepository {
 	return &authRepository{db: db}
 }
 
+// EnqueueEvent adds an event to the outbox, to be published by the relay.
+// key is the ID of the aggregate, usually the user, the event is about.
+func (r *authRepository) EnqueueEvent(topic, key string, event interface{}) error {
+	envelope, err := kafkaclient.NewEnvelope(r.db.Statement.Context, eventSource, topic, key, event)
+	if err != nil {
+		return err
+	}
+	return outbox.Enqueue(r.db, envelope)
+}
+
+// Transaction runs fn with a repository whose operations commit together
+// and whose events share a correlation ID
+func (r *authRepository) Transaction(fn func(tx AuthRepository) error) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&authRepository{db: tx.WithContext(kafkaclient.NewCorrelation(tx.Statement.Context))})
+	})
+}
+
 func (r *authRepository) CreateUser(user *domain.User) error {
 	if err := r.db.Create(user).Error; err != nil {
 		if errors.Is(err, gorm.ErrDuplicatedKey) {
@@ -48,7 +152,7 @@ func (r *authRepository) CreateUser(user *domain.User) error {
 
 func (r *authRepository) GetUserByEmail(email string) (*domain.User, error) {
 	var user domain.User
-	if err := r.db.Where("email = ?", email).First(&user).Error; err != nil {
+	if err := r.db.Preload("Roles").Where("email = ?", email).First(&user).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, ErrUserNotFound
 		}
@@ -59,7 +163,7 @@ func (r *authRepository) GetUserByEmail(email string) (*domain.User, error) {
 
 func (r *authRepository) GetUserByID(id uint64) (*domain.User, error) {
 	var user domain.User
-	if err := r.db.First(&user, id).Error; err != nil {
+	if err := r.db.Preload("Roles").First(&user, id).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, ErrUserNotFound
 		}
@@ -70,7 +174,7 @@ func (r *authRepository) GetUserByID(id uint64) (*domain.User, error) {
 
 func (r *authRepository) GetUserByUsername(username string) (*domain.User, error) {
 	var user domain.User
-	if err := r.db.Where("username = ?", username).First(&user).Error; err != nil {
+	if err := r.db.Preload("Roles").Where("username = ?", username).First(&user).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, ErrUserNotFound
 		}
@@ -80,7 +184,67 @@ func (r *authRepository) GetUserByUsername(username string) (*domain.User, error
 }
 
 func (r *authRepository) UpdateUser(user *domain.User) error {
-	return r.db.Save(user).Error
+	return r.db.Omit("Roles").Save(user).Error
+}
+
+// UpdateUserFields writes only the given fields of user, so columns changed
+// by others since it was loaded are left alone
+func (r *authRepository) UpdateUserFields(user *domain.User, fields ...string) error {
+	return r.db.Model(user).Select(append(fields, "UpdatedAt")).Updates(user).Error
+}
+
+// AdvanceMFAStep records the TOTP step of an accepted code. The update is
+// conditional so that a code cannot be used by two concurrent logins.
+func (r *authRepository) AdvanceMFAStep(userID uint64, step int64) error {
+	result := r.db.Model(&domain.User{}).
+		Where("id = ? AND mfa_last_step < ?", userID, step).
+		Update("mfa_last_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrMFAStepUsed
+	}
+	return nil
+}
+
+// ReplacePasswordHash swaps a password hash for a rehash of the same
+// password. Nothing changes if the password was changed in the meantime.
+func (r *authRepository) ReplacePasswordHash(userID uint64, oldHash, newHash string) error {
+	return r.db.Model(&domain.User{}).
+		Where("id = ? AND password = ?", userID, oldHash).
+		Update("password", newHash).Error
+}
+
+// SearchUsers returns a page of users whose email or username contains
+// query, optionally only active or inactive ones, and the total number of
+// matches
+func (r *authRepository) SearchUsers(query string, active *bool, offset, limit int) ([]*domain.User, int64, error) {
+	filter := func(db *gorm.DB) *gorm.DB {
+		if query != "" {
+			searchPattern := "%" + query + "%"
+			db = db.Where("email ILIKE ? OR username ILIKE ?", searchPattern, searchPattern)
+		}
+		if active != nil {
+			db = db.Where("is_active = ?", *active)
+		}
+		return db
+	}
+
+	var total int64
+	if err := r.db.Model(&domain.User{}).Scopes(filter).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var users []*domain.User
+	if err := r.db.Preload("Roles").Scopes(filter).
+		Order("id").
+		Offset(offset).
+		Limit(limit).
+		Find(&users).Error; err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
 }
 
 func (r *authRepository) SaveRefreshToken(token *domain.RefreshToken) error {
@@ -98,12 +262,537 @@ func (r *authRepository) GetRefreshToken(token string) (*domain.RefreshToken, er
 	return &refreshToken, nil
 }
 
+// GetRefreshTokenByParent returns the token a rotation issued in place of
+// the parent
+func (r *authRepository) GetRefreshTokenByParent(parentID uint64) (*domain.RefreshToken, error) {
+	var refreshToken domain.RefreshToken
+	if err := r.db.Where("parent_id = ?", parentID).First(&refreshToken).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTokenNotFound
+		}
+		return nil, err
+	}
+	return &refreshToken, nil
+}
+
 func (r *authRepository) DeleteRefreshToken(token string) error {
 	return r.db.Where("token = ?", token).Delete(&domain.RefreshToken{}).Error
 }
 
+// MarkRefreshTokenUsed flags a token as rotated. The update is conditional so
+// that two concurrent rotations of the same token cannot both succeed.
+func (r *authRepository) MarkRefreshTokenUsed(id uint64) error {
+	result := r.db.Model(&domain.RefreshToken{}).
+		Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", id).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTokenAlreadyUsed
+	}
+	return nil
+}
+
 func (r *authRepository) DeleteExpiredTokens() error {
 	return r.db.Where("expires_at < ?", time.Now()).Delete(&domain.RefreshToken{}).Error
 }
 
+// ReplaceRecoveryCodes discards any existing codes and stores the new set
+func (r *authRepository) ReplaceRecoveryCodes(userID uint64, codes []*domain.RecoveryCode) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&domain.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if len(codes) == 0 {
+			return nil
+		}
+		return tx.Create(codes).Error
+	})
+}
+
+func (r *authRepository) UseRecoveryCode(userID uint64, codeHash string) error {
+	result := r.db.Model(&domain.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrOneTimeTokenNotFound
+	}
+	return nil
+}
+
+func (r *authRepository) DeleteRecoveryCodes(userID uint64) error {
+	return r.db.Where("user_id = ?", userID).Delete(&domain.RecoveryCode{}).Error
+}
+
+// ListPasswordHistory returns the user's most recently replaced passwords,
+// newest first
+func (r *authRepository) ListPasswordHistory(userID uint64, limit int) ([]*domain.PasswordHistory, error) {
+	var entries []*domain.PasswordHistory
+	if err := r.db.Where("user_id = ?", userID).
+		Order("created_at DESC, id DESC").
+		Limit(limit).
+		Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// AddPasswordHistory stores a replaced password and deletes all but the
+// newest keep entries of the user
+func (r *authRepository) AddPasswordHistory(entry *domain.PasswordHistory, keep int) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(entry).Error; err != nil {
+			return err
+		}
+		newest := tx.Model(&domain.PasswordHistory{}).
+			Select("id").
+			Where("user_id = ?", entry.UserID).
+			Order("created_at DESC, id DESC").
+			Limit(keep)
+		return tx.Where("user_id = ? AND id NOT IN (?)", entry.UserID, newest).
+			Delete(&domain.PasswordHistory{}).Error
+	})
+}
+
+func (r *authRepository) SaveOneTimeToken(token *domain.OneTimeToken) error {
+	return r.db.Create(token).Error
+}
+
+// GetOneTimeToken returns an unused, unexpired token for the given purpose
+func (r *authRepository) GetOneTimeToken(purpose, tokenHash string) (*domain.OneTimeToken, error) {
+	var token domain.OneTimeToken
+	if err := r.db.Where("purpose = ? AND token_hash = ? AND used_at IS NULL AND expires_at > ?",
+		purpose, tokenHash, time.Now()).
+		First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOneTimeTokenNotFound
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *authRepository) IncrementOneTimeTokenAttempts(id uint64) error {
+	return r.db.Model(&domain.OneTimeToken{}).
+		Where("id = ?", id).
+		Update("attempts", gorm.Expr("attempts + 1")).Error
+}
+
+// UseOneTimeToken marks a token as consumed. The update is conditional so a
+// token can only ever be used once, even under concurrent requests.
+func (r *authRepository) UseOneTimeToken(id uint64) error {
+	result := r.db.Model(&domain.OneTimeToken{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrOneTimeTokenNotFound
+	}
+	return nil
+}
+
+// InvalidateOneTimeTokens consumes every outstanding token of a purpose for
+// the user, e.g. older reset links once the password has been changed
+func (r *authRepository) InvalidateOneTimeTokens(userID uint64, purpose string) error {
+	return r.db.Model(&domain.OneTimeToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", time.Now()).Error
+}
+
+func (r *authRepository) CreateSession(session *domain.Session) error {
+	return r.db.Create(session).Error
+}
+
+func (r *authRepository) GetSession(id string) (*domain.Session, error) {
+	var session domain.Session
+	if err := r.db.Where("id = ?", id).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *authRepository) ListActiveSessions(userID uint64) ([]*domain.Session, error) {
+	var sessions []*domain.Session
+	if err := r.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at DESC").
+		Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// TouchSession records a refresh: the client's current address and the new
+// expiry of the session
+func (r *authRepository) TouchSession(id, ip, userAgent string, expiresAt time.Time) error {
+	return r.db.Model(&domain.Session{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Updates(map[string]interface{}{
+			"ip":           ip,
+			"user_agent":   userAgent,
+			"last_used_at": time.Now(),
+			"expires_at":   expiresAt,
+		}).Error
+}
+
+// RevokeSession ends a session together with its refresh-token family
+func (r *authRepository) RevokeSession(id string) error {
+	now := time.Now()
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&domain.Session{}).
+			Where("id = ? AND revoked_at IS NULL", id).
+			Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Model(&domain.RefreshToken{}).
+			Where("family_id = ? AND revoked_at IS NULL", id).
+			Update("revoked_at", now).Error
+	})
+}
+
+// RevokeAllSessions ends every session and refresh token of the user
+func (r *authRepository) RevokeAllSessions(userID uint64) error {
+	now := time.Now()
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&domain.Session{}).
+			Where("user_id = ? AND revoked_at IS NULL", userID).
+			Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Model(&domain.RefreshToken{}).
+			Where("user_id = ? AND revoked_at IS NULL", userID).
+			Update("revoked_at", now).Error
+	})
+}
+
+func (r *authRepository) CreateAPIKey(key *domain.APIKey) error {
+	return r.db.Create(key).Error
+}
+
+func (r *authRepository) GetAPIKey(id uint64) (*domain.APIKey, error) {
+	var key domain.APIKey
+	if err := r.db.First(&key, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (r *authRepository) GetAPIKeyByHash(keyHash string) (*domain.APIKey, error) {
+	var key domain.APIKey
+	if err := r.db.Where("key_hash = ?", keyHash).First(&key).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+// ListAPIKeys returns the user's keys that have not been revoked, including
+// expired ones so users can see why a script stopped working
+func (r *authRepository) ListAPIKeys(userID uint64) ([]*domain.APIKey, error) {
+	var keys []*domain.APIKey
+	if err := r.db.Where("user_id = ? AND revoked_at IS NULL", userID).
+		Order("created_at DESC").
+		Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r *authRepository) TouchAPIKey(id uint64) error {
+	return r.db.Model(&domain.APIKey{}).Where("id = ?", id).Update("last_used_at", time.Now()).Error
+}
+
+func (r *authRepository) RevokeAPIKey(id uint64) error {
+	return r.db.Model(&domain.APIKey{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (r *authRepository) RevokeAllAPIKeys(userID uint64) error {
+	return r.db.Model(&domain.APIKey{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (r *authRepository) CreateOAuthClient(client *domain.OAuthClient) error {
+	return r.db.Create(client).Error
+}
+
+func (r *authRepository) GetOAuthClient(clientID string) (*domain.OAuthClient, error) {
+	var client domain.OAuthClient
+	if err := r.db.Where("client_id = ?", clientID).First(&client).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrClientNotFound
+		}
+		return nil, err
+	}
+	return &client, nil
+}
+
+func (r *authRepository) ListOAuthClients() ([]*domain.OAuthClient, error) {
+	var clients []*domain.OAuthClient
+	if err := r.db.Where("revoked_at IS NULL").Order("name").Find(&clients).Error; err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+func (r *authRepository) RevokeOAuthClient(clientID string) error {
+	result := r.db.Model(&domain.OAuthClient{}).
+		Where("client_id = ? AND revoked_at IS NULL", clientID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrClientNotFound
+	}
+	return nil
+}
+
+func (r *authRepository) SaveAuthorizationCode(code *domain.AuthorizationCode) error {
+	return r.db.Create(code).Error
+}
+
+// GetAuthorizationCode returns a code whether or not it was already used, so
+// that replays can be detected
+func (r *authRepository) GetAuthorizationCode(codeHash string) (*domain.AuthorizationCode, error) {
+	var code domain.AuthorizationCode
+	if err := r.db.Where("code_hash = ?", codeHash).First(&code).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCodeNotFound
+		}
+		return nil, err
+	}
+	return &code, nil
+}
+
+// RedeemAuthorizationCode marks a code as used by the session it was
+// exchanged for. The update is conditional so a code can be redeemed once.
+func (r *authRepository) RedeemAuthorizationCode(id uint64, sessionID string) error {
+	result := r.db.Model(&domain.AuthorizationCode{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Updates(map[string]interface{}{"used_at": time.Now(), "session_id": sessionID})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrCodeAlreadyUsed
+	}
+	return nil
+}
 
+func (r *authRepository) GetConsent(userID uint64, clientID string) (*domain.OAuthConsent, error) {
+	var consent domain.OAuthConsent
+	if err := r.db.Where("user_id = ? AND client_id = ?", userID, clientID).First(&consent).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrConsentNotFound
+		}
+		return nil, err
+	}
+	return &consent, nil
+}
+
+// SaveConsent creates or replaces the user's consent for a client
+func (r *authRepository) SaveConsent(consent *domain.OAuthConsent) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "client_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"scopes", "updated_at"}),
+	}).Create(consent).Error
+}
+
+func (r *authRepository) CreateExternalIdentity(identity *domain.ExternalIdentity) error {
+	return r.db.Create(identity).Error
+}
+
+func (r *authRepository) GetExternalIdentity(provider, subject string) (*domain.ExternalIdentity, error) {
+	var identity domain.ExternalIdentity
+	if err := r.db.Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrIdentityNotFound
+		}
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (r *authRepository) ListExternalIdentities(userID uint64) ([]*domain.ExternalIdentity, error) {
+	var identities []*domain.ExternalIdentity
+	if err := r.db.Where("user_id = ?", userID).Order("created_at").Find(&identities).Error; err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+// TouchExternalIdentity records a login and the email the provider reported
+// with it
+func (r *authRepository) TouchExternalIdentity(id uint64, email string) error {
+	return r.db.Model(&domain.ExternalIdentity{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{"email": email, "last_login_at": time.Now()}).Error
+}
+
+func (r *authRepository) DeleteExternalIdentity(userID, id uint64) error {
+	result := r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&domain.ExternalIdentity{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrIdentityNotFound
+	}
+	return nil
+}
+
+func (r *authRepository) CreatePasskey(passkey *domain.Passkey) error {
+	return r.db.Create(passkey).Error
+}
+
+func (r *authRepository) GetPasskeyByCredentialID(credentialID []byte) (*domain.Passkey, error) {
+	var passkey domain.Passkey
+	if err := r.db.Where("credential_id = ?", credentialID).First(&passkey).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPasskeyNotFound
+		}
+		return nil, err
+	}
+	return &passkey, nil
+}
+
+func (r *authRepository) ListPasskeys(userID uint64) ([]*domain.Passkey, error) {
+	var passkeys []*domain.Passkey
+	if err := r.db.Where("user_id = ?", userID).Order("created_at").Find(&passkeys).Error; err != nil {
+		return nil, err
+	}
+	return passkeys, nil
+}
+
+// UpdatePasskeyUsage records a login with the passkey and the authenticator's
+// new signature counter
+func (r *authRepository) UpdatePasskeyUsage(id uint64, signCount uint32, backedUp bool) error {
+	return r.db.Model(&domain.Passkey{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"sign_count":   signCount,
+		"backed_up":    backedUp,
+		"last_used_at": time.Now(),
+	}).Error
+}
+
+func (r *authRepository) DeletePasskey(userID, id uint64) error {
+	result := r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&domain.Passkey{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrPasskeyNotFound
+	}
+	return nil
+}
+
+func (r *authRepository) CreateAuditEntry(entry *domain.AuditEntry) error {
+	return r.db.Create(entry).Error
+}
+
+// ListAuditEntries returns a page of matching entries, newest first, and the
+// total number of matches
+func (r *authRepository) ListAuditEntries(filter AuditFilter, offset, limit int) ([]*domain.AuditEntry, int64, error) {
+	scope := func(db *gorm.DB) *gorm.DB {
+		if filter.UserID != 0 {
+			db = db.Where("actor_id = ? OR target_user_id = ?", filter.UserID, filter.UserID)
+		}
+		if filter.Action != "" {
+			db = db.Where("action = ?", filter.Action)
+		}
+		if filter.Outcome != "" {
+			db = db.Where("outcome = ?", filter.Outcome)
+		}
+		if filter.IP != "" {
+			db = db.Where("ip = ?", filter.IP)
+		}
+		if !filter.Since.IsZero() {
+			db = db.Where("created_at >= ?", filter.Since)
+		}
+		if !filter.Until.IsZero() {
+			db = db.Where("created_at < ?", filter.Until)
+		}
+		return db
+	}
+
+	var total int64
+	if err := r.db.Model(&domain.AuditEntry{}).Scopes(scope).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var entries []*domain.AuditEntry
+	if err := r.db.Scopes(scope).
+		Order("created_at DESC, id DESC").
+		Offset(offset).
+		Limit(limit).
+		Find(&entries).Error; err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
+
+// ProtectAuditLog installs a trigger that rejects updates and deletes on the
+// audit log, so a bug or a careless query cannot alter recorded entries
+func (r *authRepository) ProtectAuditLog() error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql`).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log").Error; err != nil {
+			return err
+		}
+		return tx.Exec(`CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_log
+	FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only()`).Error
+	})
+}
+
+func (r *authRepository) EnsureRole(role *domain.Role) error {
+	return r.db.Where(domain.Role{Name: role.Name}).
+		Attrs(domain.Role{Description: role.Description, Permissions: role.Permissions}).
+		FirstOrCreate(role).Error
+}
+
+func (r *authRepository) GetRoleByName(name string) (*domain.Role, error) {
+	var role domain.Role
+	if err := r.db.Where("name = ?", name).First(&role).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRoleNotFound
+		}
+		return nil, err
+	}
+	return &role, nil
+}
+
+func (r *authRepository) ListRoles() ([]*domain.Role, error) {
+	var roles []*domain.Role
+	if err := r.db.Order("name").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (r *authRepository) AssignRole(userID uint64, role *domain.Role) error {
+	return r.db.Model(&domain.User{ID: userID}).Association("Roles").Append(role)
+}
+
+func (r *authRepository) RemoveRole(userID uint64, role *domain.Role) error {
+	return r.db.Model(&domain.User{ID: userID}).Association("Roles").Delete(role)
+}
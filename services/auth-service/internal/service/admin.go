@@ -0,0 +1,210 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/my-username/billion-user-app/pkg/kafkaclient"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/domain"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/mailer"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/repository"
+)
+
+// adminResetTTL gives users who did not ask for the reset more time to
+// notice the email than a self-service reset
+const adminResetTTL = 24 * time.Hour
+
+var ErrOwnAccount = errors.New("administrators cannot deactivate their own account")
+
+// AdminActor is the administrator performing an action, recorded in the
+// audit log together with the request's origin
+type AdminActor struct {
+	UserID   uint64
+	Username string
+	Client   ClientInfo
+}
+
+// UserSearch filters the accounts returned by SearchUsers
+type UserSearch struct {
+	Query  string // Matched against email and username
+	Active *bool  // Nil for active and inactive accounts
+	Offset int
+	Limit  int
+}
+
+// SearchUsers returns a page of accounts matching search and the total
+// number of matches
+func (s *authService) SearchUsers(search UserSearch) ([]*domain.User, int64, error) {
+	return s.repo.SearchUsers(search.Query, search.Active, search.Offset, search.Limit)
+}
+
+// SetUserActive deactivates or reactivates an account. Deactivating also
+// ends every session and revokes the user's API keys once the change is
+// recorded, so the account is locked out right away rather than when its
+// access tokens expire.
+func (s *authService) SetUserActive(actor AdminActor, userID uint64, active bool, reason string) (*domain.User, error) {
+	if !active && actor.UserID == userID {
+		return nil, ErrOwnAccount
+	}
+
+	user, err := s.repo.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.IsActive == active {
+		return user, nil
+	}
+
+	action := domain.AuditActionUserActivated
+	if !active {
+		action = domain.AuditActionUserDeactivated
+	}
+
+	user.IsActive = active
+	err = s.recordAdminAction(actor, action, user.ID, reason, func(tx repository.AuthRepository) error {
+		return tx.UpdateUserFields(user, "IsActive")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !active {
+		if err := s.revokeAllCredentials(user.ID); err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+// RevokeUserTokens logs the user out everywhere: sessions, refresh and
+// access tokens and API keys are all revoked
+func (s *authService) RevokeUserTokens(actor AdminActor, userID uint64, reason string) error {
+	user, err := s.repo.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.recordAdminAction(actor, domain.AuditActionTokensRevoked, user.ID, reason, nil); err != nil {
+		return err
+	}
+
+	return s.revokeAllCredentials(user.ID)
+}
+
+// ResetUserCredentials is for accounts that may be compromised. The password
+// is replaced with a random one nobody knows, MFA is turned off if resetMFA
+// is set, every credential is revoked and the user is emailed a link to
+// choose a new password.
+func (s *authService) ResetUserCredentials(actor AdminActor, userID uint64, resetMFA bool, reason string) error {
+	user, err := s.repo.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := s.hasher.Hash(generateSecureToken(32))
+	if err != nil {
+		return err
+	}
+	user.Password = hashedPassword
+
+	if resetMFA {
+		user.MFAEnabled = false
+		user.MFASecret = ""
+		user.MFALastStep = 0
+	}
+
+	err = s.recordAdminAction(actor, domain.AuditActionCredentialsReset, user.ID, reason, func(tx repository.AuthRepository) error {
+		fields := []string{"Password"}
+		if resetMFA {
+			fields = append(fields, "MFAEnabled", "MFASecret", "MFALastStep")
+		}
+		if err := tx.UpdateUserFields(user, fields...); err != nil {
+			return err
+		}
+		if resetMFA {
+			if err := tx.DeleteRecoveryCodes(user.ID); err != nil {
+				return err
+			}
+		}
+
+		// Links sent before the reset may have gone to whoever took over the
+		// account
+		if err := tx.InvalidateOneTimeTokens(user.ID, domain.TokenPurposePasswordReset); err != nil {
+			return err
+		}
+		return tx.InvalidateOneTimeTokens(user.ID, domain.TokenPurposeMagicLink)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.revokeAllCredentials(user.ID); err != nil {
+		return err
+	}
+
+	// Deactivated users are not told; they can ask for a reset once the
+	// account is reactivated
+	if !user.IsActive {
+		return nil
+	}
+
+	token, err := s.issueOneTimeToken(user.ID, domain.TokenPurposePasswordReset, adminResetTTL)
+	if err != nil {
+		return err
+	}
+
+	return s.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Your password has been reset",
+		Body: fmt.Sprintf("Hi %s,\n\nAn administrator has reset the password for your account "+
+			"and logged you out on all devices. Open the link below within the next 24 hours "+
+			"to choose a new password:\n\n%s\n",
+			user.Username, s.link("/reset-password", token)),
+	})
+}
+
+// revokeAllCredentials ends every session and revokes all access tokens and
+// API keys of the user
+func (s *authService) revokeAllCredentials(userID uint64) error {
+	if err := s.RevokeAllSessions(userID); err != nil {
+		return err
+	}
+	return s.repo.RevokeAllAPIKeys(userID)
+}
+
+// recordAdminAction appends the action to the audit log and also publishes it
+// on the "auth.admin" topic. Unlike authentication events it must be recorded:
+// change, if any, runs in the same transaction, so an administrator's action
+// is refused if it cannot be. Work outside the database, such as revoking
+// tokens, is done once this returns.
+func (s *authService) recordAdminAction(actor AdminActor, action string, userID uint64, reason string, change func(tx repository.AuthRepository) error) error {
+	entry := &domain.AuditEntry{
+		ActorID:      actor.UserID,
+		Action:       action,
+		TargetUserID: userID,
+		Outcome:      domain.AuditOutcomeSuccess,
+		Reason:       reason,
+		IP:           actor.Client.IP,
+		UserAgent:    actor.Client.UserAgent,
+	}
+	return s.repo.Transaction(func(tx repository.AuthRepository) error {
+		if change != nil {
+			if err := change(tx); err != nil {
+				return err
+			}
+		}
+		if err := appendAudit(tx, entry); err != nil {
+			return err
+		}
+		event := kafkaclient.AuthAdminEvent{
+			UserID:     userID,
+			ActorID:    actor.UserID,
+			Action:     action,
+			Reason:     reason,
+			OccurredAt: entry.CreatedAt.Format(time.RFC3339),
+		}
+		return tx.EnqueueEvent("auth.admin", userKey(userID), event)
+	})
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/my-username/billion-user-app/pkg/jwtutils"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/domain"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/repository"
+)
+
+// Number of characters after jwtutils.APIKeyPrefix kept in clear text so users
+// can tell their keys apart
+const apiKeyVisibleChars = 8
+
+var (
+	ErrAPIKeyNotFound = errors.New("API key not found")
+	ErrInvalidAPIKey  = errors.New("invalid, expired or revoked API key")
+	ErrInvalidScope   = errors.New("invalid scope")
+	ErrScopedToken    = errors.New("scoped tokens cannot be used with auth-service")
+)
+
+// CreateAPIKey issues a new API key for the user, restricted to scopes. The
+// returned secret is shown once and cannot be recovered later. A zero
+// lifetime creates a key that never expires.
+func (s *authService) CreateAPIKey(userID uint64, name string, scopes []string, lifetime time.Duration) (*domain.APIKey, string, error) {
+	scopes, err := validateScopes(scopes, jwtutils.Scopes())
+	if err != nil {
+		return nil, "", err
+	}
+
+	secret := jwtutils.APIKeyPrefix + generateSecureToken(32)
+	key := &domain.APIKey{
+		UserID:  userID,
+		Name:    name,
+		Prefix:  secret[:len(jwtutils.APIKeyPrefix)+apiKeyVisibleChars],
+		KeyHash: hashToken(secret),
+		Scopes:  scopes,
+	}
+	if lifetime > 0 {
+		expiresAt := s.now().Add(lifetime)
+		key.ExpiresAt = &expiresAt
+	}
+
+	if err := s.repo.CreateAPIKey(key); err != nil {
+		return nil, "", err
+	}
+	return key, secret, nil
+}
+
+// ListAPIKeys returns the user's API keys that have not been revoked
+func (s *authService) ListAPIKeys(userID uint64) ([]*domain.APIKey, error) {
+	return s.repo.ListAPIKeys(userID)
+}
+
+// RevokeAPIKey disables one of the user's API keys, including the access
+// tokens already exchanged for it
+func (s *authService) RevokeAPIKey(userID, keyID uint64) error {
+	key, err := s.repo.GetAPIKey(keyID)
+	if err != nil {
+		if err == repository.ErrAPIKeyNotFound {
+			return ErrAPIKeyNotFound
+		}
+		return err
+	}
+
+	if key.UserID != userID {
+		return ErrAPIKeyNotFound
+	}
+
+	if err := s.repo.RevokeAPIKey(key.ID); err != nil {
+		return err
+	}
+	s.revokeSessionTokens(apiKeySessionID(key.ID))
+	return nil
+}
+
+// ExchangeAPIKey trades an API key for a short-lived access token carrying the
+// owner's identity and the key's scopes. Services call it on behalf of their
+// clients, so usage is tracked once per token lifetime rather than per
+// request.
+func (s *authService) ExchangeAPIKey(secret string) (string, time.Duration, error) {
+	if !jwtutils.IsAPIKey(secret) {
+		return "", 0, ErrInvalidAPIKey
+	}
+
+	key, err := s.repo.GetAPIKeyByHash(hashToken(secret))
+	if err != nil {
+		if err == repository.ErrAPIKeyNotFound {
+			return "", 0, ErrInvalidAPIKey
+		}
+		return "", 0, err
+	}
+
+	if !key.IsActive(s.now()) {
+		return "", 0, ErrInvalidAPIKey
+	}
+
+	user, err := s.repo.GetUserByID(key.UserID)
+	if err != nil || !user.IsActive {
+		return "", 0, ErrInvalidAPIKey
+	}
+
+	_ = s.repo.TouchAPIKey(key.ID)
+
+	accessToken, err := s.jwtManager.GenerateToken(user.ID, user.Email, user.Username, user.RoleNames(), user.Permissions(),
+		jwtutils.WithSessionID(apiKeySessionID(key.ID)), jwtutils.WithScopes(key.Scopes))
+	if err != nil {
+		return "", 0, err
+	}
+
+	return accessToken, s.jwtManager.TokenDuration(), nil
+}
+
+// apiKeySessionPrefix starts the "sid" of access tokens exchanged for an API
+// key
+const apiKeySessionPrefix = "apikey-"
+
+// apiKeySessionID is the "sid" of access tokens exchanged for an API key, so
+// they can be revoked together with the key
+func apiKeySessionID(keyID uint64) string {
+	return apiKeySessionPrefix + strconv.FormatUint(keyID, 10)
+}
+
+// validateScopes rejects scopes not in known and removes duplicates. At
+// least one scope is required, since unscoped tokens are unrestricted.
+func validateScopes(scopes, known []string) ([]string, error) {
+	isKnown := make(map[string]bool, len(known))
+	for _, scope := range known {
+		isKnown[scope] = true
+	}
+
+	seen := make(map[string]bool)
+	var valid []string
+	for _, scope := range scopes {
+		if !isKnown[scope] {
+			return nil, ErrInvalidScope
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			valid = append(valid, scope)
+		}
+	}
+
+	if len(valid) == 0 {
+		return nil, ErrInvalidScope
+	}
+	return valid, nil
+}
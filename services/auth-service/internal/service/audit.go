@@ -0,0 +1,107 @@
+package service
+
+import (
+	"time"
+
+	"github.com/my-username/billion-user-app/pkg/kafkaclient"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/domain"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/repository"
+)
+
+// reasonMFAChallenge is recorded for logins that passed the first factor and
+// still have to complete MFA
+const reasonMFAChallenge = "MFA challenge issued"
+
+// AuditQuery filters the entries returned by ListAuditLog
+type AuditQuery struct {
+	UserID  uint64 // Actor or target; zero for every user
+	Action  string
+	Outcome string
+	IP      string
+	Since   time.Time
+	Until   time.Time
+	Offset  int
+	Limit   int
+}
+
+// ListAuditLog returns a page of audit entries matching query, newest first,
+// and the total number of matches
+func (s *authService) ListAuditLog(query AuditQuery) ([]*domain.AuditEntry, int64, error) {
+	filter := repository.AuditFilter{
+		UserID:  query.UserID,
+		Action:  query.Action,
+		Outcome: query.Outcome,
+		IP:      query.IP,
+		Since:   query.Since,
+		Until:   query.Until,
+	}
+	return s.repo.ListAuditEntries(filter, query.Offset, query.Limit)
+}
+
+// writeAudit appends entry to the audit log and publishes it on the
+// "auth.audit" topic
+func (s *authService) writeAudit(entry *domain.AuditEntry) error {
+	return s.repo.Transaction(func(tx repository.AuthRepository) error {
+		return appendAudit(tx, entry)
+	})
+}
+
+// appendAudit appends entry to the audit log and enqueues its event in the
+// caller's transaction
+func appendAudit(tx repository.AuthRepository, entry *domain.AuditEntry) error {
+	if err := tx.CreateAuditEntry(entry); err != nil {
+		return err
+	}
+
+	event := kafkaclient.AuthAuditEvent{
+		ID:           entry.ID,
+		ActorID:      entry.ActorID,
+		TargetUserID: entry.TargetUserID,
+		Action:       entry.Action,
+		Outcome:      entry.Outcome,
+		Reason:       entry.Reason,
+		Email:        entry.Email,
+		IP:           entry.IP,
+		UserAgent:    entry.UserAgent,
+		OccurredAt:   entry.CreatedAt.Format(time.RFC3339),
+	}
+	return tx.EnqueueEvent("auth.audit", userKey(entry.TargetUserID), event)
+}
+
+// recordAuthEvent records the outcome of a user's own authentication request.
+// userID is zero when the account is unknown. A failed request is recorded
+// with err as the reason. Best effort: users are not turned away because the
+// audit log is unavailable.
+func (s *authService) recordAuthEvent(action string, userID uint64, email string, client ClientInfo, reason string, err error) {
+	entry := &domain.AuditEntry{
+		ActorID:      userID,
+		Action:       action,
+		TargetUserID: userID,
+		Email:        email,
+		Outcome:      domain.AuditOutcomeSuccess,
+		Reason:       reason,
+		IP:           client.IP,
+		UserAgent:    client.UserAgent,
+	}
+	if err != nil {
+		entry.Outcome = domain.AuditOutcomeFailure
+		entry.Reason = err.Error()
+	}
+	_ = s.writeAudit(entry)
+}
+
+// recordLogin records a login attempt by user, or by email if the account
+// is unknown. Logins stopped at the MFA challenge are recorded as successful
+// first steps; VerifyMFA records the second.
+func (s *authService) recordLogin(action string, user *domain.User, email string, client ClientInfo, result *LoginResult, err error) {
+	var userID uint64
+	if user != nil {
+		userID, email = user.ID, user.Email
+	}
+
+	var reason string
+	if err == nil && result != nil && result.MFARequired {
+		reason = reasonMFAChallenge
+	}
+	s.recordAuthEvent(action, userID, email, client, reason, err)
+}
@@ -0,0 +1,266 @@
+package service
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/my-username/billion-user-app/pkg/jwtutils"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/domain"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/repository"
+)
+
+var (
+	ErrClientNotFound      = errors.New("OAuth client not found")
+	ErrInvalidClient       = errors.New("invalid client credentials")
+	ErrInvalidAudience     = errors.New("invalid audience")
+	ErrInvalidGrantType    = errors.New("invalid grant type")
+	ErrInvalidRedirectURI  = errors.New("invalid redirect URI")
+	ErrUnauthorizedClient  = errors.New("client is not allowed to use this grant")
+	ErrInvalidClientConfig = errors.New("public clients cannot use the client-credentials grant")
+)
+
+// ClientRegistration describes an OAuth2 client to register
+type ClientRegistration struct {
+	Name         string
+	Public       bool     // No secret; must use the authorization-code flow with PKCE
+	Scopes       []string // Scopes the client may request
+	Audiences    []string // Services it may call, required for client_credentials
+	GrantTypes   []string // Defaults to client_credentials
+	RedirectURIs []string // Required for authorization_code
+}
+
+// ClientToken is an access token issued with the client-credentials grant
+type ClientToken struct {
+	AccessToken string
+	ExpiresIn   time.Duration
+	Scopes      []string
+	Audience    []string
+}
+
+// RegisterClient registers an OAuth2 client. The returned secret is shown
+// once and cannot be recovered later; it is empty for public clients.
+func (s *authService) RegisterClient(reg ClientRegistration) (*domain.OAuthClient, string, error) {
+	grants, err := validateGrantTypes(reg.GrantTypes, reg.Public)
+	if err != nil {
+		return nil, "", err
+	}
+	usesCode := contains(grants, domain.GrantAuthorizationCode)
+
+	known := jwtutils.Scopes()
+	if usesCode {
+		known = append(known, oidcScopes...)
+	}
+	scopes, err := validateScopes(reg.Scopes, known)
+	if err != nil {
+		return nil, "", err
+	}
+
+	audiences := uniqueNonEmpty(reg.Audiences)
+	if contains(grants, domain.GrantClientCredentials) && len(audiences) == 0 {
+		return nil, "", ErrInvalidAudience
+	}
+
+	redirectURIs := uniqueNonEmpty(reg.RedirectURIs)
+	if usesCode && len(redirectURIs) == 0 {
+		return nil, "", ErrInvalidRedirectURI
+	}
+	for _, uri := range redirectURIs {
+		if !validRedirectURI(uri) {
+			return nil, "", ErrInvalidRedirectURI
+		}
+	}
+
+	client := &domain.OAuthClient{
+		ClientID:     generateSecureToken(12),
+		Name:         reg.Name,
+		Public:       reg.Public,
+		Scopes:       scopes,
+		Audiences:    audiences,
+		GrantTypes:   grants,
+		RedirectURIs: redirectURIs,
+	}
+
+	var secret string
+	if !reg.Public {
+		secret = generateSecureToken(32)
+		client.SecretHash = hashToken(secret)
+	}
+
+	if err := s.repo.CreateOAuthClient(client); err != nil {
+		return nil, "", err
+	}
+	return client, secret, nil
+}
+
+// ListClients returns every active OAuth2 client
+func (s *authService) ListClients() ([]*domain.OAuthClient, error) {
+	return s.repo.ListOAuthClients()
+}
+
+// RevokeClient disables a client and the tokens already issued to it
+func (s *authService) RevokeClient(clientID string) error {
+	if err := s.repo.RevokeOAuthClient(clientID); err != nil {
+		if err == repository.ErrClientNotFound {
+			return ErrClientNotFound
+		}
+		return err
+	}
+	s.revokeSessionTokens(clientSessionID(clientID))
+	return nil
+}
+
+// IssueClientToken implements the client-credentials grant. Empty scopes or
+// audience default to everything the client is registered for; anything
+// beyond that is rejected.
+func (s *authService) IssueClientToken(clientID, secret string, scopes, audience []string) (*ClientToken, error) {
+	client, err := s.authenticateClient(clientID, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	if !client.AllowsGrant(domain.GrantClientCredentials) {
+		return nil, ErrUnauthorizedClient
+	}
+
+	scopes, err = narrow(client.Scopes, scopes)
+	if err != nil {
+		return nil, ErrInvalidScope
+	}
+
+	audience, err = narrow(client.Audiences, audience)
+	if err != nil {
+		return nil, ErrInvalidAudience
+	}
+
+	accessToken, err := s.jwtManager.GenerateServiceToken(client.ClientID, scopes, audience,
+		jwtutils.WithSessionID(clientSessionID(client.ClientID)))
+	if err != nil {
+		return nil, err
+	}
+
+	return &ClientToken{
+		AccessToken: accessToken,
+		ExpiresIn:   s.jwtManager.TokenDuration(),
+		Scopes:      scopes,
+		Audience:    audience,
+	}, nil
+}
+
+// authenticateClient checks a confidential client's secret. Public clients
+// have no secret and are only identified; the grants open to them must
+// prove possession in another way (PKCE).
+func (s *authService) authenticateClient(clientID, secret string) (*domain.OAuthClient, error) {
+	client, err := s.repo.GetOAuthClient(clientID)
+	if err != nil {
+		if err == repository.ErrClientNotFound {
+			return nil, ErrInvalidClient
+		}
+		return nil, err
+	}
+
+	if client.RevokedAt != nil {
+		return nil, ErrInvalidClient
+	}
+
+	if client.Public {
+		return client, nil
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return nil, ErrInvalidClient
+	}
+	return client, nil
+}
+
+// clientSessionPrefix starts the "sid" of tokens issued to a client
+const clientSessionPrefix = "client-"
+
+// clientSessionID is the "sid" of tokens issued to a client, so they can be
+// revoked together with the client
+func clientSessionID(clientID string) string {
+	return clientSessionPrefix + clientID
+}
+
+// narrow returns requested if it is a subset of allowed, or all of allowed if
+// nothing was requested
+func narrow(allowed, requested []string) ([]string, error) {
+	requested = uniqueNonEmpty(requested)
+	if len(requested) == 0 {
+		return allowed, nil
+	}
+
+	permitted := make(map[string]bool, len(allowed))
+	for _, a := range allowed {
+		permitted[a] = true
+	}
+	for _, r := range requested {
+		if !permitted[r] {
+			return nil, errors.New("not permitted: " + r)
+		}
+	}
+	return requested, nil
+}
+
+// validateGrantTypes defaults to client_credentials and rejects unknown or
+// inconsistent grant types
+func validateGrantTypes(grants []string, public bool) ([]string, error) {
+	grants = uniqueNonEmpty(grants)
+	if len(grants) == 0 {
+		grants = []string{domain.GrantClientCredentials}
+	}
+
+	for _, g := range grants {
+		switch g {
+		case domain.GrantClientCredentials:
+			if public {
+				return nil, ErrInvalidClientConfig
+			}
+		case domain.GrantAuthorizationCode:
+		case domain.GrantRefreshToken:
+			if !contains(grants, domain.GrantAuthorizationCode) {
+				return nil, ErrInvalidGrantType
+			}
+		default:
+			return nil, ErrInvalidGrantType
+		}
+	}
+	return grants, nil
+}
+
+// validRedirectURI accepts absolute URIs without a fragment (RFC 6749
+// section 3.1.2), including custom schemes used by native apps
+func validRedirectURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme == "" || u.Fragment != "" {
+		return false
+	}
+	if u.Scheme == "http" || u.Scheme == "https" {
+		return u.Host != ""
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func uniqueNonEmpty(values []string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v != "" && !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/my-username/billion-user-app/services/auth-service/internal/domain"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/mailer"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/repository"
+)
+
+const (
+	emailVerificationTTL = 24 * time.Hour
+	passwordResetTTL     = time.Hour
+)
+
+var (
+	ErrInvalidToken     = errors.New("invalid or expired token")
+	ErrEmailNotVerified = errors.New("email address is not verified")
+)
+
+// SendVerificationEmail (re)sends the verification link. It succeeds silently
+// for unknown or already verified addresses so it cannot be used to probe
+// which emails are registered.
+func (s *authService) SendVerificationEmail(email string) error {
+	user, err := s.repo.GetUserByEmail(email)
+	if err != nil {
+		if err == repository.ErrUserNotFound {
+			return nil
+		}
+		return err
+	}
+
+	if user.EmailVerified {
+		return nil
+	}
+	return s.sendVerificationEmail(user)
+}
+
+// VerifyEmail marks the address behind a verification token as verified
+func (s *authService) VerifyEmail(token string) error {
+	ott, err := s.consumeOneTimeToken(domain.TokenPurposeEmailVerification, token)
+	if err != nil {
+		if err == repository.ErrOneTimeTokenNotFound {
+			return ErrInvalidToken
+		}
+		return err
+	}
+
+	user, err := s.repo.GetUserByID(ott.UserID)
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	return s.markEmailVerified(user)
+}
+
+// RequestPasswordReset emails a reset link. Like SendVerificationEmail it
+// does not reveal whether the address exists.
+func (s *authService) RequestPasswordReset(email string) error {
+	user, err := s.repo.GetUserByEmail(email)
+	if err != nil {
+		if err == repository.ErrUserNotFound {
+			return nil
+		}
+		return err
+	}
+
+	if !user.IsActive {
+		return nil
+	}
+
+	token, err := s.issueOneTimeToken(user.ID, domain.TokenPurposePasswordReset, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	return s.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password for your account. "+
+			"If it was you, open the link below within the next hour:\n\n%s\n\n"+
+			"If you did not ask for this, you can ignore this email.\n",
+			user.Username, s.link("/reset-password", token)),
+	})
+}
+
+// ResetPassword sets a new password using a reset token. Every session of
+// the user is revoked, since the old password may have been compromised.
+// A password rejected by the policy leaves the token usable, so the user can
+// pick another one.
+func (s *authService) ResetPassword(token, newPassword string, client ClientInfo) (err error) {
+	var user *domain.User
+	defer func() { s.recordLogin(domain.AuditActionPasswordReset, user, "", client, nil, err) }()
+
+	ott, err := s.findOneTimeToken(domain.TokenPurposePasswordReset, token)
+	if err != nil {
+		if err == repository.ErrOneTimeTokenNotFound {
+			return ErrInvalidToken
+		}
+		return err
+	}
+
+	user, err = s.repo.GetUserByID(ott.UserID)
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	if err := s.checkNewPassword(user, newPassword); err != nil {
+		return err
+	}
+
+	if err := s.repo.UseOneTimeToken(ott.ID); err != nil {
+		if err == repository.ErrOneTimeTokenNotFound {
+			return ErrInvalidToken
+		}
+		return err
+	}
+
+	if err := s.replacePassword(user, newPassword); err != nil {
+		return err
+	}
+
+	// Receiving the reset email proves ownership of the address
+	if !user.EmailVerified {
+		now := s.now()
+		user.EmailVerified = true
+		user.EmailVerifiedAt = &now
+	}
+
+	if err := s.repo.UpdateUser(user); err != nil {
+		return err
+	}
+
+	_ = s.repo.InvalidateOneTimeTokens(user.ID, domain.TokenPurposePasswordReset)
+
+	// A fresh password is a good reason to lift a brute-force lockout
+	if s.loginGuard != nil {
+		_ = s.loginGuard.Unlock(user.Email)
+	}
+
+	return s.RevokeAllSessions(user.ID)
+}
+
+func (s *authService) sendVerificationEmail(user *domain.User) error {
+	token, err := s.issueOneTimeToken(user.ID, domain.TokenPurposeEmailVerification, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	return s.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening the link below:\n\n%s\n\n"+
+			"The link expires in 24 hours.\n",
+			user.Username, s.link("/verify-email", token)),
+	})
+}
+
+func (s *authService) markEmailVerified(user *domain.User) error {
+	if user.EmailVerified {
+		return nil
+	}
+
+	now := s.now()
+	user.EmailVerified = true
+	user.EmailVerifiedAt = &now
+	if err := s.repo.UpdateUser(user); err != nil {
+		return err
+	}
+
+	return s.repo.InvalidateOneTimeTokens(user.ID, domain.TokenPurposeEmailVerification)
+}
+
+func (s *authService) sendMail(msg mailer.Message) error {
+	if s.mailer == nil {
+		return nil
+	}
+	return s.mailer.Send(msg)
+}
+
+// link builds a frontend URL carrying a token
+func (s *authService) link(path, token string) string {
+	return s.appBaseURL + path + "?token=" + url.QueryEscape(token)
+}
@@ -0,0 +1,362 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/my-username/billion-user-app/pkg/jwtutils"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/domain"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/federation"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/repository"
+)
+
+var (
+	ErrUnknownProvider          = errors.New("unknown identity provider")
+	ErrIdentityNotFound         = errors.New("external identity not found")
+	ErrIdentityLinked           = errors.New("external identity is linked to another account")
+	ErrExternalEmailNotVerified = errors.New("identity provider did not verify the email address")
+	ErrAccountExists            = errors.New("an unverified account with this email already exists")
+)
+
+const (
+	externalLoginTTL      = 10 * time.Minute // From redirect to callback
+	externalHandoffTTL    = time.Minute      // From callback to token exchange
+	externalLinkTokenTTL  = 5 * time.Minute
+	externalLoginRedirect = "/login/external"
+)
+
+var usernameDisallowed = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// ExternalCallback holds the parameters a provider sends back to the
+// callback URL
+type ExternalCallback struct {
+	State string
+	Code  string
+	Error string
+}
+
+// externalLoginState is kept in a signed cookie between the redirect to the
+// provider and the callback. Binding it to the browser stops an attacker
+// from completing their own login in a victim's browser.
+type externalLoginState struct {
+	Provider   string `json:"p"`
+	State      string `json:"s"`
+	Nonce      string `json:"n"`
+	Verifier   string `json:"v"`
+	LinkUserID uint64 `json:"u,omitempty"` // Set when linking to a signed-in user
+	ExpiresAt  int64  `json:"e"`
+}
+
+// ExternalProviders returns the names of the configured providers
+func (s *authService) ExternalProviders() []string {
+	names := make([]string, 0, len(s.providers))
+	for name := range s.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BeginExternalLogin starts a login with an external provider. It returns
+// the provider URL to redirect to and the state to store in a cookie for
+// CompleteExternalLogin. With a linkToken from CreateIdentityLinkToken the
+// identity is linked to that token's user instead.
+func (s *authService) BeginExternalLogin(providerName, linkToken string) (string, string, error) {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return "", "", ErrUnknownProvider
+	}
+
+	st := externalLoginState{
+		Provider:  providerName,
+		State:     generateSecureToken(16),
+		Nonce:     generateSecureToken(16),
+		Verifier:  generateSecureToken(32),
+		ExpiresAt: s.now().Add(externalLoginTTL).Unix(),
+	}
+
+	if linkToken != "" {
+		ott, err := s.consumeOneTimeToken(domain.TokenPurposeExternalLink, linkToken)
+		if err != nil {
+			return "", "", ErrInvalidToken
+		}
+		st.LinkUserID = ott.UserID
+	}
+
+	challenge := sha256.Sum256([]byte(st.Verifier))
+	authURL, err := provider.AuthCodeURL(st.State, st.Nonce, base64.RawURLEncoding.EncodeToString(challenge[:]))
+	if err != nil {
+		return "", "", err
+	}
+
+	sealed, err := s.sealExternalLoginState(st)
+	if err != nil {
+		return "", "", err
+	}
+	return authURL, sealed, nil
+}
+
+// CompleteExternalLogin handles the provider's callback. It returns the
+// frontend URL to send the browser to: with a single-use token for
+// ExchangeExternalLogin after a login, "linked" after linking an identity, or
+// an error code.
+func (s *authService) CompleteExternalLogin(providerName, sealedState string, callback ExternalCallback) string {
+	redirect := func(params url.Values) string {
+		return s.appBaseURL + externalLoginRedirect + "?" + params.Encode()
+	}
+	fail := func(code string) string {
+		return redirect(url.Values{"error": {code}})
+	}
+
+	st, ok := s.openExternalLoginState(sealedState)
+	if !ok || st.Provider != providerName || subtle.ConstantTimeCompare([]byte(st.State), []byte(callback.State)) != 1 {
+		return fail("invalid_state")
+	}
+
+	if callback.Error != "" {
+		return fail("access_denied")
+	}
+
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return fail("invalid_state")
+	}
+
+	identity, err := provider.Exchange(callback.Code, st.Verifier, st.Nonce)
+	if err != nil {
+		return fail("provider_error")
+	}
+
+	user, err := s.resolveExternalIdentity(providerName, identity, st.LinkUserID)
+	switch err {
+	case nil:
+	case ErrIdentityLinked:
+		return fail("identity_linked")
+	case ErrExternalEmailNotVerified:
+		return fail("email_not_verified")
+	case ErrAccountExists:
+		return fail("account_exists")
+	default:
+		return fail("server_error")
+	}
+
+	if st.LinkUserID != 0 {
+		return redirect(url.Values{"linked": {providerName}})
+	}
+
+	if !user.IsActive {
+		return fail("account_inactive")
+	}
+
+	token, err := s.issueOneTimeToken(user.ID, domain.TokenPurposeExternalLogin, externalHandoffTTL)
+	if err != nil {
+		return fail("server_error")
+	}
+	return redirect(url.Values{"token": {token}})
+}
+
+// ExchangeExternalLogin trades the token from a completed external login for
+// the same result as a password login: tokens, or an MFA challenge
+func (s *authService) ExchangeExternalLogin(token string, client ClientInfo) (result *LoginResult, err error) {
+	var user *domain.User
+	defer func() { s.recordLogin(domain.AuditActionExternalLogin, user, "", client, result, err) }()
+
+	ott, err := s.consumeOneTimeToken(domain.TokenPurposeExternalLogin, token)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	user, err = s.repo.GetUserByID(ott.UserID)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if !user.IsActive {
+		return nil, ErrUserInactive
+	}
+
+	return s.completeLogin(user, client)
+}
+
+// CreateIdentityLinkToken returns a single-use token that lets the signed-in
+// user start BeginExternalLogin in link mode from a plain browser redirect
+func (s *authService) CreateIdentityLinkToken(userID uint64) (string, error) {
+	return s.issueOneTimeToken(userID, domain.TokenPurposeExternalLink, externalLinkTokenTTL)
+}
+
+// ListIdentities returns the external identities linked to the user
+func (s *authService) ListIdentities(userID uint64) ([]*domain.ExternalIdentity, error) {
+	return s.repo.ListExternalIdentities(userID)
+}
+
+// UnlinkIdentity removes one of the user's external identities. Accounts
+// created through a provider can still sign in after setting a password with
+// the reset flow.
+func (s *authService) UnlinkIdentity(userID, identityID uint64) error {
+	if err := s.repo.DeleteExternalIdentity(userID, identityID); err != nil {
+		if err == repository.ErrIdentityNotFound {
+			return ErrIdentityNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// resolveExternalIdentity finds or creates the user behind an identity. An
+// identity seen before signs in its user. A new one is linked to the user in
+// link mode, or else to the account with the same email if both the provider
+// and that account verified it; otherwise a new account is created. Accounts
+// whose email was never verified are not linked automatically: whoever
+// registered them may not own the address.
+func (s *authService) resolveExternalIdentity(provider string, identity *federation.Identity, linkUserID uint64) (*domain.User, error) {
+	existing, err := s.repo.GetExternalIdentity(provider, identity.Subject)
+	if err == nil {
+		if linkUserID != 0 && existing.UserID != linkUserID {
+			return nil, ErrIdentityLinked
+		}
+		_ = s.repo.TouchExternalIdentity(existing.ID, identity.Email)
+		return s.repo.GetUserByID(existing.UserID)
+	}
+	if err != repository.ErrIdentityNotFound {
+		return nil, err
+	}
+
+	var user *domain.User
+	if linkUserID != 0 {
+		user, err = s.repo.GetUserByID(linkUserID)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if identity.Email == "" || !identity.EmailVerified {
+			return nil, ErrExternalEmailNotVerified
+		}
+
+		user, err = s.repo.GetUserByEmail(identity.Email)
+		switch {
+		case err == nil:
+			if !user.EmailVerified {
+				return nil, ErrAccountExists
+			}
+		case err == repository.ErrUserNotFound:
+			user, err = s.createExternalUser(identity)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, err
+		}
+	}
+
+	err = s.repo.CreateExternalIdentity(&domain.ExternalIdentity{
+		UserID:      user.ID,
+		Provider:    provider,
+		Subject:     identity.Subject,
+		Email:       identity.Email,
+		LastLoginAt: s.now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// createExternalUser creates an account for a new external identity. It gets
+// a random password nobody knows, and the provider's verified email.
+func (s *authService) createExternalUser(identity *federation.Identity) (*domain.User, error) {
+	username, err := s.availableUsername(identity)
+	if err != nil {
+		return nil, err
+	}
+
+	hashedPassword, err := s.hasher.Hash(generateSecureToken(32))
+	if err != nil {
+		return nil, err
+	}
+
+	defaultRole, err := s.repo.GetRoleByName(jwtutils.RoleUser)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	user := &domain.User{
+		Email:           identity.Email,
+		Username:        username,
+		Password:        hashedPassword,
+		IsActive:        true,
+		Roles:           []domain.Role{*defaultRole},
+		EmailVerified:   true,
+		EmailVerifiedAt: &now,
+	}
+
+	if err := s.createUser(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// availableUsername derives a username from the provider's preferred
+// username or the email's local part, adding a random suffix if it is taken
+func (s *authService) availableUsername(identity *federation.Identity) (string, error) {
+	base := identity.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(identity.Email, "@")
+	}
+	base = usernameDisallowed.ReplaceAllString(base, "")
+	if len(base) > 40 {
+		base = base[:40]
+	}
+	for len(base) < 3 {
+		base += "_"
+	}
+
+	candidate := base
+	for i := 0; i < 5; i++ {
+		_, err := s.repo.GetUserByUsername(candidate)
+		if err == repository.ErrUserNotFound {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		candidate = base + "_" + generateSecureToken(3)
+	}
+	return "", repository.ErrUserAlreadyExists
+}
+
+func (s *authService) sealExternalLoginState(st externalLoginState) (string, error) {
+	payload, err := json.Marshal(st)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + s.signOneTimeToken(encoded), nil
+}
+
+func (s *authService) openExternalLoginState(sealed string) (*externalLoginState, bool) {
+	encoded, signature, ok := strings.Cut(sealed, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(s.signOneTimeToken(encoded))) {
+		return nil, false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, false
+	}
+
+	var st externalLoginState
+	if err := json.Unmarshal(payload, &st); err != nil || s.now().Unix() >= st.ExpiresAt {
+		return nil, false
+	}
+	return &st, true
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/my-username/billion-user-app/services/auth-service/internal/domain"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/federation"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/passwords"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/repository"
+)
+
+// identityRepository keeps the users and external identities
+// resolveExternalIdentity works with in memory. Other methods are not
+// implemented and panic.
+type identityRepository struct {
+	repository.AuthRepository
+
+	users      []*domain.User
+	identities []*domain.ExternalIdentity
+	events     []string
+}
+
+func (r *identityRepository) GetUserByID(id uint64) (*domain.User, error) {
+	for _, user := range r.users {
+		if user.ID == id {
+			return user, nil
+		}
+	}
+	return nil, repository.ErrUserNotFound
+}
+
+func (r *identityRepository) GetUserByEmail(email string) (*domain.User, error) {
+	for _, user := range r.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return nil, repository.ErrUserNotFound
+}
+
+func (r *identityRepository) GetUserByUsername(username string) (*domain.User, error) {
+	for _, user := range r.users {
+		if user.Username == username {
+			return user, nil
+		}
+	}
+	return nil, repository.ErrUserNotFound
+}
+
+func (r *identityRepository) GetRoleByName(name string) (*domain.Role, error) {
+	return &domain.Role{ID: 1, Name: name}, nil
+}
+
+func (r *identityRepository) CreateUser(user *domain.User) error {
+	user.ID = uint64(len(r.users) + 1)
+	user.CreatedAt = time.Now()
+	r.users = append(r.users, user)
+	return nil
+}
+
+func (r *identityRepository) EnqueueEvent(topic, key string, event interface{}) error {
+	r.events = append(r.events, topic)
+	return nil
+}
+
+func (r *identityRepository) Transaction(fn func(tx repository.AuthRepository) error) error {
+	return fn(r)
+}
+
+func (r *identityRepository) GetExternalIdentity(provider, subject string) (*domain.ExternalIdentity, error) {
+	for _, identity := range r.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return identity, nil
+		}
+	}
+	return nil, repository.ErrIdentityNotFound
+}
+
+func (r *identityRepository) CreateExternalIdentity(identity *domain.ExternalIdentity) error {
+	identity.ID = uint64(len(r.identities) + 1)
+	r.identities = append(r.identities, identity)
+	return nil
+}
+
+func (r *identityRepository) TouchExternalIdentity(id uint64, email string) error {
+	return nil
+}
+
+func TestResolveExternalIdentity(t *testing.T) {
+	verified := &domain.User{ID: 1, Email: "verified@example.com", Username: "verified", EmailVerified: true}
+	unverified := &domain.User{ID: 2, Email: "unverified@example.com", Username: "unverified"}
+	linked := &domain.ExternalIdentity{ID: 1, UserID: verified.ID, Provider: "corp", Subject: "linked"}
+
+	tests := []struct {
+		name       string
+		identity   federation.Identity
+		linkUserID uint64
+		wantUser   uint64 // 0 for a new account
+		wantErr    error
+		wantLinked bool
+	}{
+		{
+			name:     "known identity signs in its user",
+			identity: federation.Identity{Subject: "linked", Email: "someone@else.example"},
+			wantUser: verified.ID,
+		},
+		{
+			name:       "known identity cannot be linked to another user",
+			identity:   federation.Identity{Subject: "linked"},
+			linkUserID: unverified.ID,
+			wantErr:    ErrIdentityLinked,
+		},
+		{
+			name:       "link mode links to the signed-in user",
+			identity:   federation.Identity{Subject: "new", Email: "other@example.com"},
+			linkUserID: unverified.ID,
+			wantUser:   unverified.ID,
+			wantLinked: true,
+		},
+		{
+			name:       "verified email links to the verified account",
+			identity:   federation.Identity{Subject: "new", Email: "verified@example.com", EmailVerified: true},
+			wantUser:   verified.ID,
+			wantLinked: true,
+		},
+		{
+			name:     "email the provider did not verify is refused",
+			identity: federation.Identity{Subject: "new", Email: "verified@example.com"},
+			wantErr:  ErrExternalEmailNotVerified,
+		},
+		{
+			name:     "missing email is refused",
+			identity: federation.Identity{Subject: "new", EmailVerified: true},
+			wantErr:  ErrExternalEmailNotVerified,
+		},
+		{
+			name:     "account whose email was never verified is not taken over",
+			identity: federation.Identity{Subject: "new", Email: "unverified@example.com", EmailVerified: true},
+			wantErr:  ErrAccountExists,
+		},
+		{
+			name:       "unknown verified email creates an account",
+			identity:   federation.Identity{Subject: "new", Email: "new@example.com", EmailVerified: true, PreferredUsername: "verified"},
+			wantLinked: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifiedCopy, unverifiedCopy, linkedCopy := *verified, *unverified, *linked
+			repo := &identityRepository{
+				users:      []*domain.User{&verifiedCopy, &unverifiedCopy},
+				identities: []*domain.ExternalIdentity{&linkedCopy},
+			}
+			s := &authService{repo: repo, hasher: passwords.NewHasher(passwords.Bcrypt{Cost: 4}), now: time.Now}
+
+			user, err := s.resolveExternalIdentity("corp", &tt.identity, tt.linkUserID)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("resolveExternalIdentity() error = %v, want %v", err, tt.wantErr)
+				}
+				if len(repo.identities) != 1 {
+					t.Errorf("identity linked despite the error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveExternalIdentity: %v", err)
+			}
+
+			if tt.wantUser != 0 && user.ID != tt.wantUser {
+				t.Errorf("user = %d, want %d", user.ID, tt.wantUser)
+			}
+			if tt.wantUser == 0 {
+				if user.ID <= unverified.ID || !user.EmailVerified || user.Email != tt.identity.Email {
+					t.Errorf("new user = %+v, want a new verified account for %s", user, tt.identity.Email)
+				}
+				// The preferred username is taken, so a suffix is added
+				if user.Username == "verified" || len(repo.events) != 1 {
+					t.Errorf("new user %q, events %v", user.Username, repo.events)
+				}
+			}
+
+			if got := len(repo.identities) == 2; got != tt.wantLinked {
+				t.Fatalf("identity linked = %v, want %v", got, tt.wantLinked)
+			}
+			if tt.wantLinked {
+				identity := repo.identities[1]
+				if identity.UserID != user.ID || identity.Provider != "corp" || identity.Subject != tt.identity.Subject {
+					t.Errorf("linked identity = %+v, want %s for user %d", identity, tt.identity.Subject, user.ID)
+				}
+			}
+		})
+	}
+}
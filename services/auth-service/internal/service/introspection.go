@@ -0,0 +1,233 @@
+package service
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/my-username/billion-user-app/pkg/jwtutils"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/domain"
+)
+
+// Token type hints (RFC 7009 section 2.1, RFC 7662 section 2.1)
+const (
+	TokenTypeAccessToken  = "access_token"
+	TokenTypeRefreshToken = "refresh_token"
+)
+
+var ErrUnsupportedTokenType = errors.New("access tokens cannot be revoked without a revocation list")
+
+// Introspection describes a token to a resource server (RFC 7662 section
+// 2.2). Only Active is meaningful for inactive tokens.
+type Introspection struct {
+	Active    bool
+	TokenType string
+	Scopes    []string
+	ClientID  string
+	Username  string
+	Subject   string
+	Audience  []string
+	Issuer    string
+	TokenID   string
+	Actor     *jwtutils.Actor // Administrator impersonating the user
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// IntrospectToken tells an authenticated confidential client whether a token
+// is live. Any such client may introspect access tokens, so resource servers
+// and the API gateway can check them; refresh tokens are only described to
+// the client they were issued to. Unknown, expired and revoked tokens are all
+// reported as inactive.
+func (s *authService) IntrospectToken(clientID, secret, token, hint string) (*Introspection, error) {
+	client, err := s.authenticateClient(clientID, secret)
+	if err != nil {
+		return nil, err
+	}
+	if client.Public {
+		return nil, ErrInvalidClient
+	}
+
+	if hint == TokenTypeRefreshToken {
+		if result := s.introspectRefreshToken(client, token); result.Active {
+			return result, nil
+		}
+		return s.introspectAccessToken(token), nil
+	}
+
+	if result := s.introspectAccessToken(token); result.Active {
+		return result, nil
+	}
+	return s.introspectRefreshToken(client, token), nil
+}
+
+// RevokeOAuthToken revokes a token issued to the authenticated client (RFC
+// 7009). Revoking a refresh token ends its whole session, including the
+// access tokens issued from it. Tokens that are invalid, already revoked or
+// belong to another client are ignored, as the RFC asks.
+func (s *authService) RevokeOAuthToken(clientID, secret, token, hint string) error {
+	client, err := s.authenticateClient(clientID, secret)
+	if err != nil {
+		return err
+	}
+
+	if hint == TokenTypeRefreshToken {
+		if revoked, err := s.revokeClientRefreshToken(client, token); revoked || err != nil {
+			return err
+		}
+		return s.revokeClientAccessToken(client, token)
+	}
+
+	if _, err := s.jwtManager.ValidateToken(token); err == nil {
+		return s.revokeClientAccessToken(client, token)
+	}
+	_, err = s.revokeClientRefreshToken(client, token)
+	return err
+}
+
+func (s *authService) introspectAccessToken(token string) *Introspection {
+	claims, err := s.jwtManager.ValidateToken(token)
+	if err != nil || s.checkTokenSource(claims) != nil {
+		return &Introspection{}
+	}
+
+	result := &Introspection{
+		Active:    true,
+		TokenType: TokenTypeAccessToken,
+		Scopes:    claims.Scopes,
+		ClientID:  claims.ClientID,
+		Subject:   claims.Subject,
+		Audience:  claims.Audience,
+		Issuer:    claims.Issuer,
+		TokenID:   claims.ID,
+		Actor:     claims.Actor,
+	}
+	if !claims.IsService() {
+		result.Username = claims.Username
+		result.Subject = strconv.FormatUint(claims.UserID, 10)
+	}
+	if claims.IssuedAt != nil {
+		result.IssuedAt = claims.IssuedAt.Time
+	}
+	if claims.ExpiresAt != nil {
+		result.ExpiresAt = claims.ExpiresAt.Time
+	}
+	return result
+}
+
+func (s *authService) introspectRefreshToken(client *domain.OAuthClient, token string) *Introspection {
+	rt, session, user, ok := s.liveRefreshToken(client, token)
+	if !ok {
+		return &Introspection{}
+	}
+
+	return &Introspection{
+		Active:    true,
+		TokenType: TokenTypeRefreshToken,
+		Scopes:    session.Scopes,
+		ClientID:  session.ClientID,
+		Username:  user.Username,
+		Subject:   strconv.FormatUint(user.ID, 10),
+		Issuer:    s.issuer,
+		IssuedAt:  rt.CreatedAt,
+		ExpiresAt: rt.ExpiresAt,
+	}
+}
+
+// liveRefreshToken returns an unused, unrevoked refresh token of client
+// together with its session and user
+func (s *authService) liveRefreshToken(client *domain.OAuthClient, token string) (*domain.RefreshToken, *domain.Session, *domain.User, bool) {
+	rt, err := s.repo.GetRefreshToken(token)
+	if err != nil || rt.IsRevoked() || rt.IsUsed() || rt.FamilyID == "" {
+		return nil, nil, nil, false
+	}
+
+	session, err := s.repo.GetSession(rt.FamilyID)
+	if err != nil || session.ClientID != client.ClientID || !session.IsActive(s.now()) {
+		return nil, nil, nil, false
+	}
+
+	user, err := s.repo.GetUserByID(rt.UserID)
+	if err != nil || !user.IsActive {
+		return nil, nil, nil, false
+	}
+	return rt, session, user, true
+}
+
+// revokeClientRefreshToken ends the session of a refresh token issued to
+// client and reports whether token was one
+func (s *authService) revokeClientRefreshToken(client *domain.OAuthClient, token string) (bool, error) {
+	rt, err := s.repo.GetRefreshToken(token)
+	if err != nil || rt.FamilyID == "" {
+		return false, nil
+	}
+
+	session, err := s.repo.GetSession(rt.FamilyID)
+	if err != nil || session.ClientID != client.ClientID {
+		return false, nil
+	}
+
+	if err := s.repo.RevokeSession(session.ID); err != nil {
+		return true, err
+	}
+	s.revokeSessionTokens(session.ID)
+	return true, nil
+}
+
+// revokeClientAccessToken adds an access token issued to client to the
+// revocation list
+func (s *authService) revokeClientAccessToken(client *domain.OAuthClient, token string) error {
+	claims, err := s.jwtManager.ValidateToken(token)
+	if err != nil || claims.ClientID != client.ClientID || claims.ID == "" || claims.ExpiresAt == nil {
+		return nil
+	}
+
+	if s.revocations == nil {
+		return ErrUnsupportedTokenType
+	}
+	return s.revocations.RevokeToken(claims.ID, claims.ExpiresAt.Time)
+}
+
+// checkTokenSource rejects access tokens whose origin has since been
+// revoked: the login session, the API key they were exchanged for, or the
+// client they were issued to. The revocation list usually rejects them
+// already; this also covers the window where it is unavailable.
+func (s *authService) checkTokenSource(claims *jwtutils.Claims) error {
+	if clientID, ok := strings.CutPrefix(claims.SessionID, clientSessionPrefix); ok {
+		client, err := s.repo.GetOAuthClient(clientID)
+		if err != nil || client.RevokedAt != nil {
+			return ErrSessionRevoked
+		}
+		return nil
+	}
+
+	if rawID, ok := strings.CutPrefix(claims.SessionID, apiKeySessionPrefix); ok {
+		keyID, err := strconv.ParseUint(rawID, 10, 64)
+		if err != nil {
+			return ErrSessionRevoked
+		}
+		key, err := s.repo.GetAPIKey(keyID)
+		if err != nil || !key.IsActive(s.now()) {
+			return ErrSessionRevoked
+		}
+	} else if err := s.checkSession(claims); err != nil {
+		return err
+	}
+
+	if claims.Actor != nil {
+		actor, err := s.repo.GetUserByID(claims.Actor.UserID)
+		if err != nil || !actor.IsActive {
+			return ErrUserInactive
+		}
+	}
+
+	if claims.IsService() {
+		return nil
+	}
+	user, err := s.repo.GetUserByID(claims.UserID)
+	if err != nil || !user.IsActive {
+		return ErrUserInactive
+	}
+	return nil
+}
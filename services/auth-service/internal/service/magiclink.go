@@ -0,0 +1,90 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/my-username/billion-user-app/services/auth-service/internal/domain"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/mailer"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/repository"
+)
+
+const magicLinkTTL = 15 * time.Minute
+
+// RequestMagicLink emails a single-use login link. Requests are rate limited
+// per address and per client IP before the address is looked up, and unknown
+// or inactive addresses succeed silently, so neither reveals which emails are
+// registered. Rejected requests come back as *lockout.BlockedError.
+func (s *authService) RequestMagicLink(email string, client ClientInfo) error {
+	if s.magicLinkIPLimiter != nil && client.IP != "" {
+		if err := s.magicLinkIPLimiter.Allow(client.IP); err != nil {
+			return err
+		}
+	}
+	if s.magicLinkLimiter != nil {
+		if err := s.magicLinkLimiter.Allow(email); err != nil {
+			return err
+		}
+	}
+
+	user, err := s.repo.GetUserByEmail(email)
+	if err != nil {
+		if err == repository.ErrUserNotFound {
+			return nil
+		}
+		return err
+	}
+
+	if !user.IsActive {
+		return nil
+	}
+
+	// Only the newest link works
+	_ = s.repo.InvalidateOneTimeTokens(user.ID, domain.TokenPurposeMagicLink)
+
+	token, err := s.issueOneTimeToken(user.ID, domain.TokenPurposeMagicLink, magicLinkTTL)
+	if err != nil {
+		return err
+	}
+
+	return s.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Your login link",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen the link below within the next 15 minutes to log in. "+
+			"It can only be used once:\n\n%s\n\n"+
+			"If you did not ask for this, you can ignore this email.\n",
+			user.Username, s.link("/login/magic", token)),
+	})
+}
+
+// LoginWithMagicLink exchanges a magic-link token for the same result as
+// Login: tokens, or an MFA challenge if the user has MFA enabled. The token
+// is consumed on first use, so a replayed link is rejected.
+func (s *authService) LoginWithMagicLink(token string, client ClientInfo) (result *LoginResult, err error) {
+	var user *domain.User
+	defer func() { s.recordLogin(domain.AuditActionMagicLinkLogin, user, "", client, result, err) }()
+
+	ott, err := s.consumeOneTimeToken(domain.TokenPurposeMagicLink, token)
+	if err != nil {
+		if err == repository.ErrOneTimeTokenNotFound {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	user, err = s.repo.GetUserByID(ott.UserID)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if !user.IsActive {
+		return nil, ErrUserInactive
+	}
+
+	// Receiving the link proves ownership of the address
+	if err := s.markEmailVerified(user); err != nil {
+		return nil, err
+	}
+
+	return s.completeLogin(user, client)
+}
@@ -0,0 +1,235 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/my-username/billion-user-app/services/auth-service/internal/domain"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/repository"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/totp"
+)
+
+const (
+	mfaIssuer         = "BillionUserApp"
+	mfaChallengeTTL   = 5 * time.Minute
+	mfaMaxAttempts    = 5
+	recoveryCodeCount = 10
+)
+
+var (
+	ErrMFAAlreadyEnabled   = errors.New("mfa is already enabled")
+	ErrMFANotEnrolled      = errors.New("mfa enrollment has not been started")
+	ErrMFANotEnabled       = errors.New("mfa is not enabled")
+	ErrInvalidMFACode      = errors.New("invalid mfa code")
+	ErrInvalidMFAChallenge = errors.New("invalid or expired mfa challenge")
+)
+
+// MFAEnrollment holds what the user needs to add the account to an
+// authenticator app
+type MFAEnrollment struct {
+	Secret string
+	URI    string
+}
+
+// EnrollMFA generates a new TOTP secret. MFA is not enforced until the user
+// proves they can produce codes with ConfirmMFA.
+func (s *authService) EnrollMFA(userID uint64) (*MFAEnrollment, error) {
+	user, err := s.repo.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.MFAEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	user.MFASecret = secret
+	user.MFALastStep = 0
+	if err := s.repo.UpdateUser(user); err != nil {
+		return nil, err
+	}
+
+	return &MFAEnrollment{
+		Secret: secret,
+		URI:    totp.URI(mfaIssuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmMFA enables MFA once the user submits a valid code for the enrolled
+// secret, and returns a fresh set of recovery codes
+func (s *authService) ConfirmMFA(userID uint64, code string) ([]string, error) {
+	user, err := s.repo.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.MFAEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if user.MFASecret == "" {
+		return nil, ErrMFANotEnrolled
+	}
+
+	if err := s.verifyTOTP(user, code); err != nil {
+		return nil, err
+	}
+
+	user.MFAEnabled = true
+	if err := s.repo.UpdateUser(user); err != nil {
+		return nil, err
+	}
+
+	return s.generateRecoveryCodes(user.ID)
+}
+
+// DisableMFA turns MFA off after checking a current TOTP or recovery code
+func (s *authService) DisableMFA(userID uint64, code string) error {
+	user, err := s.repo.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	if !user.MFAEnabled {
+		return ErrMFANotEnabled
+	}
+
+	if err := s.verifyMFACode(user, code); err != nil {
+		return err
+	}
+
+	user.MFAEnabled = false
+	user.MFASecret = ""
+	user.MFALastStep = 0
+	if err := s.repo.UpdateUser(user); err != nil {
+		return err
+	}
+
+	return s.repo.DeleteRecoveryCodes(user.ID)
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes, invalidating old ones
+func (s *authService) RegenerateRecoveryCodes(userID uint64, code string) ([]string, error) {
+	user, err := s.repo.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.MFAEnabled {
+		return nil, ErrMFANotEnabled
+	}
+
+	if err := s.verifyTOTP(user, code); err != nil {
+		return nil, err
+	}
+
+	return s.generateRecoveryCodes(user.ID)
+}
+
+// VerifyMFA completes a login that was answered with an MFA challenge
+func (s *authService) VerifyMFA(mfaToken, code string, client ClientInfo) (result *LoginResult, err error) {
+	var user *domain.User
+	defer func() { s.recordLogin(domain.AuditActionMFAVerify, user, "", client, result, err) }()
+
+	challenge, err := s.findOneTimeToken(domain.TokenPurposeMFAChallenge, mfaToken)
+	if err != nil {
+		if err == repository.ErrOneTimeTokenNotFound {
+			return nil, ErrInvalidMFAChallenge
+		}
+		return nil, err
+	}
+
+	// Too many wrong codes: burn the challenge so the password has to be
+	// entered again
+	if challenge.Attempts >= mfaMaxAttempts {
+		_ = s.repo.UseOneTimeToken(challenge.ID)
+		return nil, ErrInvalidMFAChallenge
+	}
+
+	user, err = s.repo.GetUserByID(challenge.UserID)
+	if err != nil {
+		return nil, ErrInvalidMFAChallenge
+	}
+
+	if !user.IsActive {
+		return nil, ErrUserInactive
+	}
+
+	if s.loginGuard != nil {
+		if err := s.loginGuard.Check(user.Email, client.IP); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.verifyMFACode(user, code); err != nil {
+		_ = s.repo.IncrementOneTimeTokenAttempts(challenge.ID)
+		s.recordLoginFailure(user.Email, user, client)
+		return nil, err
+	}
+
+	if err := s.repo.UseOneTimeToken(challenge.ID); err != nil {
+		return nil, ErrInvalidMFAChallenge
+	}
+
+	return s.issueTokens(user, client)
+}
+
+// verifyMFACode accepts either a TOTP code or an unused recovery code
+func (s *authService) verifyMFACode(user *domain.User, code string) error {
+	if err := s.verifyTOTP(user, code); err == nil {
+		return nil
+	}
+
+	if err := s.repo.UseRecoveryCode(user.ID, hashToken(normalizeRecoveryCode(code))); err != nil {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// verifyTOTP checks a TOTP code and records its time step so the same code
+// cannot be used twice
+func (s *authService) verifyTOTP(user *domain.User, code string) error {
+	step, err := totp.Validate(user.MFASecret, code, s.now(), user.MFALastStep)
+	if err != nil {
+		return ErrInvalidMFACode
+	}
+
+	if err := s.repo.AdvanceMFAStep(user.ID, step); err != nil {
+		if err == repository.ErrMFAStepUsed {
+			return ErrInvalidMFACode
+		}
+		return err
+	}
+	user.MFALastStep = step
+	return nil
+}
+
+func (s *authService) generateRecoveryCodes(userID uint64) ([]string, error) {
+	plain := make([]string, 0, recoveryCodeCount)
+	codes := make([]*domain.RecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := generateSecureToken(5) // 10 hex characters
+		code := raw[:5] + "-" + raw[5:]
+		plain = append(plain, code)
+		codes = append(codes, &domain.RecoveryCode{
+			UserID:   userID,
+			CodeHash: hashToken(normalizeRecoveryCode(code)),
+		})
+	}
+
+	if err := s.repo.ReplaceRecoveryCodes(userID, codes); err != nil {
+		return nil, err
+	}
+	return plain, nil
+}
+
+// normalizeRecoveryCode makes recovery codes case- and separator-insensitive
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/my-username/billion-user-app/services/auth-service/internal/domain"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/repository"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/totp"
+)
+
+// stepRepository stores a user's last TOTP step the way the users table
+// does, advancing it only if the new step is greater
+type stepRepository struct {
+	repository.AuthRepository
+
+	mu       sync.Mutex
+	lastStep int64
+}
+
+func (r *stepRepository) AdvanceMFAStep(userID uint64, step int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if step <= r.lastStep {
+		return repository.ErrMFAStepUsed
+	}
+	r.lastStep = step
+	return nil
+}
+
+func TestVerifyTOTPRejectsConcurrentReplay(t *testing.T) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1234567890, 0)
+	code, err := totp.Code(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := &stepRepository{}
+	s := &authService{repo: repo, now: func() time.Time { return now }}
+
+	// Two logins loaded the user before either accepted the code
+	results := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		user := &domain.User{ID: 1, MFAEnabled: true, MFASecret: secret}
+		go func() { results <- s.verifyTOTP(user, code) }()
+	}
+
+	accepted := 0
+	for i := 0; i < 2; i++ {
+		err := <-results
+		switch {
+		case err == nil:
+			accepted++
+		case !errors.Is(err, ErrInvalidMFACode):
+			t.Errorf("verifyTOTP() error = %v, want ErrInvalidMFACode", err)
+		}
+	}
+	if accepted != 1 {
+		t.Errorf("code accepted %d times, want once", accepted)
+	}
+	if repo.lastStep != totp.Step(now) {
+		t.Errorf("last step = %d, want %d", repo.lastStep, totp.Step(now))
+	}
+}
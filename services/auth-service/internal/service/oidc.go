@@ -0,0 +1,442 @@
+package service
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/my-username/billion-user-app/pkg/jwtutils"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/domain"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/repository"
+)
+
+// OpenID Connect scopes. They may be requested in the authorization-code
+// flow alongside the API scopes from jwtutils.
+const (
+	ScopeOpenID        = "openid"
+	ScopeProfile       = "profile"
+	ScopeEmail         = "email"
+	ScopeOfflineAccess = "offline_access" // Issue a refresh token
+)
+
+var oidcScopes = []string{ScopeOpenID, ScopeProfile, ScopeEmail, ScopeOfflineAccess}
+
+const (
+	authorizationCodeTTL = time.Minute
+	pkceMethodS256       = "S256"
+)
+
+var (
+	ErrInvalidRequest          = errors.New("invalid authorization request")
+	ErrUnsupportedResponseType = errors.New("unsupported response type")
+	ErrInvalidGrant            = errors.New("invalid or expired authorization grant")
+	ErrInsufficientScope       = errors.New("token does not grant the openid scope")
+)
+
+// AuthorizationRequest holds the parameters of an authorization request
+// (RFC 6749 section 4.1.1 with PKCE, RFC 7636)
+type AuthorizationRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scopes              []string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// Consent describes what a client asks the user for
+type Consent struct {
+	Client  *domain.OAuthClient
+	Scopes  []string
+	Granted bool // The user already allowed these scopes
+}
+
+// OAuthTokens is the result of a token request in the authorization-code
+// flow. RefreshToken and IDToken are empty unless offline_access and openid
+// were granted.
+type OAuthTokens struct {
+	AccessToken  string
+	RefreshToken string
+	IDToken      string
+	ExpiresIn    time.Duration
+	Scopes       []string
+}
+
+// BeginAuthorization validates an authorization request and returns the URL
+// of the frontend's consent page, which carries the request on in its query.
+// ErrInvalidClient and ErrInvalidRedirectURI must be shown to the user; any
+// other error can be reported to the client's redirect URI.
+func (s *authService) BeginAuthorization(req AuthorizationRequest) (string, error) {
+	if _, err := s.validateAuthorizationRequest(req); err != nil {
+		return "", err
+	}
+
+	query := url.Values{
+		"response_type":         {req.ResponseType},
+		"client_id":             {req.ClientID},
+		"redirect_uri":          {req.RedirectURI},
+		"scope":                 {strings.Join(req.Scopes, " ")},
+		"code_challenge":        {req.CodeChallenge},
+		"code_challenge_method": {req.CodeChallengeMethod},
+	}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	if req.Nonce != "" {
+		query.Set("nonce", req.Nonce)
+	}
+	return s.appBaseURL + "/oauth/consent?" + query.Encode(), nil
+}
+
+// validateAuthorizationRequest checks a request against the client's
+// registration
+func (s *authService) validateAuthorizationRequest(req AuthorizationRequest) (*Consent, error) {
+	client, err := s.repo.GetOAuthClient(req.ClientID)
+	if err != nil {
+		if err == repository.ErrClientNotFound {
+			return nil, ErrInvalidClient
+		}
+		return nil, err
+	}
+
+	if client.RevokedAt != nil {
+		return nil, ErrInvalidClient
+	}
+
+	if !client.AllowsRedirectURI(req.RedirectURI) {
+		return nil, ErrInvalidRedirectURI
+	}
+
+	if req.ResponseType != "code" {
+		return nil, ErrUnsupportedResponseType
+	}
+
+	if !client.AllowsGrant(domain.GrantAuthorizationCode) {
+		return nil, ErrUnauthorizedClient
+	}
+
+	scopes, err := narrow(client.Scopes, req.Scopes)
+	if err != nil {
+		return nil, ErrInvalidScope
+	}
+
+	// PKCE is required for every client, confidential ones included
+	if req.CodeChallengeMethod != pkceMethodS256 || len(req.CodeChallenge) < 43 || len(req.CodeChallenge) > 128 {
+		return nil, ErrInvalidRequest
+	}
+
+	return &Consent{Client: client, Scopes: scopes}, nil
+}
+
+// GetConsent validates the request and reports whether the user has already
+// allowed the client the requested scopes
+func (s *authService) GetConsent(userID uint64, req AuthorizationRequest) (*Consent, error) {
+	consent, err := s.validateAuthorizationRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := s.repo.GetConsent(userID, consent.Client.ClientID)
+	if err == nil {
+		consent.Granted = existing.Covers(consent.Scopes)
+	}
+	return consent, nil
+}
+
+// Authorize completes the consent step for the signed-in user and returns
+// the URL to send the browser back to: the client's redirect URI with either
+// an authorization code or an access_denied error.
+func (s *authService) Authorize(claims *jwtutils.Claims, req AuthorizationRequest, approved bool) (string, error) {
+	consent, err := s.validateAuthorizationRequest(req)
+	if err != nil {
+		return "", err
+	}
+
+	if !approved {
+		return AuthorizationRedirect(req.RedirectURI, url.Values{
+			"error": {"access_denied"},
+			"state": {req.State},
+		}), nil
+	}
+
+	granted := consent.Scopes
+	if existing, err := s.repo.GetConsent(claims.UserID, consent.Client.ClientID); err == nil {
+		granted = uniqueNonEmpty(append(existing.Scopes, granted...))
+	}
+	if err := s.repo.SaveConsent(&domain.OAuthConsent{
+		UserID:   claims.UserID,
+		ClientID: consent.Client.ClientID,
+		Scopes:   granted,
+	}); err != nil {
+		return "", err
+	}
+
+	code := generateSecureToken(32)
+	ac := &domain.AuthorizationCode{
+		CodeHash:            hashToken(code),
+		ClientID:            consent.Client.ClientID,
+		UserID:              claims.UserID,
+		RedirectURI:         req.RedirectURI,
+		Scopes:              consent.Scopes,
+		Nonce:               req.Nonce,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		AuthTime:            s.authTime(claims),
+		ExpiresAt:           s.now().Add(authorizationCodeTTL),
+	}
+	if err := s.repo.SaveAuthorizationCode(ac); err != nil {
+		return "", err
+	}
+
+	return AuthorizationRedirect(req.RedirectURI, url.Values{
+		"code":  {code},
+		"state": {req.State},
+	}), nil
+}
+
+// ExchangeAuthorizationCode implements the authorization-code grant. Each
+// redeemed code starts a session bound to the client; replaying a code
+// revokes that session.
+func (s *authService) ExchangeAuthorizationCode(clientID, secret, code, redirectURI, verifier string, info ClientInfo) (*OAuthTokens, error) {
+	client, err := s.authenticateClient(clientID, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	if !client.AllowsGrant(domain.GrantAuthorizationCode) {
+		return nil, ErrUnauthorizedClient
+	}
+
+	ac, err := s.repo.GetAuthorizationCode(hashToken(code))
+	if err != nil {
+		if err == repository.ErrCodeNotFound {
+			return nil, ErrInvalidGrant
+		}
+		return nil, err
+	}
+
+	if ac.UsedAt != nil {
+		s.revokeCodeSession(ac)
+		return nil, ErrInvalidGrant
+	}
+
+	if ac.ClientID != client.ClientID || ac.RedirectURI != redirectURI || !s.now().Before(ac.ExpiresAt) {
+		return nil, ErrInvalidGrant
+	}
+
+	if !verifyPKCE(verifier, ac.CodeChallenge) {
+		return nil, ErrInvalidGrant
+	}
+
+	user, err := s.repo.GetUserByID(ac.UserID)
+	if err != nil || !user.IsActive {
+		return nil, ErrInvalidGrant
+	}
+
+	session := s.newSession(user, info)
+	session.ClientID = client.ClientID
+	session.Scopes = ac.Scopes
+
+	if err := s.repo.RedeemAuthorizationCode(ac.ID, session.ID); err != nil {
+		if err == repository.ErrCodeAlreadyUsed {
+			s.revokeCodeSession(ac)
+			return nil, ErrInvalidGrant
+		}
+		return nil, err
+	}
+
+	if err := s.repo.CreateSession(session); err != nil {
+		return nil, err
+	}
+
+	accessToken, err := s.sessionAccessToken(user, session)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := &OAuthTokens{
+		AccessToken: accessToken,
+		ExpiresIn:   s.jwtManager.TokenDuration(),
+		Scopes:      session.Scopes,
+	}
+
+	if contains(session.Scopes, ScopeOfflineAccess) && client.AllowsGrant(domain.GrantRefreshToken) {
+		rt, err := s.issueRefreshToken(user.ID, session.ID, nil)
+		if err != nil {
+			return nil, err
+		}
+		tokens.RefreshToken = rt.Token
+	}
+
+	if contains(session.Scopes, ScopeOpenID) {
+		tokens.IDToken, err = s.idToken(user, client.ClientID, session.Scopes, ac.Nonce, ac.AuthTime)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tokens, nil
+}
+
+// RefreshClientTokens implements the refresh-token grant for OAuth2 clients.
+// It rotates the token like RefreshToken, but only for sessions of the
+// authenticated client.
+func (s *authService) RefreshClientTokens(clientID, secret, refreshToken string, info ClientInfo) (*OAuthTokens, error) {
+	client, err := s.authenticateClient(clientID, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	if !client.AllowsGrant(domain.GrantRefreshToken) {
+		return nil, ErrUnauthorizedClient
+	}
+
+	accessToken, newRefresh, session, err := s.rotateRefreshToken(refreshToken, info, client.ClientID)
+	if err != nil {
+		if err == ErrInvalidCredentials || err == ErrTokenReused || err == ErrUserInactive {
+			return nil, ErrInvalidGrant
+		}
+		return nil, err
+	}
+
+	return &OAuthTokens{
+		AccessToken:  accessToken,
+		RefreshToken: newRefresh,
+		ExpiresIn:    s.jwtManager.TokenDuration(),
+		Scopes:       session.Scopes,
+	}, nil
+}
+
+// UserInfo returns the claims about the user behind an access token that its
+// scopes allow (OpenID Connect Core section 5.3)
+func (s *authService) UserInfo(accessToken string) (map[string]interface{}, error) {
+	claims, err := s.jwtManager.ValidateToken(accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.IsService() || (claims.IsScoped() && !claims.HasScope(ScopeOpenID)) {
+		return nil, ErrInsufficientScope
+	}
+
+	if err := s.checkSession(claims); err != nil {
+		return nil, err
+	}
+
+	user, err := s.repo.GetUserByID(claims.UserID)
+	if err != nil || !user.IsActive {
+		return nil, jwtutils.ErrInvalidToken
+	}
+
+	info := map[string]interface{}{
+		"sub": strconv.FormatUint(user.ID, 10),
+	}
+	if claims.HasScope(ScopeProfile) {
+		info["preferred_username"] = user.Username
+		info["updated_at"] = user.UpdatedAt.Unix()
+	}
+	if claims.HasScope(ScopeEmail) {
+		info["email"] = user.Email
+		info["email_verified"] = user.EmailVerified
+	}
+	return info, nil
+}
+
+// Discovery returns the OpenID Provider metadata (OpenID Connect Discovery
+// section 3)
+func (s *authService) Discovery() map[string]interface{} {
+	return map[string]interface{}{
+		"issuer":                                        s.issuer,
+		"authorization_endpoint":                        s.issuer + "/oauth/authorize",
+		"token_endpoint":                                s.issuer + "/oauth/token",
+		"userinfo_endpoint":                             s.issuer + "/oauth/userinfo",
+		"introspection_endpoint":                        s.issuer + "/oauth/introspect",
+		"revocation_endpoint":                           s.issuer + "/oauth/revoke",
+		"jwks_uri":                                      s.issuer + "/.well-known/jwks.json",
+		"scopes_supported":                              append(append([]string{}, oidcScopes...), jwtutils.Scopes()...),
+		"response_types_supported":                      []string{"code"},
+		"grant_types_supported":                         []string{domain.GrantAuthorizationCode, domain.GrantRefreshToken, domain.GrantClientCredentials},
+		"subject_types_supported":                       []string{"public"},
+		"id_token_signing_alg_values_supported":         s.jwtManager.SigningAlgorithms(),
+		"token_endpoint_auth_methods_supported":         []string{"client_secret_basic", "client_secret_post", "none"},
+		"introspection_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+		"revocation_endpoint_auth_methods_supported":    []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":              []string{pkceMethodS256},
+		"claims_supported":                              []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "email", "email_verified", "preferred_username"},
+	}
+}
+
+// AuthorizationRedirect appends params to a client's redirect URI
+func AuthorizationRedirect(redirectURI string, params url.Values) string {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+
+	q := u.Query()
+	for key, values := range params {
+		for _, v := range values {
+			if v != "" {
+				q.Add(key, v)
+			}
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// idToken issues an ID token for user to the client, with the profile and
+// email claims its scopes allow
+func (s *authService) idToken(user *domain.User, clientID string, scopes []string, nonce string, authTime time.Time) (string, error) {
+	claims := jwtutils.NewIDTokenClaims(s.issuer, strconv.FormatUint(user.ID, 10), clientID, nonce, authTime)
+	if contains(scopes, ScopeProfile) {
+		claims.PreferredUsername = user.Username
+	}
+	if contains(scopes, ScopeEmail) {
+		verified := user.EmailVerified
+		claims.Email = user.Email
+		claims.EmailVerified = &verified
+	}
+	return s.jwtManager.GenerateIDToken(claims)
+}
+
+// authTime is when the user behind claims logged in: the start of their
+// session, or the token's issue time for tokens without one
+func (s *authService) authTime(claims *jwtutils.Claims) time.Time {
+	if claims.SessionID != "" {
+		if session, err := s.repo.GetSession(claims.SessionID); err == nil {
+			return session.CreatedAt
+		}
+	}
+	if claims.IssuedAt != nil {
+		return claims.IssuedAt.Time
+	}
+	return s.now()
+}
+
+// revokeCodeSession revokes the tokens issued for a code that was redeemed
+// more than once (RFC 6749 section 4.1.2)
+func (s *authService) revokeCodeSession(ac *domain.AuthorizationCode) {
+	if ac.SessionID == "" {
+		return
+	}
+	_ = s.repo.RevokeSession(ac.SessionID)
+	s.revokeSessionTokens(ac.SessionID)
+}
+
+// verifyPKCE checks a code verifier against an S256 challenge
+func verifyPKCE(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
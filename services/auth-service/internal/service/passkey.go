@@ -0,0 +1,213 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/my-username/billion-user-app/services/auth-service/internal/domain"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/repository"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/webauthn"
+)
+
+// passkeyCeremonyTTL is how long the user has to answer the browser prompt
+const passkeyCeremonyTTL = 5 * time.Minute
+
+const (
+	defaultPasskeyName   = "Passkey"
+	maxPasskeyNameLength = 100
+)
+
+var (
+	ErrPasskeysDisabled = errors.New("passkeys are not configured")
+	ErrPasskeyNotFound  = errors.New("passkey not found")
+	ErrInvalidPasskey   = errors.New("invalid passkey")
+)
+
+// The challenge of a WebAuthn ceremony is a one-time token, so it is signed,
+// expires and can only be answered once. The browser echoes it back in the
+// client data, which is how the finishing request finds its ceremony.
+
+// BeginPasskeyRegistration returns the options for navigator.credentials.create
+// to add a passkey to the user's account
+func (s *authService) BeginPasskeyRegistration(userID uint64) (*webauthn.CreationOptions, error) {
+	if s.passkeys == nil {
+		return nil, ErrPasskeysDisabled
+	}
+
+	user, err := s.repo.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := s.repo.ListPasskeys(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge, err := s.issueOneTimeToken(user.ID, domain.TokenPurposePasskeyRegister, passkeyCeremonyTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	webauthnUser := webauthn.User{
+		ID:          passkeyUserHandle(user.ID),
+		Name:        user.Email,
+		DisplayName: user.Username,
+	}
+	return s.passkeys.CreationOptions(webauthnUser, []byte(challenge), passkeyDescriptors(existing)), nil
+}
+
+// FinishPasskeyRegistration verifies the browser's answer to
+// BeginPasskeyRegistration and stores the new passkey under name
+func (s *authService) FinishPasskeyRegistration(userID uint64, name string, resp *webauthn.RegistrationResponse, client ClientInfo) (passkey *domain.Passkey, err error) {
+	if s.passkeys == nil {
+		return nil, ErrPasskeysDisabled
+	}
+	defer func() { s.recordAuthEvent(domain.AuditActionPasskeyAdded, userID, "", client, "", err) }()
+
+	challenge, err := resp.Challenge()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	ott, err := s.consumeOneTimeToken(domain.TokenPurposePasskeyRegister, string(challenge))
+	if err != nil || ott.UserID != userID {
+		return nil, fmt.Errorf("%w: unknown or expired challenge", ErrInvalidPasskey)
+	}
+
+	credential, err := s.passkeys.VerifyRegistration(resp, challenge)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = defaultPasskeyName
+	}
+	if len(name) > maxPasskeyNameLength {
+		name = name[:maxPasskeyNameLength]
+	}
+
+	passkey = &domain.Passkey{
+		UserID:       userID,
+		Name:         name,
+		CredentialID: credential.ID,
+		PublicKey:    credential.PublicKey,
+		SignCount:    credential.SignCount,
+		Transports:   credential.Transports,
+		BackedUp:     credential.BackedUp,
+	}
+	if err := s.repo.CreatePasskey(passkey); err != nil {
+		return nil, err
+	}
+	return passkey, nil
+}
+
+// ListPasskeys returns the user's passkeys
+func (s *authService) ListPasskeys(userID uint64) ([]*domain.Passkey, error) {
+	return s.repo.ListPasskeys(userID)
+}
+
+// DeletePasskey removes one of the user's passkeys. It stays on the
+// authenticator but can no longer be used to log in.
+func (s *authService) DeletePasskey(userID, passkeyID uint64, client ClientInfo) (err error) {
+	defer func() { s.recordAuthEvent(domain.AuditActionPasskeyRemoved, userID, "", client, "", err) }()
+
+	if err := s.repo.DeletePasskey(userID, passkeyID); err != nil {
+		if err == repository.ErrPasskeyNotFound {
+			return ErrPasskeyNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// BeginPasskeyLogin returns the options for navigator.credentials.get.
+// Passkeys are discoverable, so no credentials are listed and the browser
+// lets the user pick any passkey for this site. Listing a user's passkeys
+// would reveal whether an account exists.
+func (s *authService) BeginPasskeyLogin() (*webauthn.RequestOptions, error) {
+	if s.passkeys == nil {
+		return nil, ErrPasskeysDisabled
+	}
+
+	challenge, err := s.issueOneTimeToken(0, domain.TokenPurposePasskeyLogin, passkeyCeremonyTTL)
+	if err != nil {
+		return nil, err
+	}
+	return s.passkeys.RequestOptions([]byte(challenge), nil), nil
+}
+
+// LoginWithPasskey verifies the browser's answer to BeginPasskeyLogin and
+// logs the passkey's owner in. A passkey unlocked with a PIN or biometric
+// counts as two factors; otherwise users with MFA get the usual challenge.
+func (s *authService) LoginWithPasskey(resp *webauthn.AssertionResponse, client ClientInfo) (result *LoginResult, err error) {
+	if s.passkeys == nil {
+		return nil, ErrPasskeysDisabled
+	}
+	var user *domain.User
+	defer func() { s.recordLogin(domain.AuditActionPasskeyLogin, user, "", client, result, err) }()
+
+	challenge, err := resp.Challenge()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	if _, err := s.consumeOneTimeToken(domain.TokenPurposePasskeyLogin, string(challenge)); err != nil {
+		return nil, fmt.Errorf("%w: unknown or expired challenge", ErrInvalidPasskey)
+	}
+
+	passkey, err := s.repo.GetPasskeyByCredentialID(resp.RawID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: unknown credential", ErrInvalidPasskey)
+	}
+
+	if len(resp.Response.UserHandle) > 0 && !bytes.Equal(resp.Response.UserHandle, passkeyUserHandle(passkey.UserID)) {
+		return nil, fmt.Errorf("%w: user handle does not match", ErrInvalidPasskey)
+	}
+
+	user, err = s.repo.GetUserByID(passkey.UserID)
+	if err != nil {
+		return nil, ErrInvalidPasskey
+	}
+
+	assertion, err := s.passkeys.VerifyAssertion(resp, challenge, passkey.PublicKey, passkey.SignCount)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	if err := s.repo.UpdatePasskeyUsage(passkey.ID, assertion.SignCount, assertion.BackedUp); err != nil {
+		return nil, err
+	}
+
+	if !user.IsActive {
+		return nil, ErrUserInactive
+	}
+
+	if s.requireEmailVerification && !user.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+
+	if assertion.UserVerified {
+		return s.issueTokens(user, client)
+	}
+	return s.completeLogin(user, client)
+}
+
+// passkeyUserHandle is the WebAuthn user handle of a user: their ID, which
+// carries no personal information
+func passkeyUserHandle(userID uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, userID)
+}
+
+func passkeyDescriptors(passkeys []*domain.Passkey) []webauthn.CredentialDescriptor {
+	descriptors := make([]webauthn.CredentialDescriptor, 0, len(passkeys))
+	for _, passkey := range passkeys {
+		descriptors = append(descriptors, webauthn.NewCredentialDescriptor(passkey.CredentialID, passkey.Transports))
+	}
+	return descriptors
+}
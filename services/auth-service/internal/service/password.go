@@ -0,0 +1,97 @@
+package service
+
+import "github.com/my-username/billion-user-app/services/auth-service/internal/domain"
+
+// ChangePassword replaces a signed-in user's password after checking the
+// current one. The user's other sessions are revoked, since the old password
+// may have been compromised; the session making the request stays logged in.
+func (s *authService) ChangePassword(userID uint64, sessionID, currentPassword, newPassword string, client ClientInfo) (err error) {
+	defer func() { s.recordAuthEvent(domain.AuditActionPasswordChange, userID, "", client, "", err) }()
+
+	user, err := s.repo.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	if match, _, err := s.hasher.Verify(user.Password, currentPassword); err != nil || !match {
+		return ErrInvalidCredentials
+	}
+
+	if err := s.checkNewPassword(user, newPassword); err != nil {
+		return err
+	}
+
+	if err := s.replacePassword(user, newPassword); err != nil {
+		return err
+	}
+	if err := s.repo.UpdateUser(user); err != nil {
+		return err
+	}
+
+	sessions, err := s.repo.ListActiveSessions(user.ID)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if session.ID == sessionID {
+			continue
+		}
+		if err := s.repo.RevokeSession(session.ID); err != nil {
+			return err
+		}
+		s.revokeSessionTokens(session.ID)
+	}
+	return nil
+}
+
+// checkNewPassword applies the password policy and the user's password
+// history to a password they want to set
+func (s *authService) checkNewPassword(user *domain.User, password string) error {
+	if err := s.passwords.Check(password, user.Username, user.Email); err != nil {
+		return err
+	}
+
+	historySize := s.passwords.Policy().HistorySize
+	if historySize <= 0 {
+		return nil
+	}
+
+	hashes := []string{user.Password}
+	if historySize > 1 {
+		history, err := s.repo.ListPasswordHistory(user.ID, historySize-1)
+		if err != nil {
+			return err
+		}
+		for _, entry := range history {
+			hashes = append(hashes, entry.Hash)
+		}
+	}
+
+	for _, hash := range hashes {
+		if match, _, _ := s.hasher.Verify(hash, password); match {
+			return s.passwords.ReusedError()
+		}
+	}
+	return nil
+}
+
+// replacePassword sets a new password on user and remembers the old one in
+// the password history. The caller saves the user.
+func (s *authService) replacePassword(user *domain.User, password string) error {
+	hashedPassword, err := s.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	// The current password is checked separately, so the history only has
+	// to hold the ones before it
+	if keep := s.passwords.Policy().HistorySize - 1; keep > 0 && user.Password != "" {
+		entry := &domain.PasswordHistory{UserID: user.ID, Hash: user.Password}
+		if err := s.repo.AddPasswordHistory(entry, keep); err != nil {
+			return err
+		}
+	}
+
+	user.Password = hashedPassword
+	return nil
+}
@@ -4,48 +4,204 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
+	"strconv"
 	"time"
 
 	"github.com/my-username/billion-user-app/pkg/jwtutils"
 	"github.com/my-username/billion-user-app/pkg/kafkaclient"
+	"github.com/my-username/billion-user-app/pkg/revocation"
 	"github.com/my-username/billion-user-app/services/auth-service/internal/domain"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/federation"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/lockout"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/mailer"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/passwords"
 	"github.com/my-username/billion-user-app/services/auth-service/internal/repository"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/webauthn"
 )
 
 var (
 	ErrInvalidCredentials = errors.New("invalid credentials")
 	ErrUserInactive       = errors.New("user account is inactive")
+	ErrTokenReused        = errors.New("refresh token reuse detected")
 )
 
+const refreshTokenTTL = 7 * 24 * time.Hour
+
+// Security event types published on the "auth.security" topic
+const (
+	SecurityEventTokenReuse    = "refresh_token_reuse"
+	SecurityEventAccountLocked = "account_locked"
+)
+
+// ClientInfo describes the client making an authentication request
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+// LoginResult is returned by Login. When MFARequired is set no tokens have
+// been issued yet: MFAToken must be exchanged, together with a TOTP or
+// recovery code, through VerifyMFA.
+type LoginResult struct {
+	AccessToken  string
+	RefreshToken string
+	MFARequired  bool
+	MFAToken     string
+}
+
 // AuthService defines the interface for auth business logic
 type AuthService interface {
-	Register(email, username, password string) (*domain.User, error)
-	Login(email, password string) (string, string, error)
-	RefreshToken(refreshToken string) (string, string, error)
-	Logout(refreshToken string) error
+	Register(email, username, password string, client ClientInfo) (*domain.User, error)
+	Login(email, password string, client ClientInfo) (*LoginResult, error)
+	VerifyMFA(mfaToken, code string, client ClientInfo) (*LoginResult, error)
+	EnrollMFA(userID uint64) (*MFAEnrollment, error)
+	ConfirmMFA(userID uint64, code string) ([]string, error)
+	DisableMFA(userID uint64, code string) error
+	RegenerateRecoveryCodes(userID uint64, code string) ([]string, error)
+	SendVerificationEmail(email string) error
+	VerifyEmail(token string) error
+	RequestPasswordReset(email string) error
+	ResetPassword(token, newPassword string, client ClientInfo) error
+	ChangePassword(userID uint64, sessionID, currentPassword, newPassword string, client ClientInfo) error
+	RequestMagicLink(email string, client ClientInfo) error
+	LoginWithMagicLink(token string, client ClientInfo) (*LoginResult, error)
+	RefreshToken(refreshToken string, client ClientInfo) (string, string, error)
+	Logout(refreshToken string, client ClientInfo) error
 	ValidateToken(token string) (*jwtutils.Claims, error)
 	GetUserByID(id uint64) (*domain.User, error)
+	ListSessions(userID uint64) ([]*domain.Session, error)
+	RevokeSession(userID uint64, sessionID string) error
+	RevokeAllSessions(userID uint64) error
+	CreateAPIKey(userID uint64, name string, scopes []string, lifetime time.Duration) (*domain.APIKey, string, error)
+	ListAPIKeys(userID uint64) ([]*domain.APIKey, error)
+	RevokeAPIKey(userID, keyID uint64) error
+	ExchangeAPIKey(secret string) (string, time.Duration, error)
+	RegisterClient(reg ClientRegistration) (*domain.OAuthClient, string, error)
+	ListClients() ([]*domain.OAuthClient, error)
+	RevokeClient(clientID string) error
+	IssueClientToken(clientID, secret string, scopes, audience []string) (*ClientToken, error)
+	BeginAuthorization(req AuthorizationRequest) (string, error)
+	GetConsent(userID uint64, req AuthorizationRequest) (*Consent, error)
+	Authorize(claims *jwtutils.Claims, req AuthorizationRequest, approved bool) (string, error)
+	ExchangeAuthorizationCode(clientID, secret, code, redirectURI, verifier string, client ClientInfo) (*OAuthTokens, error)
+	RefreshClientTokens(clientID, secret, refreshToken string, client ClientInfo) (*OAuthTokens, error)
+	IntrospectToken(clientID, secret, token, hint string) (*Introspection, error)
+	RevokeOAuthToken(clientID, secret, token, hint string) error
+	UserInfo(accessToken string) (map[string]interface{}, error)
+	Discovery() map[string]interface{}
+	ExternalProviders() []string
+	BeginExternalLogin(provider, linkToken string) (string, string, error)
+	CompleteExternalLogin(provider, sealedState string, callback ExternalCallback) string
+	ExchangeExternalLogin(token string, client ClientInfo) (*LoginResult, error)
+	CreateIdentityLinkToken(userID uint64) (string, error)
+	ListIdentities(userID uint64) ([]*domain.ExternalIdentity, error)
+	UnlinkIdentity(userID, identityID uint64) error
+	BeginPasskeyRegistration(userID uint64) (*webauthn.CreationOptions, error)
+	FinishPasskeyRegistration(userID uint64, name string, resp *webauthn.RegistrationResponse, client ClientInfo) (*domain.Passkey, error)
+	ListPasskeys(userID uint64) ([]*domain.Passkey, error)
+	DeletePasskey(userID, passkeyID uint64, client ClientInfo) error
+	BeginPasskeyLogin() (*webauthn.RequestOptions, error)
+	LoginWithPasskey(resp *webauthn.AssertionResponse, client ClientInfo) (*LoginResult, error)
+	JWKS() jwtutils.JWKS
+	SeedRoles() error
+	ListRoles() ([]*domain.Role, error)
+	AssignRole(actor AdminActor, userID uint64, roleName, reason string) (*domain.User, error)
+	RevokeRole(actor AdminActor, userID uint64, roleName, reason string) (*domain.User, error)
+	SearchUsers(search UserSearch) ([]*domain.User, int64, error)
+	SetUserActive(actor AdminActor, userID uint64, active bool, reason string) (*domain.User, error)
+	RevokeUserTokens(actor AdminActor, userID uint64, reason string) error
+	ResetUserCredentials(actor AdminActor, userID uint64, resetMFA bool, reason string) error
+	ImpersonateUser(actor AdminActor, userID uint64, reason string) (*Impersonation, error)
+	ListAuditLog(query AuditQuery) ([]*domain.AuditEntry, int64, error)
+}
+
+// Options holds optional collaborators and settings of the auth service
+type Options struct {
+	Mailer                   mailer.Mailer
+	LoginGuard               *lockout.Guard         // Brute-force protection, optional
+	MagicLinkLimiter         *lockout.Limiter       // Magic links per email address, optional
+	MagicLinkIPLimiter       *lockout.Limiter       // Magic links per client IP, optional
+	PasswordChecker          *passwords.Checker     // Defaults to passwords.DefaultPolicy without a breach check
+	PasswordHasher           *passwords.Hasher      // Defaults to passwords.DefaultHasher
+	Revocations              *revocation.Store      // Access-token revocation list, optional
+	IdentityProviders        []*federation.Provider // External OpenID providers for login, optional
+	Passkeys                 *webauthn.RelyingParty // WebAuthn relying party for passkey login, optional
+	TokenSecret              string                 // HMAC key for one-time tokens
+	AppBaseURL               string                 // Frontend URL used in email links
+	Issuer                   string                 // OpenID Connect issuer, the public URL of this service
+	RequireEmailVerification bool
 }
 
 type authService struct {
 	repo        repository.AuthRepository
 	jwtManager  *jwtutils.JWTManager
-	kafkaClient *kafkaclient.Client
+	mailer      mailer.Mailer
+	loginGuard  *lockout.Guard
+	revocations *revocation.Store
+	providers   map[string]*federation.Provider
+	passkeys    *webauthn.RelyingParty
+	passwords   *passwords.Checker
+	hasher      *passwords.Hasher
+	now         func() time.Time // Injectable clock for TOTP and token expiry
+
+	magicLinkLimiter   *lockout.Limiter
+	magicLinkIPLimiter *lockout.Limiter
+
+	tokenSecret              []byte
+	appBaseURL               string
+	issuer                   string
+	requireEmailVerification bool
 }
 
 // NewAuthService creates a new auth service
-func NewAuthService(repo repository.AuthRepository, jwtManager *jwtutils.JWTManager, kafkaClient *kafkaclient.Client) AuthService {
+func NewAuthService(repo repository.AuthRepository, jwtManager *jwtutils.JWTManager, opts Options) AuthService {
+	providers := make(map[string]*federation.Provider, len(opts.IdentityProviders))
+	for _, p := range opts.IdentityProviders {
+		providers[p.Name()] = p
+	}
+
+	checker := opts.PasswordChecker
+	if checker == nil {
+		checker = passwords.NewChecker(passwords.DefaultPolicy(), nil)
+	}
+	hasher := opts.PasswordHasher
+	if hasher == nil {
+		hasher = passwords.DefaultHasher()
+	}
+
 	return &authService{
 		repo:        repo,
 		jwtManager:  jwtManager,
-		kafkaClient: kafkaClient,
+		mailer:      opts.Mailer,
+		loginGuard:  opts.LoginGuard,
+		revocations: opts.Revocations,
+		providers:   providers,
+		passkeys:    opts.Passkeys,
+		passwords:   checker,
+		hasher:      hasher,
+		now:         time.Now,
+
+		magicLinkLimiter:   opts.MagicLinkLimiter,
+		magicLinkIPLimiter: opts.MagicLinkIPLimiter,
+
+		tokenSecret:              []byte(opts.TokenSecret),
+		appBaseURL:               opts.AppBaseURL,
+		issuer:                   opts.Issuer,
+		requireEmailVerification: opts.RequireEmailVerification,
 	}
 }
 
-func (s *authService) Register(email, username, password string) (*domain.User, error) {
+func (s *authService) Register(email, username, password string, client ClientInfo) (user *domain.User, err error) {
+	defer func() {
+		var userID uint64
+		if user != nil {
+			userID = user.ID
+		}
+		s.recordAuthEvent(domain.AuditActionRegister, userID, email, client, "", err)
+	}()
+
 	// Check if user already exists by email
-	_, err := s.repo.GetUserByEmail(email)
+	_, err = s.repo.GetUserByEmail(email)
 	if err == nil {
 		return nil, repository.ErrUserAlreadyExists
 	}
@@ -62,124 +218,442 @@ func (s *authService) Register(email, username, password string) (*domain.User,
 		return nil, err
 	}
 
+	if err := s.passwords.Check(password, username, email); err != nil {
+		return nil, err
+	}
+
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashedPassword, err := s.hasher.Hash(password)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every account starts with the default "user" role
+	defaultRole, err := s.repo.GetRoleByName(jwtutils.RoleUser)
 	if err != nil {
 		return nil, err
 	}
 
 	// Create user
-	user := &domain.User{
+	user = &domain.User{
 		Email:    email,
 		Username: username,
-		Password: string(hashedPassword),
+		Password: hashedPassword,
 		IsActive: true,
+		Roles:    []domain.Role{*defaultRole},
 	}
 
-	if err := s.repo.CreateUser(user); err != nil {
+	if err := s.createUser(user); err != nil {
 		return nil, err
 	}
 
-	// Publish "user.created" event
-	if s.kafkaClient != nil {
-		event := kafkaclient.UserCreatedEvent{
-			UserID:    user.ID,
-			Email:     user.Email,
-			Username:  user.Username,
-			CreatedAt: user.CreatedAt.Format(time.RFC3339),
-		}
-		_ = s.kafkaClient.PublishEvent("user.created", event)
-	}
+	// Best effort: the user can ask for a new link if delivery fails
+	_ = s.sendVerificationEmail(user)
 
 	return user, nil
 }
 
-func (s *authService) Login(email, password string) (string, string, error) {
-	user, err := s.repo.GetUserByEmail(email)
+func (s *authService) Login(email, password string, client ClientInfo) (result *LoginResult, err error) {
+	var user *domain.User
+	defer func() { s.recordLogin(domain.AuditActionLogin, user, email, client, result, err) }()
+
+	// Rejected attempts come back as *lockout.BlockedError
+	if s.loginGuard != nil {
+		if err := s.loginGuard.Check(email, client.IP); err != nil {
+			return nil, err
+		}
+	}
+
+	user, err = s.repo.GetUserByEmail(email)
 	if err != nil {
-		return "", "", ErrInvalidCredentials
+		// Unknown emails are counted too, so lockouts do not reveal which
+		// accounts exist
+		s.recordLoginFailure(email, nil, client)
+		return nil, ErrInvalidCredentials
 	}
 
 	if !user.IsActive {
-		return "", "", ErrUserInactive
+		return nil, ErrUserInactive
 	}
 
 	// Verify password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return "", "", ErrInvalidCredentials
+	match, rehash, err := s.hasher.Verify(user.Password, password)
+	if err != nil || !match {
+		s.recordLoginFailure(email, user, client)
+		return nil, ErrInvalidCredentials
 	}
 
-	// Generate tokens
-	accessToken, err := s.jwtManager.GenerateToken(user.ID, user.Email, user.Username)
-	if err != nil {
-		return "", "", err
+	// Hashes made with an older algorithm or cost are upgraded while the
+	// plaintext is at hand. Best effort: the old hash keeps working.
+	if rehash {
+		if hashedPassword, err := s.hasher.Hash(password); err == nil {
+			if s.repo.ReplacePasswordHash(user.ID, user.Password, hashedPassword) == nil {
+				user.Password = hashedPassword
+			}
+		}
 	}
 
-	// Create secure refresh token
-	refreshToken := generateSecureToken(64)
-	expiry := time.Now().Add(7 * 24 * time.Hour)
-
-	rt := &domain.RefreshToken{
-		UserID:    user.ID,
-		Token:     refreshToken,
-		ExpiresAt: expiry,
+	if s.requireEmailVerification && !user.EmailVerified {
+		return nil, ErrEmailNotVerified
 	}
 
-	if err := s.repo.SaveRefreshToken(rt); err != nil {
-		return "", "", err
-	}
+	return s.completeLogin(user, client)
+}
 
-	return accessToken, refreshToken, nil
+func (s *authService) RefreshToken(refreshToken string, client ClientInfo) (string, string, error) {
+	accessToken, newRefresh, _, err := s.rotateRefreshToken(refreshToken, client, "")
+	return accessToken, newRefresh, err
 }
 
-func (s *authService) RefreshToken(refreshToken string) (string, string, error) {
-	rt, err := s.repo.GetRefreshToken(refreshToken)
+// rotateRefreshToken exchanges a refresh token for a new access and refresh
+// token. clientID is the OAuth2 client the token's session must belong to,
+// or empty for first-party logins. Every attempt is recorded in the audit log.
+func (s *authService) rotateRefreshToken(refreshToken string, client ClientInfo, clientID string) (access, refresh string, session *domain.Session, err error) {
+	var rt *domain.RefreshToken
+	defer func() {
+		var userID uint64
+		if rt != nil {
+			userID = rt.UserID
+		}
+		s.recordAuthEvent(domain.AuditActionRefresh, userID, "", client, "", err)
+	}()
+
+	rt, err = s.repo.GetRefreshToken(refreshToken)
 	if err != nil {
-		return "", "", ErrInvalidCredentials
+		return "", "", nil, ErrInvalidCredentials
+	}
+
+	if rt.IsRevoked() {
+		return "", "", nil, ErrInvalidCredentials
+	}
+
+	// Tokens issued before sessions existed have no family and belong to
+	// first-party logins
+	if rt.FamilyID != "" {
+		session, err = s.repo.GetSession(rt.FamilyID)
+		if err != nil {
+			return "", "", nil, ErrInvalidCredentials
+		}
+	}
+	if (session == nil && clientID != "") || (session != nil && session.ClientID != clientID) {
+		return "", "", nil, ErrInvalidCredentials
+	}
+
+	// A token that was already rotated is being replayed: either the
+	// legitimate client or an attacker holds a stolen copy. We cannot tell
+	// which, so the whole family is revoked and both must log in again.
+	if rt.IsUsed() {
+		s.revokeFamilyOnReuse(rt)
+		return "", "", nil, ErrTokenReused
 	}
 
 	user, err := s.repo.GetUserByID(rt.UserID)
 	if err != nil {
-		return "", "", ErrInvalidCredentials
+		return "", "", nil, ErrInvalidCredentials
 	}
 
 	if !user.IsActive {
-		return "", "", ErrUserInactive
+		// The account was deactivated since its last refresh
+		s.revokeUserTokens(user.ID)
+		return "", "", nil, ErrUserInactive
+	}
+
+	// Rotate refresh token. The parent is kept (marked as used) so that a
+	// later replay of it can be detected.
+	if err := s.repo.MarkRefreshTokenUsed(rt.ID); err != nil {
+		if err == repository.ErrTokenAlreadyUsed {
+			s.revokeFamilyOnReuse(rt)
+			return "", "", nil, ErrTokenReused
+		}
+		return "", "", nil, err
+	}
+
+	// Tokens issued before sessions existed start one on first rotation
+	if session == nil {
+		session, err = s.startSession(user, client)
+		if err != nil {
+			return "", "", nil, err
+		}
+	} else {
+		_ = s.repo.TouchSession(session.ID, client.IP, client.UserAgent, s.sessionExpiry())
+	}
+
+	parentID := rt.ID
+	newRefresh, err := s.issueRefreshToken(user.ID, session.ID, &parentID)
+	if err != nil {
+		return "", "", nil, err
 	}
 
 	// Generate new access token
-	accessToken, err := s.jwtManager.GenerateToken(user.ID, user.Email, user.Username)
+	accessToken, err := s.sessionAccessToken(user, session)
 	if err != nil {
-		return "", "", err
+		return "", "", nil, err
 	}
 
-	// Rotate refresh token
-	newRefresh := generateSecureToken(64)
-	rt.Token = newRefresh
-	rt.ExpiresAt = time.Now().Add(7 * 24 * time.Hour)
+	return accessToken, newRefresh.Token, session, nil
+}
 
-	if err := s.repo.SaveRefreshToken(rt); err != nil {
-		return "", "", err
+func (s *authService) Logout(refreshToken string, client ClientInfo) error {
+	rt, err := s.repo.GetRefreshToken(refreshToken)
+	if err != nil {
+		if err == repository.ErrTokenNotFound {
+			return nil
+		}
+		return err
 	}
 
-	// Delete old token
-	_ = s.repo.DeleteRefreshToken(refreshToken)
-
-	return accessToken, newRefresh, nil
+	err = s.endSession(rt)
+	s.recordAuthEvent(domain.AuditActionLogout, rt.UserID, "", client, "", err)
+	return err
 }
 
-func (s *authService) Logout(refreshToken string) error {
-	return s.repo.DeleteRefreshToken(refreshToken)
+// endSession revokes the session rt belongs to
+func (s *authService) endSession(rt *domain.RefreshToken) error {
+	if rt.FamilyID == "" {
+		return s.repo.DeleteRefreshToken(rt.Token)
+	}
+
+	// Logging out ends the whole session, not just the latest token
+	if err := s.repo.RevokeSession(rt.FamilyID); err != nil {
+		return err
+	}
+	s.revokeSessionTokens(rt.FamilyID)
+	return nil
 }
 
+// ValidateToken verifies the token signature and, for tokens bound to a
+// session, that the session has not been revoked. Scoped tokens, such as
+// those exchanged for API keys, are rejected: managing the account requires
+// an interactive login.
 func (s *authService) ValidateToken(token string) (*jwtutils.Claims, error) {
-	return s.jwtManager.ValidateToken(token)
+	claims, err := s.jwtManager.ValidateToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.IsScoped() {
+		return nil, ErrScopedToken
+	}
+
+	if err := s.checkSession(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
 }
 
 func (s *authService) GetUserByID(id uint64) (*domain.User, error) {
 	return s.repo.GetUserByID(id)
 }
 
+func (s *authService) JWKS() jwtutils.JWKS {
+	return s.jwtManager.JWKS()
+}
+
+// SeedRoles makes sure the built-in roles exist
+func (s *authService) SeedRoles() error {
+	for _, role := range domain.DefaultRoles() {
+		role := role
+		if err := s.repo.EnsureRole(&role); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *authService) ListRoles() ([]*domain.Role, error) {
+	return s.repo.ListRoles()
+}
+
+// AssignRole grants a role to a user. The new permissions are in the tokens
+// issued from the user's next login or refresh on.
+func (s *authService) AssignRole(actor AdminActor, userID uint64, roleName, reason string) (*domain.User, error) {
+	user, role, err := s.getUserAndRole(userID, roleName)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.recordAdminAction(actor, domain.AuditActionRoleAssigned, user.ID, roleReason(role, reason), func(tx repository.AuthRepository) error {
+		return tx.AssignRole(user.ID, role)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Reload so the response reflects the new role set
+	return s.repo.GetUserByID(user.ID)
+}
+
+// RevokeRole removes a role from a user. Their access tokens are revoked, so
+// the permissions are lost right away; refreshing issues tokens without them.
+func (s *authService) RevokeRole(actor AdminActor, userID uint64, roleName, reason string) (*domain.User, error) {
+	user, role, err := s.getUserAndRole(userID, roleName)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.recordAdminAction(actor, domain.AuditActionRoleRevoked, user.ID, roleReason(role, reason), func(tx repository.AuthRepository) error {
+		return tx.RemoveRole(user.ID, role)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.revokeUserTokens(user.ID)
+
+	return s.repo.GetUserByID(user.ID)
+}
+
+// roleReason records which role changed in the audit log's reason, which is
+// all the audit entry and admin event have room for
+func roleReason(role *domain.Role, reason string) string {
+	if reason == "" {
+		return "role " + role.Name
+	}
+	return "role " + role.Name + ": " + reason
+}
+
+func (s *authService) getUserAndRole(userID uint64, roleName string) (*domain.User, *domain.Role, error) {
+	user, err := s.repo.GetUserByID(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	role, err := s.repo.GetRoleByName(roleName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, role, nil
+}
+
+// recordLoginFailure counts a failed attempt and reports a resulting lockout
+func (s *authService) recordLoginFailure(email string, user *domain.User, client ClientInfo) {
+	if s.loginGuard == nil {
+		return
+	}
+
+	locked, err := s.loginGuard.RecordFailure(email, client.IP)
+	if err != nil || !locked || user == nil {
+		return
+	}
+
+	event := kafkaclient.AuthSecurityEvent{
+		UserID:     user.ID,
+		Type:       SecurityEventAccountLocked,
+		IP:         client.IP,
+		Reason:     "too many failed login attempts",
+		OccurredAt: time.Now().Format(time.RFC3339),
+	}
+	_ = s.repo.EnqueueEvent("auth.security", userKey(user.ID), event)
+}
+
+// completeLogin finishes the first authentication step: users with MFA get a
+// challenge, everyone else their tokens
+func (s *authService) completeLogin(user *domain.User, client ClientInfo) (*LoginResult, error) {
+	if user.MFAEnabled {
+		challenge, err := s.issueOneTimeToken(user.ID, domain.TokenPurposeMFAChallenge, mfaChallengeTTL)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{MFARequired: true, MFAToken: challenge}, nil
+	}
+
+	return s.issueTokens(user, client)
+}
+
+// createUser stores a new account together with its "user.created" event
+func (s *authService) createUser(user *domain.User) error {
+	return s.repo.Transaction(func(tx repository.AuthRepository) error {
+		if err := tx.CreateUser(user); err != nil {
+			return err
+		}
+		event := kafkaclient.UserCreatedEvent{
+			UserID:    user.ID,
+			Email:     user.Email,
+			Username:  user.Username,
+			CreatedAt: user.CreatedAt.Format(time.RFC3339),
+		}
+		return tx.EnqueueEvent("user.created", userKey(user.ID), event)
+	})
+}
+
+// userKey is the outbox key of events about a user, keeping them in order.
+// Events without a known user have no order to keep.
+func userKey(userID uint64) string {
+	if userID == 0 {
+		return ""
+	}
+	return strconv.FormatUint(userID, 10)
+}
+
+// issueTokens starts a new session for a fully authenticated user and issues
+// its first access and refresh tokens
+func (s *authService) issueTokens(user *domain.User, client ClientInfo) (*LoginResult, error) {
+	if s.loginGuard != nil {
+		_ = s.loginGuard.RecordSuccess(user.Email)
+	}
+
+	session, err := s.startSession(user, client)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := s.jwtManager.GenerateToken(user.ID, user.Email, user.Username, user.RoleNames(), user.Permissions(),
+		jwtutils.WithSessionID(session.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	rt, err := s.issueRefreshToken(user.ID, session.ID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResult{AccessToken: accessToken, RefreshToken: rt.Token}, nil
+}
+
+// issueRefreshToken creates and stores a new refresh token in the given family
+func (s *authService) issueRefreshToken(userID uint64, familyID string, parentID *uint64) (*domain.RefreshToken, error) {
+	rt := &domain.RefreshToken{
+		UserID:    userID,
+		Token:     generateSecureToken(64),
+		FamilyID:  familyID,
+		ParentID:  parentID,
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	}
+
+	if err := s.repo.SaveRefreshToken(rt); err != nil {
+		return nil, err
+	}
+	return rt, nil
+}
+
+// revokeFamilyOnReuse revokes every token descended from the same login as rt
+// and reports the replay as a security event
+func (s *authService) revokeFamilyOnReuse(rt *domain.RefreshToken) {
+	familyID := rt.FamilyID
+	if familyID == "" {
+		// Tokens issued before sessions existed joined the session their
+		// first rotation started, which only the child records
+		if child, err := s.repo.GetRefreshTokenByParent(rt.ID); err == nil {
+			familyID = child.FamilyID
+		}
+	}
+	if familyID != "" {
+		_ = s.repo.RevokeSession(familyID)
+		s.revokeSessionTokens(familyID)
+	}
+
+	event := kafkaclient.AuthSecurityEvent{
+		UserID:     rt.UserID,
+		Type:       SecurityEventTokenReuse,
+		FamilyID:   familyID,
+		Reason:     "rotated refresh token was presented again; token family revoked",
+		OccurredAt: time.Now().Format(time.RFC3339),
+	}
+	_ = s.repo.EnqueueEvent("auth.security", userKey(rt.UserID), event)
+}
+
 // generateSecureToken creates a cryptographically secure random string
 func generateSecureToken(length int) string {
 	bytes := make([]byte, length)
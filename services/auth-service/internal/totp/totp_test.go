@@ -0,0 +1,125 @@
+package totp
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of RFC 6238 Appendix B, "12345678901234567890",
+// base32-encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeMatchesRFC6238(t *testing.T) {
+	// The RFC lists 8-digit codes; 6-digit codes are their last 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("Code(%d): %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("Code(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	tests := []struct {
+		name   string
+		offset time.Duration
+		valid  bool
+	}{
+		{"current step", 0, true},
+		{"previous step", -Period, true},
+		{"next step", Period, true},
+		{"two steps behind", -2 * Period, false},
+		{"two steps ahead", 2 * Period, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codeTime := now.Add(tt.offset)
+			code, err := Code(rfcSecret, codeTime)
+			if err != nil {
+				t.Fatalf("Code: %v", err)
+			}
+
+			step, err := Validate(rfcSecret, code, now, 0)
+			if !tt.valid {
+				if !errors.Is(err, ErrInvalidCode) {
+					t.Errorf("Validate() error = %v, want ErrInvalidCode", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+			if step != Step(codeTime) {
+				t.Errorf("Validate() step = %d, want %d", step, Step(codeTime))
+			}
+		})
+	}
+}
+
+func TestValidateRejectsReplay(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, err := Code(rfcSecret, now)
+	if err != nil {
+		t.Fatalf("Code: %v", err)
+	}
+
+	lastStep, err := Validate(rfcSecret, code, now, 0)
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	// The same code, even a step later while it is still within the skew
+	for _, at := range []time.Time{now, now.Add(Period)} {
+		if _, err := Validate(rfcSecret, code, at, lastStep); !errors.Is(err, ErrInvalidCode) {
+			t.Errorf("replay at %v: error = %v, want ErrInvalidCode", at, err)
+		}
+	}
+
+	// A code from an earlier step that is still within the skew
+	earlier, err := Code(rfcSecret, now.Add(-Period))
+	if err != nil {
+		t.Fatalf("Code: %v", err)
+	}
+	if _, err := Validate(rfcSecret, earlier, now, lastStep); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("earlier code: error = %v, want ErrInvalidCode", err)
+	}
+
+	next, err := Code(rfcSecret, now.Add(Period))
+	if err != nil {
+		t.Fatalf("Code: %v", err)
+	}
+	if step, err := Validate(rfcSecret, next, now.Add(Period), lastStep); err != nil || step != lastStep+1 {
+		t.Errorf("next code: Validate() = %d, %v, want %d", step, err, lastStep+1)
+	}
+}
+
+func TestValidateRejectsMalformedInput(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	if _, err := Validate("not base32!", "005924", now, 0); !errors.Is(err, ErrInvalidSecret) {
+		t.Errorf("bad secret: error = %v, want ErrInvalidSecret", err)
+	}
+	if _, err := Validate(rfcSecret, "5924", now, 0); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("short code: error = %v, want ErrInvalidCode", err)
+	}
+	if _, err := Validate(rfcSecret, " 005924 ", now, 0); err != nil {
+		t.Errorf("code with spaces: %v", err)
+	}
+}
@@ -0,0 +1,465 @@
+// Package webauthn implements the relying party side of WebAuthn Level 2
+// (https://www.w3.org/TR/webauthn-2/) for passkey registration and login.
+//
+// It requests no attestation, so it does not check who made an
+// authenticator, only that registration and login ceremonies come from the
+// expected origin, answer the server's challenge and, for logins, are signed
+// by the registered key. ES256, EdDSA and RS256 keys are supported.
+//
+// Options and responses use the JSON forms of the browser API
+// (PublicKeyCredential.toJSON), with binary fields base64url-encoded. The
+// webauthntest package provides a software authenticator for tests.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidResponse   = errors.New("malformed WebAuthn response")
+	ErrChallengeMismatch = errors.New("WebAuthn response does not answer the challenge")
+	ErrOriginMismatch    = errors.New("WebAuthn response comes from an unexpected origin")
+	ErrRPIDMismatch      = errors.New("credential is scoped to another relying party")
+	ErrUserNotPresent    = errors.New("authenticator did not confirm user presence")
+	ErrUserNotVerified   = errors.New("authenticator did not verify the user")
+	ErrUnsupportedKey    = errors.New("unsupported credential public key")
+	ErrInvalidSignature  = errors.New("invalid WebAuthn signature")
+	ErrCloned            = errors.New("signature counter went backwards, the authenticator may have been cloned")
+)
+
+// User verification requirements
+const (
+	VerificationRequired    = "required"
+	VerificationPreferred   = "preferred"
+	VerificationDiscouraged = "discouraged"
+)
+
+// Client data types
+const (
+	ceremonyCreate = "webauthn.create"
+	ceremonyGet    = "webauthn.get"
+)
+
+// Authenticator data flags
+const (
+	flagUserPresent    = 0x01
+	flagUserVerified   = 0x04
+	flagBackupEligible = 0x08
+	flagBackedUp       = 0x10
+	flagAttestedData   = 0x40
+)
+
+const credentialType = "public-key"
+
+// Config describes the relying party
+type Config struct {
+	RPID             string        // Domain credentials are scoped to, e.g. "example.com"
+	RPName           string        // Shown by authenticators
+	Origins          []string      // Origins ceremonies may come from, e.g. "https://app.example.com"
+	UserVerification string        // Defaults to VerificationPreferred
+	Timeout          time.Duration // Hint for how long the browser waits for the user
+}
+
+// RelyingParty creates ceremony options and verifies the responses
+type RelyingParty struct {
+	cfg      Config
+	rpIDHash [32]byte
+}
+
+// New creates a RelyingParty
+func New(cfg Config) (*RelyingParty, error) {
+	if cfg.RPID == "" || len(cfg.Origins) == 0 {
+		return nil, errors.New("webauthn: RP ID and at least one origin are required")
+	}
+	if cfg.RPName == "" {
+		cfg.RPName = cfg.RPID
+	}
+	if cfg.UserVerification == "" {
+		cfg.UserVerification = VerificationPreferred
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 5 * time.Minute
+	}
+	return &RelyingParty{cfg: cfg, rpIDHash: sha256.Sum256([]byte(cfg.RPID))}, nil
+}
+
+// Base64URL is binary data encoded as unpadded base64url in JSON
+type Base64URL []byte
+
+func (b Base64URL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Base64URL) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
+
+// User is the account a credential is created for. ID is the user handle
+// stored on the authenticator; it must not contain personal information.
+type User struct {
+	ID          []byte
+	Name        string
+	DisplayName string
+}
+
+// CredentialDescriptor identifies a registered credential
+type CredentialDescriptor struct {
+	Type       string    `json:"type"`
+	ID         Base64URL `json:"id"`
+	Transports []string  `json:"transports,omitempty"`
+}
+
+// NewCredentialDescriptor describes the credential with the given ID
+func NewCredentialDescriptor(id []byte, transports []string) CredentialDescriptor {
+	return CredentialDescriptor{Type: credentialType, ID: id, Transports: transports}
+}
+
+// CredentialParameter is a kind of credential the relying party accepts
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"` // COSE algorithm identifier
+}
+
+// CreationOptions are passed to navigator.credentials.create to register a
+// credential (PublicKeyCredentialCreationOptions)
+type CreationOptions struct {
+	RP struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User struct {
+		ID          Base64URL `json:"id"`
+		Name        string    `json:"name"`
+		DisplayName string    `json:"displayName"`
+	} `json:"user"`
+	Challenge              Base64URL              `json:"challenge"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+	Attestation string `json:"attestation"`
+}
+
+// RequestOptions are passed to navigator.credentials.get to log in
+// (PublicKeyCredentialRequestOptions)
+type RequestOptions struct {
+	Challenge        Base64URL              `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// RegistrationResponse is the credential returned by
+// navigator.credentials.create
+type RegistrationResponse struct {
+	ID       string    `json:"id"`
+	RawID    Base64URL `json:"rawId"`
+	Type     string    `json:"type"`
+	Response struct {
+		ClientDataJSON    Base64URL `json:"clientDataJSON"`
+		AttestationObject Base64URL `json:"attestationObject"`
+		Transports        []string  `json:"transports,omitempty"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the credential returned by navigator.credentials.get
+type AssertionResponse struct {
+	ID       string    `json:"id"`
+	RawID    Base64URL `json:"rawId"`
+	Type     string    `json:"type"`
+	Response struct {
+		ClientDataJSON    Base64URL `json:"clientDataJSON"`
+		AuthenticatorData Base64URL `json:"authenticatorData"`
+		Signature         Base64URL `json:"signature"`
+		UserHandle        Base64URL `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+// Credential is a newly registered credential to store for the user
+type Credential struct {
+	ID             []byte
+	PublicKey      []byte // COSE_Key, passed back to VerifyAssertion
+	SignCount      uint32
+	Transports     []string
+	UserVerified   bool
+	BackupEligible bool // A passkey that may be synced between devices
+	BackedUp       bool
+}
+
+// Assertion is the result of a successful login ceremony
+type Assertion struct {
+	SignCount    uint32 // Store it for the next VerifyAssertion
+	UserVerified bool   // The authenticator checked a PIN or biometric
+	BackedUp     bool
+}
+
+// CreationOptions returns the options for registering a credential for user.
+// Credentials in exclude are already registered and will not be created
+// again on the same authenticator. The credential is discoverable, so it can
+// be used to log in without entering a username.
+func (rp *RelyingParty) CreationOptions(user User, challenge []byte, exclude []CredentialDescriptor) *CreationOptions {
+	options := &CreationOptions{
+		Challenge:          challenge,
+		Timeout:            rp.cfg.Timeout.Milliseconds(),
+		ExcludeCredentials: exclude,
+		Attestation:        "none",
+	}
+	options.RP.ID = rp.cfg.RPID
+	options.RP.Name = rp.cfg.RPName
+	options.User.ID = user.ID
+	options.User.Name = user.Name
+	options.User.DisplayName = user.DisplayName
+	for _, alg := range []int{AlgES256, AlgEdDSA, AlgRS256} {
+		options.PubKeyCredParams = append(options.PubKeyCredParams, CredentialParameter{Type: credentialType, Alg: alg})
+	}
+	options.AuthenticatorSelection.ResidentKey = "required"
+	options.AuthenticatorSelection.UserVerification = rp.cfg.UserVerification
+	if options.ExcludeCredentials == nil {
+		options.ExcludeCredentials = []CredentialDescriptor{}
+	}
+	return options
+}
+
+// RequestOptions returns the options for a login ceremony. With no allowed
+// credentials the user picks any passkey they hold for this relying party.
+func (rp *RelyingParty) RequestOptions(challenge []byte, allow []CredentialDescriptor) *RequestOptions {
+	if allow == nil {
+		allow = []CredentialDescriptor{}
+	}
+	return &RequestOptions{
+		Challenge:        challenge,
+		Timeout:          rp.cfg.Timeout.Milliseconds(),
+		RPID:             rp.cfg.RPID,
+		AllowCredentials: allow,
+		UserVerification: rp.cfg.UserVerification,
+	}
+}
+
+// Challenge returns the challenge the response claims to answer, so the
+// caller can look up the ceremony it belongs to. It is not verified yet.
+func (r *RegistrationResponse) Challenge() ([]byte, error) {
+	return claimedChallenge(r.Response.ClientDataJSON)
+}
+
+// Challenge returns the challenge the response claims to answer, so the
+// caller can look up the ceremony it belongs to. It is not verified yet.
+func (r *AssertionResponse) Challenge() ([]byte, error) {
+	return claimedChallenge(r.Response.ClientDataJSON)
+}
+
+// VerifyRegistration checks a registration ceremony answering challenge and
+// returns the new credential (WebAuthn section 7.1)
+func (rp *RelyingParty) VerifyRegistration(resp *RegistrationResponse, challenge []byte) (*Credential, error) {
+	if resp.Type != credentialType {
+		return nil, ErrInvalidResponse
+	}
+	if err := rp.verifyClientData(resp.Response.ClientDataJSON, ceremonyCreate, challenge); err != nil {
+		return nil, err
+	}
+
+	value, rest, err := decodeCBOR(resp.Response.AttestationObject)
+	if err != nil || len(rest) > 0 {
+		return nil, ErrInvalidResponse
+	}
+	attestation, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrInvalidResponse
+	}
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, ErrInvalidResponse
+	}
+
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthenticatorData(authData); err != nil {
+		return nil, err
+	}
+	if authData.flags&flagAttestedData == 0 || len(authData.credentialID) == 0 {
+		return nil, ErrInvalidResponse
+	}
+	if len(resp.RawID) > 0 && !bytes.Equal(resp.RawID, authData.credentialID) {
+		return nil, ErrInvalidResponse
+	}
+	if _, err := parsePublicKey(authData.publicKey); err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:             authData.credentialID,
+		PublicKey:      authData.publicKey,
+		SignCount:      authData.signCount,
+		Transports:     resp.Response.Transports,
+		UserVerified:   authData.flags&flagUserVerified != 0,
+		BackupEligible: authData.flags&flagBackupEligible != 0,
+		BackedUp:       authData.flags&flagBackedUp != 0,
+	}, nil
+}
+
+// VerifyAssertion checks a login ceremony answering challenge, signed by the
+// credential with the given COSE public key. signCount is the counter stored
+// after the credential's last use. (WebAuthn section 7.2)
+func (rp *RelyingParty) VerifyAssertion(resp *AssertionResponse, challenge, publicKeyCOSE []byte, signCount uint32) (*Assertion, error) {
+	if resp.Type != credentialType {
+		return nil, ErrInvalidResponse
+	}
+	if err := rp.verifyClientData(resp.Response.ClientDataJSON, ceremonyGet, challenge); err != nil {
+		return nil, err
+	}
+
+	authData, err := parseAuthenticatorData(resp.Response.AuthenticatorData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthenticatorData(authData); err != nil {
+		return nil, err
+	}
+
+	key, err := parsePublicKey(publicKeyCOSE)
+	if err != nil {
+		return nil, err
+	}
+	clientDataHash := sha256.Sum256(resp.Response.ClientDataJSON)
+	signed := append(append([]byte(nil), resp.Response.AuthenticatorData...), clientDataHash[:]...)
+	if !key.verify(signed, resp.Response.Signature) {
+		return nil, ErrInvalidSignature
+	}
+
+	// Authenticators without a counter, such as most synced passkeys, always
+	// report zero
+	if (authData.signCount != 0 || signCount != 0) && authData.signCount <= signCount {
+		return nil, ErrCloned
+	}
+
+	return &Assertion{
+		SignCount:    authData.signCount,
+		UserVerified: authData.flags&flagUserVerified != 0,
+		BackedUp:     authData.flags&flagBackedUp != 0,
+	}, nil
+}
+
+// clientData is the JSON the browser signs over (CollectedClientData)
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+func claimedChallenge(raw []byte) ([]byte, error) {
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, ErrInvalidResponse
+	}
+	challenge, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(data.Challenge, "="))
+	if err != nil || len(challenge) == 0 {
+		return nil, ErrInvalidResponse
+	}
+	return challenge, nil
+}
+
+func (rp *RelyingParty) verifyClientData(raw []byte, ceremony string, challenge []byte) error {
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil || data.Type != ceremony {
+		return ErrInvalidResponse
+	}
+
+	claimed, err := claimedChallenge(raw)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(claimed, challenge) != 1 {
+		return ErrChallengeMismatch
+	}
+
+	if data.CrossOrigin {
+		return ErrOriginMismatch
+	}
+	for _, origin := range rp.cfg.Origins {
+		if data.Origin == origin {
+			return nil
+		}
+	}
+	return ErrOriginMismatch
+}
+
+func (rp *RelyingParty) verifyAuthenticatorData(authData *authenticatorData) error {
+	if subtle.ConstantTimeCompare(authData.rpIDHash, rp.rpIDHash[:]) != 1 {
+		return ErrRPIDMismatch
+	}
+	if authData.flags&flagUserPresent == 0 {
+		return ErrUserNotPresent
+	}
+	if rp.cfg.UserVerification == VerificationRequired && authData.flags&flagUserVerified == 0 {
+		return ErrUserNotVerified
+	}
+	return nil
+}
+
+// authenticatorData is the binary structure of WebAuthn section 6.1
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, ErrInvalidResponse
+	}
+
+	authData := &authenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	if authData.flags&flagAttestedData == 0 {
+		return authData, nil
+	}
+
+	// AAGUID (16 bytes), credential ID length (2 bytes), credential ID and
+	// the COSE public key, possibly followed by extensions
+	rest := data[37:]
+	if len(rest) < 18 {
+		return nil, ErrInvalidResponse
+	}
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if idLength == 0 || idLength > 1023 || len(rest) < idLength {
+		return nil, ErrInvalidResponse
+	}
+	authData.credentialID = rest[:idLength]
+	rest = rest[idLength:]
+
+	_, extensions, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, ErrInvalidResponse
+	}
+	authData.publicKey = rest[:len(rest)-len(extensions)]
+	return authData, nil
+}
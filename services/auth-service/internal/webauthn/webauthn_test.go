@@ -0,0 +1,225 @@
+package webauthn_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/my-username/billion-user-app/services/auth-service/internal/webauthn"
+	"github.com/my-username/billion-user-app/services/auth-service/internal/webauthn/webauthntest"
+)
+
+const origin = "https://app.example.com"
+
+var user = webauthn.User{ID: []byte("user-42"), Name: "user@example.com", DisplayName: "User"}
+
+func newRelyingParty(t *testing.T) *webauthn.RelyingParty {
+	t.Helper()
+	rp, err := webauthn.New(webauthn.Config{RPID: "example.com", Origins: []string{origin}})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return rp
+}
+
+// register adds a passkey for user to authenticator
+func register(t *testing.T, rp *webauthn.RelyingParty, authenticator *webauthntest.Authenticator) *webauthn.Credential {
+	t.Helper()
+	challenge := []byte("registration-challenge")
+	resp, err := authenticator.Register(rp.CreationOptions(user, challenge, nil))
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	credential, err := rp.VerifyRegistration(resp, challenge)
+	if err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+	return credential
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	rp := newRelyingParty(t)
+	authenticator := webauthntest.New(origin)
+	credential := register(t, rp, authenticator)
+
+	if len(credential.ID) == 0 || len(credential.PublicKey) == 0 {
+		t.Fatalf("credential = %+v, want an ID and public key", credential)
+	}
+	if !credential.UserVerified || !credential.BackedUp {
+		t.Errorf("credential = %+v, want user verified and backed up", credential)
+	}
+
+	signCount := credential.SignCount
+	for i := 0; i < 2; i++ {
+		challenge := []byte("login-challenge")
+		resp, err := authenticator.Login(rp.RequestOptions(challenge, nil))
+		if err != nil {
+			t.Fatalf("Login: %v", err)
+		}
+		if got, err := resp.Challenge(); err != nil || string(got) != string(challenge) {
+			t.Errorf("Challenge() = %q, %v, want %q", got, err, challenge)
+		}
+		if string(resp.Response.UserHandle) != string(user.ID) {
+			t.Errorf("user handle = %q, want %q", resp.Response.UserHandle, user.ID)
+		}
+
+		assertion, err := rp.VerifyAssertion(resp, challenge, credential.PublicKey, signCount)
+		if err != nil {
+			t.Fatalf("VerifyAssertion: %v", err)
+		}
+		if assertion.SignCount <= signCount {
+			t.Errorf("sign count = %d, want more than %d", assertion.SignCount, signCount)
+		}
+		if !assertion.UserVerified {
+			t.Error("assertion not user verified")
+		}
+		signCount = assertion.SignCount
+	}
+}
+
+func TestVerifyRegistrationRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		origin  string
+		rpID    string
+		answer  []byte
+		wantErr error
+	}{
+		{"challenge mismatch", origin, "example.com", []byte("another-challenge"), webauthn.ErrChallengeMismatch},
+		{"wrong origin", "https://evil.example", "example.com", nil, webauthn.ErrOriginMismatch},
+		{"rpIdHash mismatch", origin, "evil.example", nil, webauthn.ErrRPIDMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp := newRelyingParty(t)
+			challenge := []byte("registration-challenge")
+			options := rp.CreationOptions(user, challenge, nil)
+			options.RP.ID = tt.rpID
+			if tt.answer != nil {
+				options.Challenge = tt.answer
+			}
+
+			resp, err := webauthntest.New(tt.origin).Register(options)
+			if err != nil {
+				t.Fatalf("Register: %v", err)
+			}
+			if _, err := rp.VerifyRegistration(resp, challenge); !errors.Is(err, tt.wantErr) {
+				t.Errorf("VerifyRegistration() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertionRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*webauthntest.Authenticator, *webauthn.RequestOptions)
+		wantErr error
+	}{
+		{
+			name: "challenge mismatch",
+			modify: func(_ *webauthntest.Authenticator, options *webauthn.RequestOptions) {
+				options.Challenge = []byte("another-challenge")
+			},
+			wantErr: webauthn.ErrChallengeMismatch,
+		},
+		{
+			name: "wrong origin",
+			modify: func(authenticator *webauthntest.Authenticator, _ *webauthn.RequestOptions) {
+				authenticator.Origin = "https://evil.example"
+			},
+			wantErr: webauthn.ErrOriginMismatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp := newRelyingParty(t)
+			authenticator := webauthntest.New(origin)
+			credential := register(t, rp, authenticator)
+
+			challenge := []byte("login-challenge")
+			options := rp.RequestOptions(challenge, nil)
+			tt.modify(authenticator, options)
+			resp, err := authenticator.Login(options)
+			if err != nil {
+				t.Fatalf("Login: %v", err)
+			}
+			if _, err := rp.VerifyAssertion(resp, challenge, credential.PublicKey, credential.SignCount); !errors.Is(err, tt.wantErr) {
+				t.Errorf("VerifyAssertion() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertionRejectsOtherRelyingParty(t *testing.T) {
+	rp := newRelyingParty(t)
+	other, err := webauthn.New(webauthn.Config{RPID: "evil.example", Origins: []string{origin}})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	// The credential is scoped to evil.example, whose hash is in the
+	// authenticator data it signs
+	authenticator := webauthntest.New(origin)
+	challenge := []byte("registration-challenge")
+	resp, err := authenticator.Register(other.CreationOptions(user, challenge, nil))
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	credential, err := other.VerifyRegistration(resp, challenge)
+	if err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+
+	challenge = []byte("login-challenge")
+	assertion, err := authenticator.Login(other.RequestOptions(challenge, nil))
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if _, err := rp.VerifyAssertion(assertion, challenge, credential.PublicKey, credential.SignCount); !errors.Is(err, webauthn.ErrRPIDMismatch) {
+		t.Errorf("VerifyAssertion() error = %v, want ErrRPIDMismatch", err)
+	}
+}
+
+func TestVerifyAssertionSignCount(t *testing.T) {
+	rp := newRelyingParty(t)
+	authenticator := webauthntest.New(origin)
+	credential := register(t, rp, authenticator)
+
+	login := func() *webauthn.AssertionResponse {
+		t.Helper()
+		resp, err := authenticator.Login(rp.RequestOptions([]byte("login-challenge"), nil))
+		if err != nil {
+			t.Fatalf("Login: %v", err)
+		}
+		return resp
+	}
+
+	// The authenticator reports 1, so a stored counter of 5 means another
+	// copy of the key has been used since
+	if _, err := rp.VerifyAssertion(login(), []byte("login-challenge"), credential.PublicKey, 5); !errors.Is(err, webauthn.ErrCloned) {
+		t.Errorf("counter went backwards: error = %v, want ErrCloned", err)
+	}
+	if _, err := rp.VerifyAssertion(login(), []byte("login-challenge"), credential.PublicKey, 2); !errors.Is(err, webauthn.ErrCloned) {
+		t.Errorf("counter repeated: error = %v, want ErrCloned", err)
+	}
+}
+
+func TestVerifyAssertionWithoutSignCount(t *testing.T) {
+	rp := newRelyingParty(t)
+	authenticator := webauthntest.New(origin)
+	authenticator.SkipSignCount = true
+	credential := register(t, rp, authenticator)
+
+	// Authenticators without a counter always report zero
+	for i := 0; i < 2; i++ {
+		resp, err := authenticator.Login(rp.RequestOptions([]byte("login-challenge"), nil))
+		if err != nil {
+			t.Fatalf("Login: %v", err)
+		}
+		if _, err := rp.VerifyAssertion(resp, []byte("login-challenge"), credential.PublicKey, 0); err != nil {
+			t.Errorf("VerifyAssertion: %v", err)
+		}
+	}
+}
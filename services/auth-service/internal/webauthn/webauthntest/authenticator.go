@@ -0,0 +1,251 @@
+// Package webauthntest provides a software WebAuthn authenticator, so passkey
+// registration and login can be exercised in tests without a browser or a
+// security key.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/my-username/billion-user-app/services/auth-service/internal/webauthn"
+)
+
+// ErrNoCredential is returned by Login when the authenticator holds no
+// credential the relying party allows
+var ErrNoCredential = errors.New("webauthntest: no matching credential")
+
+// Authenticator is a platform authenticator holding ES256 passkeys in memory.
+// It plays the browser's part too, so Origin is put in the client data it
+// signs. The exported fields can be changed to simulate misbehaving clients.
+type Authenticator struct {
+	Origin        string
+	UserVerified  bool // Report that the user entered a PIN or biometric
+	SkipSignCount bool // Always report a zero signature counter, like synced passkeys
+
+	credentials []*credential
+}
+
+type credential struct {
+	id         []byte
+	rpID       string
+	userHandle []byte
+	key        *ecdsa.PrivateKey
+	signCount  uint32
+}
+
+// New creates an authenticator used from origin that verifies the user
+func New(origin string) *Authenticator {
+	return &Authenticator{Origin: origin, UserVerified: true}
+}
+
+// Register creates a credential as navigator.credentials.create would
+func (a *Authenticator) Register(options *webauthn.CreationOptions) (*webauthn.RegistrationResponse, error) {
+	for _, excluded := range options.ExcludeCredentials {
+		if a.find(options.RP.ID, excluded.ID) != nil {
+			return nil, errors.New("webauthntest: credential already registered")
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	cred := &credential{id: randomBytes(32), rpID: options.RP.ID, userHandle: options.User.ID, key: key}
+
+	clientDataJSON, err := a.clientData("webauthn.create", options.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	// AAGUID (all zero for "none" attestation), credential ID length, ID
+	// and public key
+	attested := make([]byte, 16, 16+2+len(cred.id))
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(cred.id)))
+	attested = append(attested, cred.id...)
+	attested = append(attested, coseKey(&key.PublicKey)...)
+
+	authData := a.authenticatorData(cred, 0x40, attested)
+	attestation := encodeMap([]mapEntry{
+		{encodeText("fmt"), encodeText("none")},
+		{encodeText("attStmt"), encodeMap(nil)},
+		{encodeText("authData"), encodeBytes(authData)},
+	})
+
+	a.credentials = append(a.credentials, cred)
+
+	resp := &webauthn.RegistrationResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(cred.id),
+		RawID: cred.id,
+		Type:  "public-key",
+	}
+	resp.Response.ClientDataJSON = clientDataJSON
+	resp.Response.AttestationObject = attestation
+	resp.Response.Transports = []string{"internal"}
+	return resp, nil
+}
+
+// Login signs in with a credential as navigator.credentials.get would. With
+// no allowed credentials in options the most recently registered one for
+// the relying party is used.
+func (a *Authenticator) Login(options *webauthn.RequestOptions) (*webauthn.AssertionResponse, error) {
+	var cred *credential
+	if len(options.AllowCredentials) == 0 {
+		for i := len(a.credentials) - 1; i >= 0 && cred == nil; i-- {
+			if a.credentials[i].rpID == options.RPID {
+				cred = a.credentials[i]
+			}
+		}
+	}
+	for _, allowed := range options.AllowCredentials {
+		if cred = a.find(options.RPID, allowed.ID); cred != nil {
+			break
+		}
+	}
+	if cred == nil {
+		return nil, ErrNoCredential
+	}
+
+	clientDataJSON, err := a.clientData("webauthn.get", options.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	if !a.SkipSignCount {
+		cred.signCount++
+	}
+	authData := a.authenticatorData(cred, 0, nil)
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, cred.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &webauthn.AssertionResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(cred.id),
+		RawID: cred.id,
+		Type:  "public-key",
+	}
+	resp.Response.ClientDataJSON = clientDataJSON
+	resp.Response.AuthenticatorData = authData
+	resp.Response.Signature = signature
+	resp.Response.UserHandle = cred.userHandle
+	return resp, nil
+}
+
+func (a *Authenticator) find(rpID string, id []byte) *credential {
+	for _, cred := range a.credentials {
+		if cred.rpID == rpID && string(cred.id) == string(id) {
+			return cred
+		}
+	}
+	return nil
+}
+
+func (a *Authenticator) clientData(ceremony string, challenge []byte) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"type":        ceremony,
+		"challenge":   base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+}
+
+func (a *Authenticator) authenticatorData(cred *credential, flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(cred.rpID))
+
+	// User present, and backup eligible and backed up like a synced passkey
+	flags |= 0x01 | 0x08 | 0x10
+	if a.UserVerified {
+		flags |= 0x04
+	}
+
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, cred.signCount)
+	return append(data, attested...)
+}
+
+// coseKey encodes an ES256 public key as a COSE_Key
+func coseKey(key *ecdsa.PublicKey) []byte {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+
+	return encodeMap([]mapEntry{
+		{encodeInt(1), encodeInt(2)},    // kty: EC2
+		{encodeInt(3), encodeInt(-7)},   // alg: ES256
+		{encodeInt(-1), encodeInt(1)},   // crv: P-256
+		{encodeInt(-2), encodeBytes(x)}, // x
+		{encodeInt(-3), encodeBytes(y)}, // y
+	})
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return b
+}
+
+// A minimal CBOR encoder for the structures above, using the canonical
+// form CTAP2 authenticators produce
+
+type mapEntry struct {
+	key, value []byte
+}
+
+func encodeHead(major byte, arg uint64) []byte {
+	switch {
+	case arg < 24:
+		return []byte{major<<5 | byte(arg)}
+	case arg <= 0xff:
+		return []byte{major<<5 | 24, byte(arg)}
+	case arg <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(arg))
+	case arg <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(arg))
+	}
+	return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, arg)
+}
+
+func encodeInt(v int64) []byte {
+	if v < 0 {
+		return encodeHead(1, uint64(-1-v))
+	}
+	return encodeHead(0, uint64(v))
+}
+
+func encodeBytes(b []byte) []byte {
+	return append(encodeHead(2, uint64(len(b))), b...)
+}
+
+func encodeText(s string) []byte {
+	return append(encodeHead(3, uint64(len(s))), s...)
+}
+
+// encodeMap sorts keys by their encoding, shorter first, as canonical CBOR
+// requires
+func encodeMap(entries []mapEntry) []byte {
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i].key, entries[j].key
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return string(a) < string(b)
+	})
+
+	out := encodeHead(5, uint64(len(entries)))
+	for _, entry := range entries {
+		out = append(out, entry.key...)
+		out = append(out, entry.value...)
+	}
+	return out
+}
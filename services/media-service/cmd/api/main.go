@@ -1,21 +1,24 @@
 package main
 
 import (
+	"context"
 	"log"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
-	"github.com/gofiber/fiber/v2/middleware/logger"
+	fiberlogger "github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/my-username/billion-user-app/pkg/config"
 	"github.com/my-username/billion-user-app/pkg/database"
 	"github.com/my-username/billion-user-app/pkg/jwtutils"
 	"github.com/my-username/billion-user-app/pkg/logger"
+	"github.com/my-username/billion-user-app/pkg/revocation"
 	"github.com/my-username/billion-user-app/services/media-service/internal/domain"
 	"github.com/my-username/billion-user-app/services/media-service/internal/handler"
 	"github.com/my-username/billion-user-app/services/media-service/internal/repository"
 	"github.com/my-username/billion-user-app/services/media-service/internal/service"
+	"github.com/redis/go-redis/v9"
 )
 
 func main() {
@@ -36,7 +39,31 @@ func main() {
 		appLogger.Fatal().Err(err).Msg("Failed to migrate database")
 	}
 
-	jwtManager := jwtutils.NewJWTManager(cfg.JWTSecret, 15*time.Minute)
+	// Verify tokens with auth-service's published keys when available,
+	// falling back to the legacy shared secret
+	var jwtManager *jwtutils.JWTManager
+	if cfg.JWKSURL != "" {
+		jwtManager = jwtutils.NewVerifyingJWTManager(jwtutils.NewRemoteKeySet(cfg.JWKSURL))
+	} else {
+		jwtManager = jwtutils.NewJWTManager(cfg.JWTSecret, 15*time.Minute)
+	}
+
+	// Service tokens must be addressed to us
+	jwtManager.SetAudience("media-service")
+
+	// Reject access tokens revoked by auth-service before they expire
+	redisClient := redis.NewClient(&redis.Options{Addr: cfg.RedisAddress, ContextTimeoutEnabled: true})
+	if err := redisClient.Ping(context.Background()).Err(); err != nil {
+		appLogger.Warn().Err(err).Msg("Failed to connect to Redis, revoked tokens are accepted until it is reachable")
+	}
+	revocations := revocation.NewStore(redisClient, 15*time.Minute)
+	go revocations.Listen(context.Background())
+	jwtManager.SetRevocationChecker(revocations)
+
+	// Accept API keys by exchanging them for access tokens at auth-service
+	if cfg.APIKeyExchangeURL != "" {
+		jwtManager.SetAPIKeyExchanger(jwtutils.NewRemoteAPIKeyExchanger(cfg.APIKeyExchangeURL))
+	}
 
 	mediaRepo := repository.NewMediaRepository(db)
 	mediaService := service.NewMediaService(mediaRepo)
@@ -58,7 +85,7 @@ func main() {
 		AllowMethods: "GET,POST,PUT,DELETE,OPTIONS",
 		AllowHeaders: "Origin,Content-Type,Accept,Authorization",
 	}))
-	app.Use(logger.New())
+	app.Use(fiberlogger.New())
 
 	app.Get("/health", func(c *fiber.Ctx) error {
 		return c.JSON(fiber.Map{"status": "ok", "service": "media-service"})
@@ -67,7 +94,7 @@ func main() {
 	api := app.Group("/api/v1")
 
 	// Protected routes (all media operations require auth)
-	protected := api.Group("/media", handler.JWTMiddleware(jwtManager))
+	protected := api.Group("/media", handler.JWTMiddleware(jwtManager), handler.RequireScope("media"))
 	protected.Post("/", mediaHandler.CreateMedia)
 	protected.Get("/", mediaHandler.GetMyMedia)
 	protected.Get("/:id", mediaHandler.GetMedia)
@@ -8,11 +8,15 @@ require (
 	github.com/my-username/billion-user-app/pkg/database v0.0.0
 	github.com/my-username/billion-user-app/pkg/jwtutils v0.0.0
 	github.com/my-username/billion-user-app/pkg/logger v0.0.0
+	github.com/my-username/billion-user-app/pkg/revocation v0.0.0
+	github.com/redis/go-redis/v9 v9.5.1
 	gorm.io/gorm v1.25.5
 )
 
 require (
 	github.com/andybalholm/brotli v1.0.5 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 	github.com/golang-jwt/jwt/v5 v5.2.0 // indirect
 	github.com/google/uuid v1.5.0 // indirect
 	github.com/jackc/pgpassfile v1.0.0 // indirect
@@ -27,7 +31,6 @@ require (
 	github.com/mattn/go-runewidth v0.0.15 // indirect
 	github.com/rivo/uniseg v0.2.0 // indirect
 	github.com/rs/zerolog v1.32.0 // indirect
-	github.com/stretchr/testify v1.8.4 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/valyala/fasthttp v1.51.0 // indirect
 	github.com/valyala/tcplisten v1.0.0 // indirect
@@ -42,4 +45,5 @@ replace (
 	github.com/my-username/billion-user-app/pkg/database => ../../pkg/database
 	github.com/my-username/billion-user-app/pkg/jwtutils => ../../pkg/jwtutils
 	github.com/my-username/billion-user-app/pkg/logger => ../../pkg/logger
+	github.com/my-username/billion-user-app/pkg/revocation => ../../pkg/revocation
 )
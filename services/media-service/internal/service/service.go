@@ -3,6 +3,7 @@ package service
 import (
 	"errors"
 
+	"github.com/my-username/billion-user-app/pkg/jwtutils"
 	"github.com/my-username/billion-user-app/services/media-service/internal/domain"
 	"github.com/my-username/billion-user-app/services/media-service/internal/repository"
 )
@@ -17,7 +18,7 @@ type MediaService interface {
 	CreateMedia(media *domain.Media) (*domain.Media, error)
 	GetMediaByID(id uint64) (*domain.Media, error)
 	GetMediaByUserID(userID uint64, offset, limit int) ([]*domain.Media, error)
-	DeleteMedia(id uint64, requesterID uint64) error
+	DeleteMedia(id uint64, requester *jwtutils.Claims) error
 	GeneratePresignedURL(bucket, key string, expiresIn int) (string, error)
 }
 
@@ -46,13 +47,13 @@ func (s *mediaService) GetMediaByUserID(userID uint64, offset, limit int) ([]*do
 	return s.repo.GetByUserID(userID, offset, limit)
 }
 
-func (s *mediaService) DeleteMedia(id uint64, requesterID uint64) error {
+func (s *mediaService) DeleteMedia(id uint64, requester *jwtutils.Claims) error {
 	media, err := s.repo.GetByID(id)
 	if err != nil {
 		return err
 	}
 
-	if media.UserID != requesterID {
+	if !requester.CanActOn(media.UserID, jwtutils.PermManageMedia) {
 		return ErrUnauthorized
 	}
 
@@ -1,23 +1,27 @@
 package main
 
 import (
+	"context"
 	"log"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
-	"github.com/gofiber/fiber/v2/middleware/logger"
+	fiberlogger "github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/my-username/billion-user-app/pkg/config"
 	"github.com/my-username/billion-user-app/pkg/database"
 	"github.com/my-username/billion-user-app/pkg/jwtutils"
-	"github.com/my-username/billion-user-app/pkg/kafkaclient"
 	"github.com/my-username/billion-user-app/pkg/logger"
+	"github.com/my-username/billion-user-app/pkg/outbox"
+	"github.com/my-username/billion-user-app/pkg/revocation"
 	"github.com/my-username/billion-user-app/services/product-service/internal/domain"
 	"github.com/my-username/billion-user-app/services/product-service/internal/handler"
 	"github.com/my-username/billion-user-app/services/product-service/internal/repository"
 	"github.com/my-username/billion-user-app/services/product-service/internal/service"
+	"github.com/redis/go-redis/v9"
 )
 
 func main() {
@@ -34,23 +38,41 @@ func main() {
 		appLogger.Fatal().Err(err).Msg("Failed to connect to database")
 	}
 
-	if err := db.AutoMigrate(&domain.Product{}); err != nil {
+	if err := db.AutoMigrate(&domain.Product{}, &outbox.Event{}); err != nil {
 		appLogger.Fatal().Err(err).Msg("Failed to migrate database")
 	}
 
-	var kafkaClient *kafkaclient.Client
-	brokers := strings.Split(cfg.KafkaBrokers, ",")
-	if len(brokers) > 0 && brokers[0] != "" {
-		kafkaClient, err = kafkaclient.NewClient(brokers)
-		if err != nil {
-			appLogger.Warn().Err(err).Msg("Failed to connect to Kafka, continuing without events")
-		}
+	// Publish the events written to the outbox
+	outbox.Start(context.Background(), db, strings.Split(cfg.KafkaBrokers, ","), filepath.Join(cfg.EventBusDir, "product-service"))
+
+	// Verify tokens with auth-service's published keys when available,
+	// falling back to the legacy shared secret
+	var jwtManager *jwtutils.JWTManager
+	if cfg.JWKSURL != "" {
+		jwtManager = jwtutils.NewVerifyingJWTManager(jwtutils.NewRemoteKeySet(cfg.JWKSURL))
+	} else {
+		jwtManager = jwtutils.NewJWTManager(cfg.JWTSecret, 15*time.Minute)
 	}
 
-	jwtManager := jwtutils.NewJWTManager(cfg.JWTSecret, 15*time.Minute)
+	// Service tokens must be addressed to us
+	jwtManager.SetAudience("product-service")
+
+	// Reject access tokens revoked by auth-service before they expire
+	redisClient := redis.NewClient(&redis.Options{Addr: cfg.RedisAddress, ContextTimeoutEnabled: true})
+	if err := redisClient.Ping(context.Background()).Err(); err != nil {
+		appLogger.Warn().Err(err).Msg("Failed to connect to Redis, revoked tokens are accepted until it is reachable")
+	}
+	revocations := revocation.NewStore(redisClient, 15*time.Minute)
+	go revocations.Listen(context.Background())
+	jwtManager.SetRevocationChecker(revocations)
+
+	// Accept API keys by exchanging them for access tokens at auth-service
+	if cfg.APIKeyExchangeURL != "" {
+		jwtManager.SetAPIKeyExchanger(jwtutils.NewRemoteAPIKeyExchanger(cfg.APIKeyExchangeURL))
+	}
 
 	productRepo := repository.NewProductRepository(db)
-	productService := service.NewProductService(productRepo, kafkaClient)
+	productService := service.NewProductService(productRepo)
 	productHandler := handler.NewProductHandler(productService)
 
 	app := fiber.New(fiber.Config{
@@ -69,7 +91,7 @@ func main() {
 		AllowMethods: "GET,POST,PUT,DELETE,OPTIONS",
 		AllowHeaders: "Origin,Content-Type,Accept,Authorization",
 	}))
-	app.Use(logger.New())
+	app.Use(fiberlogger.New())
 
 	app.Get("/health", func(c *fiber.Ctx) error {
 		return c.JSON(fiber.Map{"status": "ok", "service": "product-service"})
@@ -84,7 +106,7 @@ func main() {
 	api.Get("/products/category/:category", productHandler.GetProductsByCategory)
 
 	// Protected routes
-	protected := api.Group("/products", handler.JWTMiddleware(jwtManager))
+	protected := api.Group("/products", handler.JWTMiddleware(jwtManager), handler.RequireScope("products"))
 	protected.Post("/", productHandler.CreateProduct)
 	protected.Put("/:id", productHandler.UpdateProduct)
 	protected.Delete("/:id", productHandler.DeleteProduct)
@@ -132,7 +132,7 @@ func (h *ProductHandler) UpdateProduct(c *fiber.Ctx) error {
 		ImageURL:    req.ImageURL,
 	}
 
-	product, err := h.productService.UpdateProduct(id, updates, claims.UserID)
+	product, err := h.productService.UpdateProduct(id, updates, claims)
 	if err != nil {
 		if err == service.ErrProductNotFound {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
@@ -168,7 +168,7 @@ func (h *ProductHandler) DeleteProduct(c *fiber.Ctx) error {
 		})
 	}
 
-	if err := h.productService.DeleteProduct(id, claims.UserID); err != nil {
+	if err := h.productService.DeleteProduct(id, claims); err != nil {
 		if err == service.ErrProductNotFound {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 				"error": "Product not found",
@@ -265,7 +265,8 @@ func (h *ProductHandler) GetProductsByCategory(c *fiber.Ctx) error {
 	})
 }
 
-// JWTMiddleware validates JWT tokens
+// JWTMiddleware validates JWT tokens. Administrators impersonating a user
+// may read but not change anything.
 func JWTMiddleware(jwtManager *jwtutils.JWTManager) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		authHeader := c.Get("Authorization")
@@ -291,8 +292,29 @@ func JWTMiddleware(jwtManager *jwtutils.JWTManager) fiber.Handler {
 			})
 		}
 
+		if !claims.AllowsMethod(c.Method()) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not allowed while impersonating a user",
+			})
+		}
+
 		c.Locals("claims", claims)
 		c.Locals("user_id", claims.UserID)
 		return c.Next()
 	}
 }
+
+// RequireScope rejects scoped tokens, such as those of API keys, that do not
+// grant access to resource for the request's HTTP method. It must run after
+// JWTMiddleware.
+func RequireScope(resource string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, ok := c.Locals("claims").(*jwtutils.Claims)
+		if !ok || !claims.HasScope(jwtutils.ScopeForMethod(resource, c.Method())) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Insufficient scope",
+			})
+		}
+		return c.Next()
+	}
+}
@@ -3,10 +3,15 @@ package repository
 import (
 	"errors"
 
+	"github.com/my-username/billion-user-app/pkg/kafkaclient"
+	"github.com/my-username/billion-user-app/pkg/outbox"
 	"github.com/my-username/billion-user-app/services/product-service/internal/domain"
 	"gorm.io/gorm"
 )
 
+// eventSource identifies this service as the producer of its events
+const eventSource = "product-service"
+
 var (
 	ErrProductNotFound = errors.New("product not found")
 )
@@ -20,6 +25,11 @@ type ProductRepository interface {
 	List(offset, limit int) ([]*domain.Product, error)
 	Search(query string, limit int) ([]*domain.Product, error)
 	GetByCategory(category string, offset, limit int) ([]*domain.Product, error)
+	// EnqueueEvent adds an event to the outbox; key is the aggregate's ID
+	EnqueueEvent(topic, key string, event interface{}) error
+	// Transaction runs fn with a repository whose operations commit together
+	// and whose events share a correlation ID
+	Transaction(fn func(tx ProductRepository) error) error
 }
 
 type productRepository struct {
@@ -31,6 +41,20 @@ func NewProductRepository(db *gorm.DB) ProductRepository {
 	return &productRepository{db: db}
 }
 
+func (r *productRepository) EnqueueEvent(topic, key string, event interface{}) error {
+	envelope, err := kafkaclient.NewEnvelope(r.db.Statement.Context, eventSource, topic, key, event)
+	if err != nil {
+		return err
+	}
+	return outbox.Enqueue(r.db, envelope)
+}
+
+func (r *productRepository) Transaction(fn func(tx ProductRepository) error) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&productRepository{db: tx.WithContext(kafkaclient.NewCorrelation(tx.Statement.Context))})
+	})
+}
+
 func (r *productRepository) Create(product *domain.Product) error {
 	return r.db.Create(product).Error
 }
@@ -2,8 +2,10 @@ package service
 
 import (
 	"errors"
+	"strconv"
 	"time"
 
+	"github.com/my-username/billion-user-app/pkg/jwtutils"
 	"github.com/my-username/billion-user-app/pkg/kafkaclient"
 	"github.com/my-username/billion-user-app/services/product-service/internal/domain"
 	"github.com/my-username/billion-user-app/services/product-service/internal/repository"
@@ -18,40 +20,42 @@ var (
 type ProductService interface {
 	CreateProduct(product *domain.Product) (*domain.Product, error)
 	GetProductByID(id uint64) (*domain.Product, error)
-	UpdateProduct(id uint64, updates *domain.Product, requesterID uint64) (*domain.Product, error)
-	DeleteProduct(id uint64, requesterID uint64) error
+	UpdateProduct(id uint64, updates *domain.Product, requester *jwtutils.Claims) (*domain.Product, error)
+	DeleteProduct(id uint64, requester *jwtutils.Claims) error
 	ListProducts(offset, limit int) ([]*domain.Product, error)
 	SearchProducts(query string, limit int) ([]*domain.Product, error)
 	GetProductsByCategory(category string, offset, limit int) ([]*domain.Product, error)
 }
 
 type productService struct {
-	repo        repository.ProductRepository
-	kafkaClient *kafkaclient.Client
+	repo repository.ProductRepository
 }
 
 // NewProductService creates a new product service
-func NewProductService(repo repository.ProductRepository, kafkaClient *kafkaclient.Client) ProductService {
+func NewProductService(repo repository.ProductRepository) ProductService {
 	return &productService{
-		repo:        repo,
-		kafkaClient: kafkaClient,
+		repo: repo,
 	}
 }
 
 func (s *productService) CreateProduct(product *domain.Product) (*domain.Product, error) {
-	if err := s.repo.Create(product); err != nil {
+	// The event is published by the outbox relay once the product is committed
+	err := s.repo.Transaction(func(tx repository.ProductRepository) error {
+		if err := tx.Create(product); err != nil {
+			return err
+		}
+		event := kafkaclient.ProductCreatedEvent{
+			ProductID: product.ID,
+			Name:      product.Name,
+			Price:     product.Price,
+			CreatedAt: product.CreatedAt.Format(time.RFC3339),
+		}
+		return tx.EnqueueEvent("product.created", strconv.FormatUint(product.ID, 10), event)
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	// Publish event
-	event := kafkaclient.ProductCreatedEvent{
-		ProductID: product.ID,
-		Name:      product.Name,
-		Price:     product.Price,
-		CreatedAt: product.CreatedAt.Format(time.RFC3339),
-	}
-	_ = s.kafkaClient.PublishEvent("product.created", event)
-
 	return product, nil
 }
 
@@ -59,14 +63,14 @@ func (s *productService) GetProductByID(id uint64) (*domain.Product, error) {
 	return s.repo.GetByID(id)
 }
 
-func (s *productService) UpdateProduct(id uint64, updates *domain.Product, requesterID uint64) (*domain.Product, error) {
+func (s *productService) UpdateProduct(id uint64, updates *domain.Product, requester *jwtutils.Claims) (*domain.Product, error) {
 	product, err := s.repo.GetByID(id)
 	if err != nil {
 		return nil, err
 	}
 
-	// Check authorization (only creator can update)
-	if product.CreatedBy != requesterID {
+	// Check authorization (only the creator or a product manager can update)
+	if !requester.CanActOn(product.CreatedBy, jwtutils.PermManageProducts) {
 		return nil, ErrUnauthorized
 	}
 
@@ -100,13 +104,13 @@ func (s *productService) UpdateProduct(id uint64, updates *domain.Product, reque
 	return product, nil
 }
 
-func (s *productService) DeleteProduct(id uint64, requesterID uint64) error {
+func (s *productService) DeleteProduct(id uint64, requester *jwtutils.Claims) error {
 	product, err := s.repo.GetByID(id)
 	if err != nil {
 		return err
 	}
 
-	if product.CreatedBy != requesterID {
+	if !requester.CanActOn(product.CreatedBy, jwtutils.PermManageProducts) {
 		return ErrUnauthorized
 	}
 
@@ -183,7 +183,7 @@ func (h *TaskHandler) UpdateTask(c *fiber.Ctx) error {
 		DueDate:     req.DueDate,
 	}
 
-	task, err := h.taskService.UpdateTask(id, updates, claims.UserID)
+	task, err := h.taskService.UpdateTask(id, updates, claims)
 	if err != nil {
 		if err == service.ErrTaskNotFound {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
@@ -219,7 +219,7 @@ func (h *TaskHandler) DeleteTask(c *fiber.Ctx) error {
 		})
 	}
 
-	if err := h.taskService.DeleteTask(id, claims.UserID); err != nil {
+	if err := h.taskService.DeleteTask(id, claims); err != nil {
 		if err == service.ErrTaskNotFound {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 				"error": "Task not found",
@@ -240,7 +240,8 @@ func (h *TaskHandler) DeleteTask(c *fiber.Ctx) error {
 	})
 }
 
-// JWTMiddleware validates JWT tokens
+// JWTMiddleware validates JWT tokens. Administrators impersonating a user
+// may read but not change anything.
 func JWTMiddleware(jwtManager *jwtutils.JWTManager) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		authHeader := c.Get("Authorization")
@@ -266,8 +267,29 @@ func JWTMiddleware(jwtManager *jwtutils.JWTManager) fiber.Handler {
 			})
 		}
 
+		if !claims.AllowsMethod(c.Method()) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not allowed while impersonating a user",
+			})
+		}
+
 		c.Locals("claims", claims)
 		c.Locals("user_id", claims.UserID)
 		return c.Next()
 	}
 }
+
+// RequireScope rejects scoped tokens, such as those of API keys, that do not
+// grant access to resource for the request's HTTP method. It must run after
+// JWTMiddleware.
+func RequireScope(resource string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, ok := c.Locals("claims").(*jwtutils.Claims)
+		if !ok || !claims.HasScope(jwtutils.ScopeForMethod(resource, c.Method())) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Insufficient scope",
+			})
+		}
+		return c.Next()
+	}
+}
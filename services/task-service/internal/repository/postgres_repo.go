@@ -3,10 +3,15 @@ package repository
 import (
 	"errors"
 
+	"github.com/my-username/billion-user-app/pkg/kafkaclient"
+	"github.com/my-username/billion-user-app/pkg/outbox"
 	"github.com/my-username/billion-user-app/services/task-service/internal/domain"
 	"gorm.io/gorm"
 )
 
+// eventSource identifies this service as the producer of its events
+const eventSource = "task-service"
+
 var (
 	ErrTaskNotFound = errors.New("task not found")
 )
@@ -19,6 +24,11 @@ type TaskRepository interface {
 	Update(task *domain.Task) error
 	Delete(id uint64) error
 	GetByStatus(userID uint64, status domain.TaskStatus, offset, limit int) ([]*domain.Task, error)
+	// EnqueueEvent adds an event to the outbox; key is the aggregate's ID
+	EnqueueEvent(topic, key string, event interface{}) error
+	// Transaction runs fn with a repository whose operations commit together
+	// and whose events share a correlation ID
+	Transaction(fn func(tx TaskRepository) error) error
 }
 
 type taskRepository struct {
@@ -30,6 +40,20 @@ func NewTaskRepository(db *gorm.DB) TaskRepository {
 	return &taskRepository{db: db}
 }
 
+func (r *taskRepository) EnqueueEvent(topic, key string, event interface{}) error {
+	envelope, err := kafkaclient.NewEnvelope(r.db.Statement.Context, eventSource, topic, key, event)
+	if err != nil {
+		return err
+	}
+	return outbox.Enqueue(r.db, envelope)
+}
+
+func (r *taskRepository) Transaction(fn func(tx TaskRepository) error) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&taskRepository{db: tx.WithContext(kafkaclient.NewCorrelation(tx.Statement.Context))})
+	})
+}
+
 func (r *taskRepository) Create(task *domain.Task) error {
 	return r.db.Create(task).Error
 }
@@ -2,8 +2,10 @@ package service
 
 import (
 	"errors"
+	"strconv"
 	"time"
 
+	"github.com/my-username/billion-user-app/pkg/jwtutils"
 	"github.com/my-username/billion-user-app/pkg/kafkaclient"
 	"github.com/my-username/billion-user-app/services/task-service/internal/domain"
 	"github.com/my-username/billion-user-app/services/task-service/internal/repository"
@@ -19,21 +21,19 @@ type TaskService interface {
 	CreateTask(task *domain.Task) (*domain.Task, error)
 	GetTaskByID(id uint64) (*domain.Task, error)
 	GetTasksByUserID(userID uint64, offset, limit int) ([]*domain.Task, error)
-	UpdateTask(id uint64, updates *domain.Task, requesterID uint64) (*domain.Task, error)
-	DeleteTask(id uint64, requesterID uint64) error
+	UpdateTask(id uint64, updates *domain.Task, requester *jwtutils.Claims) (*domain.Task, error)
+	DeleteTask(id uint64, requester *jwtutils.Claims) error
 	GetTasksByStatus(userID uint64, status domain.TaskStatus, offset, limit int) ([]*domain.Task, error)
 }
 
 type taskService struct {
-	repo        repository.TaskRepository
-	kafkaClient *kafkaclient.Client
+	repo repository.TaskRepository
 }
 
 // NewTaskService creates a new task service
-func NewTaskService(repo repository.TaskRepository, kafkaClient *kafkaclient.Client) TaskService {
+func NewTaskService(repo repository.TaskRepository) TaskService {
 	return &taskService{
-		repo:        repo,
-		kafkaClient: kafkaClient,
+		repo: repo,
 	}
 }
 
@@ -42,20 +42,24 @@ func (s *taskService) CreateTask(task *domain.Task) (*domain.Task, error) {
 		task.Status = domain.TaskStatusPending
 	}
 
-	if err := s.repo.Create(task); err != nil {
+	// The event is published by the outbox relay once the task is committed
+	err := s.repo.Transaction(func(tx repository.TaskRepository) error {
+		if err := tx.Create(task); err != nil {
+			return err
+		}
+		event := kafkaclient.TaskCreatedEvent{
+			TaskID:    task.ID,
+			UserID:    task.UserID,
+			Title:     task.Title,
+			Status:    string(task.Status),
+			CreatedAt: task.CreatedAt.Format(time.RFC3339),
+		}
+		return tx.EnqueueEvent("task.created", strconv.FormatUint(task.ID, 10), event)
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	// Publish event
-	event := kafkaclient.TaskCreatedEvent{
-		TaskID:    task.ID,
-		UserID:    task.UserID,
-		Title:     task.Title,
-		Status:    string(task.Status),
-		CreatedAt: task.CreatedAt.Format(time.RFC3339),
-	}
-	_ = s.kafkaClient.PublishEvent("task.created", event)
-
 	return task, nil
 }
 
@@ -67,14 +71,14 @@ func (s *taskService) GetTasksByUserID(userID uint64, offset, limit int) ([]*dom
 	return s.repo.GetByUserID(userID, offset, limit)
 }
 
-func (s *taskService) UpdateTask(id uint64, updates *domain.Task, requesterID uint64) (*domain.Task, error) {
+func (s *taskService) UpdateTask(id uint64, updates *domain.Task, requester *jwtutils.Claims) (*domain.Task, error) {
 	task, err := s.repo.GetByID(id)
 	if err != nil {
 		return nil, err
 	}
 
-	// Check authorization
-	if task.UserID != requesterID {
+	// Check authorization (owner or a task manager)
+	if !requester.CanActOn(task.UserID, jwtutils.PermManageTasks) {
 		return nil, ErrUnauthorized
 	}
 
@@ -102,13 +106,13 @@ func (s *taskService) UpdateTask(id uint64, updates *domain.Task, requesterID ui
 	return task, nil
 }
 
-func (s *taskService) DeleteTask(id uint64, requesterID uint64) error {
+func (s *taskService) DeleteTask(id uint64, requester *jwtutils.Claims) error {
 	task, err := s.repo.GetByID(id)
 	if err != nil {
 		return err
 	}
 
-	if task.UserID != requesterID {
+	if !requester.CanActOn(task.UserID, jwtutils.PermManageTasks) {
 		return ErrUnauthorized
 	}
 
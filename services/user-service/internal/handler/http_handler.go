@@ -143,7 +143,7 @@ func (h *UserHandler) UpdateUser(c *fiber.Ctx) error {
 		Metadata:    req.Metadata,
 	}
 
-	user, err := h.userService.UpdateUser(id, updates, claims.UserID)
+	user, err := h.userService.UpdateUser(id, updates, claims)
 	if err != nil {
 		if err == service.ErrUserNotFound {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
@@ -180,7 +180,7 @@ func (h *UserHandler) DeleteUser(c *fiber.Ctx) error {
 		})
 	}
 
-	if err := h.userService.DeleteUser(id, claims.UserID); err != nil {
+	if err := h.userService.DeleteUser(id, claims); err != nil {
 		if err == service.ErrUserNotFound {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 				"error": "User not found",
@@ -250,7 +250,8 @@ func (h *UserHandler) SearchUsers(c *fiber.Ctx) error {
 	})
 }
 
-// JWTMiddleware validates JWT tokens
+// JWTMiddleware validates JWT tokens. Administrators impersonating a user
+// may read but not change anything.
 func JWTMiddleware(jwtManager *jwtutils.JWTManager) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		authHeader := c.Get("Authorization")
@@ -276,8 +277,29 @@ func JWTMiddleware(jwtManager *jwtutils.JWTManager) fiber.Handler {
 			})
 		}
 
+		if !claims.AllowsMethod(c.Method()) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Not allowed while impersonating a user",
+			})
+		}
+
 		c.Locals("claims", claims)
 		c.Locals("user_id", claims.UserID)
 		return c.Next()
 	}
 }
+
+// RequireScope rejects scoped tokens, such as those of API keys, that do not
+// grant access to resource for the request's HTTP method. It must run after
+// JWTMiddleware.
+func RequireScope(resource string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, ok := c.Locals("claims").(*jwtutils.Claims)
+		if !ok || !claims.HasScope(jwtutils.ScopeForMethod(resource, c.Method())) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Insufficient scope",
+			})
+		}
+		return c.Next()
+	}
+}
@@ -3,10 +3,15 @@ package repository
 import (
 	"errors"
 
+	"github.com/my-username/billion-user-app/pkg/kafkaclient"
+	"github.com/my-username/billion-user-app/pkg/outbox"
 	"github.com/my-username/billion-user-app/services/user-service/internal/domain"
 	"gorm.io/gorm"
 )
 
+// eventSource identifies this service as the producer of its events
+const eventSource = "user-service"
+
 var (
 	ErrUserNotFound      = errors.New("user not found")
 	ErrUserAlreadyExists = errors.New("user already exists")
@@ -22,6 +27,11 @@ type UserRepository interface {
 	Delete(id uint64) error
 	List(offset, limit int) ([]*domain.User, error)
 	Search(query string, limit int) ([]*domain.User, error)
+	// EnqueueEvent adds an event to the outbox; key is the aggregate's ID
+	EnqueueEvent(topic, key string, event interface{}) error
+	// Transaction runs fn with a repository whose operations commit together
+	// and whose events share a correlation ID
+	Transaction(fn func(tx UserRepository) error) error
 }
 
 type userRepository struct {
@@ -33,6 +43,20 @@ func NewUserRepository(db *gorm.DB) UserRepository {
 	return &userRepository{db: db}
 }
 
+func (r *userRepository) EnqueueEvent(topic, key string, event interface{}) error {
+	envelope, err := kafkaclient.NewEnvelope(r.db.Statement.Context, eventSource, topic, key, event)
+	if err != nil {
+		return err
+	}
+	return outbox.Enqueue(r.db, envelope)
+}
+
+func (r *userRepository) Transaction(fn func(tx UserRepository) error) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&userRepository{db: tx.WithContext(kafkaclient.NewCorrelation(tx.Statement.Context))})
+	})
+}
+
 func (r *userRepository) Create(user *domain.User) error {
 	if err := r.db.Create(user).Error; err != nil {
 		if errors.Is(err, gorm.ErrDuplicatedKey) {
@@ -2,8 +2,10 @@ package service
 
 import (
 	"errors"
+	"strconv"
 	"time"
 
+	"github.com/my-username/billion-user-app/pkg/jwtutils"
 	"github.com/my-username/billion-user-app/pkg/kafkaclient"
 	"github.com/my-username/billion-user-app/services/user-service/internal/domain"
 	"github.com/my-username/billion-user-app/services/user-service/internal/repository"
@@ -20,22 +22,20 @@ type UserService interface {
 	CreateUser(user *domain.User) (*domain.User, error)
 	GetUserByID(id uint64) (*domain.User, error)
 	GetUserByUsername(username string) (*domain.User, error)
-	UpdateUser(id uint64, updates *domain.User, requesterID uint64) (*domain.User, error)
-	DeleteUser(id uint64, requesterID uint64) error
+	UpdateUser(id uint64, updates *domain.User, requester *jwtutils.Claims) (*domain.User, error)
+	DeleteUser(id uint64, requester *jwtutils.Claims) error
 	ListUsers(offset, limit int) ([]*domain.User, error)
 	SearchUsers(query string, limit int) ([]*domain.User, error)
 }
 
 type userService struct {
-	repo        repository.UserRepository
-	kafkaClient *kafkaclient.Client
+	repo repository.UserRepository
 }
 
 // NewUserService creates a new user service
-func NewUserService(repo repository.UserRepository, kafkaClient *kafkaclient.Client) UserService {
+func NewUserService(repo repository.UserRepository) UserService {
 	return &userService{
-		repo:        repo,
-		kafkaClient: kafkaClient,
+		repo: repo,
 	}
 }
 
@@ -57,19 +57,23 @@ func (s *userService) CreateUser(user *domain.User) (*domain.User, error) {
 		return nil, err
 	}
 
-	if err := s.repo.Create(user); err != nil {
+	// The event is published by the outbox relay once the user is committed
+	err = s.repo.Transaction(func(tx repository.UserRepository) error {
+		if err := tx.Create(user); err != nil {
+			return err
+		}
+		event := kafkaclient.UserCreatedEvent{
+			UserID:    user.ID,
+			Email:     user.Email,
+			Username:  user.Username,
+			CreatedAt: user.CreatedAt.Format(time.RFC3339),
+		}
+		return tx.EnqueueEvent("user.created", userKey(user.ID), event)
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	// Publish event
-	event := kafkaclient.UserCreatedEvent{
-		UserID:    user.ID,
-		Email:     user.Email,
-		Username:  user.Username,
-		CreatedAt: user.CreatedAt.Format(time.RFC3339),
-	}
-	_ = s.kafkaClient.PublishEvent("user.created", event)
-
 	return user, nil
 }
 
@@ -81,9 +85,10 @@ func (s *userService) GetUserByUsername(username string) (*domain.User, error) {
 	return s.repo.GetByUsername(username)
 }
 
-func (s *userService) UpdateUser(id uint64, updates *domain.User, requesterID uint64) (*domain.User, error) {
-	// Check authorization (users can only update their own profile)
-	if id != requesterID {
+func (s *userService) UpdateUser(id uint64, updates *domain.User, requester *jwtutils.Claims) (*domain.User, error) {
+	// Check authorization (users can only update their own profile unless
+	// their role allows managing other users)
+	if !requester.CanActOn(id, jwtutils.PermManageUsers) {
 		return nil, ErrUnauthorized
 	}
 
@@ -109,24 +114,27 @@ func (s *userService) UpdateUser(id uint64, updates *domain.User, requesterID ui
 		user.Metadata = updates.Metadata
 	}
 
-	if err := s.repo.Update(user); err != nil {
+	err = s.repo.Transaction(func(tx repository.UserRepository) error {
+		if err := tx.Update(user); err != nil {
+			return err
+		}
+		event := kafkaclient.UserUpdatedEvent{
+			UserID:    user.ID,
+			Email:     user.Email,
+			Username:  user.Username,
+			UpdatedAt: user.UpdatedAt.Format(time.RFC3339),
+		}
+		return tx.EnqueueEvent("user.updated", userKey(user.ID), event)
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	// Publish event
-	event := kafkaclient.UserUpdatedEvent{
-		UserID:    user.ID,
-		Email:     user.Email,
-		Username:  user.Username,
-		UpdatedAt: user.UpdatedAt.Format(time.RFC3339),
-	}
-	_ = s.kafkaClient.PublishEvent("user.updated", event)
-
 	return user, nil
 }
 
-func (s *userService) DeleteUser(id uint64, requesterID uint64) error {
-	if id != requesterID {
+func (s *userService) DeleteUser(id uint64, requester *jwtutils.Claims) error {
+	if !requester.CanActOn(id, jwtutils.PermDeleteUsers) {
 		return ErrUnauthorized
 	}
 	return s.repo.Delete(id)
@@ -140,4 +148,9 @@ func (s *userService) SearchUsers(query string, limit int) ([]*domain.User, erro
 	return s.repo.Search(query, limit)
 }
 
+// userKey is the outbox key of a user's events, keeping them in order
+func userKey(id uint64) string {
+	return strconv.FormatUint(id, 10)
+}
+
 